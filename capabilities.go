@@ -0,0 +1,177 @@
+package helmclient
+
+import (
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"io/ioutil"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"time"
+)
+
+type capabilitiesClient interface {
+	Capture() (*CapabilitiesSnapshot, error)
+	Save(path string) error
+}
+
+type capabilitiesClientImpl struct {
+	env *helmEnv
+}
+
+// CapabilitiesSnapshot records what a cluster serves, so that charts can be
+// rendered for it later without access to the cluster.
+type CapabilitiesSnapshot struct {
+	KubeVersion CapabilitiesKubeVersion `json:"kubeVersion"`
+	// APIVersions lists group/version and group/version/Kind entries, the same
+	// way .Capabilities.APIVersions does for a connected client.
+	APIVersions []string               `json:"apiVersions"`
+	Resources   []CapabilitiesResource `json:"resources"`
+	CapturedAt  time.Time              `json:"capturedAt"`
+}
+
+type CapabilitiesKubeVersion struct {
+	Version string `json:"version"`
+	Major   string `json:"major"`
+	Minor   string `json:"minor"`
+}
+
+// CapabilitiesResource is an API resource served by the cluster.
+type CapabilitiesResource struct {
+	GroupVersion string `json:"groupVersion"`
+	Kind         string `json:"kind"`
+	Name         string `json:"name"`
+	Namespaced   bool   `json:"namespaced"`
+}
+
+func newCapabilitiesClient(env *helmEnv) (*capabilitiesClientImpl, error) {
+	return &capabilitiesClientImpl{
+		env: env,
+	}, nil
+}
+
+// Capture asks the cluster of the client for its version and API resources.
+func (c *capabilitiesClientImpl) Capture() (*CapabilitiesSnapshot, error) {
+	dc, err := c.env.clientGetter.ToDiscoveryClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get Kubernetes discovery client")
+	}
+	kubeVersion, err := dc.ServerVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get server version from Kubernetes")
+	}
+	snapshot := &CapabilitiesSnapshot{
+		KubeVersion: CapabilitiesKubeVersion{
+			Version: kubeVersion.GitVersion,
+			Major:   kubeVersion.Major,
+			Minor:   kubeVersion.Minor,
+		},
+		CapturedAt: time.Now().UTC(),
+	}
+
+	// An API service which is registered but unimplemented fails discovery of
+	// its group only, the other groups are still returned. See helm issue 6361.
+	_, resourceLists, err := dc.ServerGroupsAndResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, errors.Wrap(err, "could not get apiVersions from Kubernetes")
+		}
+		warning("The Kubernetes server has an orphaned API service. Server reports: %s", err)
+	}
+	apiVersions, err := action.GetVersionSet(dc)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, errors.Wrap(err, "could not get apiVersions from Kubernetes")
+	}
+	snapshot.APIVersions = apiVersions
+	sort.Strings(snapshot.APIVersions)
+
+	for _, list := range resourceLists {
+		for _, r := range list.APIResources {
+			snapshot.Resources = append(snapshot.Resources, CapabilitiesResource{
+				GroupVersion: list.GroupVersion,
+				Kind:         r.Kind,
+				Name:         r.Name,
+				Namespaced:   r.Namespaced,
+			})
+		}
+	}
+	return snapshot, nil
+}
+
+// Save captures the capabilities and writes them to path as YAML.
+func (c *capabilitiesClientImpl) Save(path string) error {
+	snapshot, err := c.Capture()
+	if err != nil {
+		return err
+	}
+	return snapshot.Save(path)
+}
+
+func (s *CapabilitiesSnapshot) Save(path string) error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LoadCapabilitiesSnapshot reads a snapshot written by Save.
+func LoadCapabilitiesSnapshot(path string) (*CapabilitiesSnapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &CapabilitiesSnapshot{}
+	if err := yaml.Unmarshal(data, snapshot); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse capabilities snapshot %s", path)
+	}
+	if snapshot.KubeVersion.Version == "" {
+		return nil, errors.Errorf("capabilities snapshot %s has no kubeVersion", path)
+	}
+	return snapshot, nil
+}
+
+// capabilities converts the snapshot for rendering. kubeVersion and extraAPIs
+// take precedence over the snapshot as they do over a connected cluster.
+func (s *CapabilitiesSnapshot) capabilities(kubeVersion *chartutil.KubeVersion, extraAPIs []string) *chartutil.Capabilities {
+	caps := &chartutil.Capabilities{
+		KubeVersion: chartutil.KubeVersion{
+			Version: s.KubeVersion.Version,
+			Major:   s.KubeVersion.Major,
+			Minor:   s.KubeVersion.Minor,
+		},
+		APIVersions: append(append(chartutil.VersionSet{}, s.APIVersions...), extraAPIs...),
+		HelmVersion: chartutil.DefaultCapabilities.HelmVersion,
+	}
+	if kubeVersion != nil {
+		caps.KubeVersion = *kubeVersion
+	}
+	return caps
+}
+
+// loadCapabilities loads the snapshot at path, if any.
+func loadCapabilities(path string, kubeVersion *chartutil.KubeVersion, extraAPIs []string) (*chartutil.Capabilities, error) {
+	if path == "" {
+		return nil, nil
+	}
+	snapshot, err := LoadCapabilitiesSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.capabilities(kubeVersion, extraAPIs), nil
+}
+
+// useOfflineCapabilities points cfg at caps and at fake cluster clients, so a
+// dry run renders as if connected to the captured cluster without reaching it.
+// The fake clients accept any manifest, callers rule out validation.
+func useOfflineCapabilities(cfg *action.Configuration, caps *chartutil.Capabilities, namespace string) {
+	cfg.Capabilities = caps
+	cfg.KubeClient = &kubefake.PrintingKubeClient{Out: ioutil.Discard}
+	mem := driver.NewMemory()
+	mem.SetNamespace(namespace)
+	cfg.Releases = storage.Init(mem)
+}
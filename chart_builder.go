@@ -0,0 +1,216 @@
+package helmclient
+
+import (
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"path"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	buildChartDefaultDescription = "A Helm chart for Kubernetes"
+	buildChartDefaultType        = "application"
+	buildChartDefaultAppVersion  = ""
+	buildChartDefaultKubeVersion = ""
+	buildChartHelpersFile        = "templates/_helpers.tpl"
+)
+
+type BuildChartOption struct {
+	f func(o *buildChartOptions)
+}
+
+type buildChartOptions struct {
+	metadata  *chart.Metadata
+	values    map[string]interface{}
+	rawValues []byte
+	schema    []byte
+	files     []*loader.BufferedFile
+	subcharts []*chart.Chart
+}
+
+func (o *buildChartOptions) apply(opts []BuildChartOption) {
+	for _, op := range opts {
+		op.f(o)
+	}
+}
+
+func newBuildChartOptions(name string, version string, opts []BuildChartOption) *buildChartOptions {
+	options := &buildChartOptions{
+		metadata: &chart.Metadata{
+			APIVersion:  chart.APIVersionV2,
+			Name:        name,
+			Version:     version,
+			Description: buildChartDefaultDescription,
+			Type:        buildChartDefaultType,
+			AppVersion:  buildChartDefaultAppVersion,
+			KubeVersion: buildChartDefaultKubeVersion,
+		},
+	}
+	options.apply(opts)
+	return options
+}
+
+func BuildChartWithDescription(description string) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.Description = description
+	}}
+}
+
+func BuildChartWithAppVersion(appVersion string) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.AppVersion = appVersion
+	}}
+}
+
+// BuildChartWithType sets the chart type, application or library.
+func BuildChartWithType(chartType string) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.Type = chartType
+	}}
+}
+
+func BuildChartWithKubeVersion(kubeVersion string) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.KubeVersion = kubeVersion
+	}}
+}
+
+func BuildChartWithHome(home string) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.Home = home
+	}}
+}
+
+func BuildChartWithIcon(icon string) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.Icon = icon
+	}}
+}
+
+func BuildChartWithSources(sources []string) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.Sources = sources
+	}}
+}
+
+func BuildChartWithKeywords(keywords []string) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.Keywords = keywords
+	}}
+}
+
+func BuildChartWithMaintainers(maintainers []*chart.Maintainer) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.Maintainers = maintainers
+	}}
+}
+
+func BuildChartWithAnnotations(annotations map[string]string) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.Annotations = annotations
+	}}
+}
+
+func BuildChartWithDeprecated(deprecated bool) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.Deprecated = deprecated
+	}}
+}
+
+// BuildChartWithTemplate adds a file under templates/, name is relative to it.
+func BuildChartWithTemplate(name string, data []byte) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.files = append(o.files, &loader.BufferedFile{Name: path.Join(chartutil.TemplatesDir, name), Data: data})
+	}}
+}
+
+// BuildChartWithHelpers sets templates/_helpers.tpl.
+func BuildChartWithHelpers(data []byte) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.files = append(o.files, &loader.BufferedFile{Name: buildChartHelpersFile, Data: data})
+	}}
+}
+
+// BuildChartWithValues sets the default values. It is marshaled to values.yaml
+// unless BuildChartWithRawValues is also given.
+func BuildChartWithValues(values map[string]interface{}) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.values = values
+	}}
+}
+
+// BuildChartWithRawValues sets values.yaml verbatim, keeping its comments.
+func BuildChartWithRawValues(data []byte) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.rawValues = data
+	}}
+}
+
+func BuildChartWithSchema(schema []byte) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.schema = schema
+	}}
+}
+
+// BuildChartWithCRD adds a file under crds/, name is relative to it.
+func BuildChartWithCRD(name string, data []byte) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.files = append(o.files, &loader.BufferedFile{Name: path.Join("crds", name), Data: data})
+	}}
+}
+
+// BuildChartWithFile adds any other file, name is relative to the chart root.
+func BuildChartWithFile(name string, data []byte) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.files = append(o.files, &loader.BufferedFile{Name: name, Data: data})
+	}}
+}
+
+// BuildChartWithDependency declares a dependency in Chart.yaml. If subchart is
+// not nil it is vendored into charts/ as well, otherwise the dependency has to
+// be fetched before the chart can be installed.
+func BuildChartWithDependency(dependency *chart.Dependency, subchart *chart.Chart) BuildChartOption {
+	return BuildChartOption{f: func(o *buildChartOptions) {
+		o.metadata.Dependencies = append(o.metadata.Dependencies, dependency)
+		if subchart != nil {
+			o.subcharts = append(o.subcharts, subchart)
+		}
+	}}
+}
+
+// BuildChart assembles a chart from Go. The result is loaded the same way as a
+// chart read from the disk, so it can be passed to ChartFromObject or saved
+// with chartutil.SaveDir and chartutil.Save.
+func BuildChart(name string, version string, opts []BuildChartOption) (*chart.Chart, error) {
+	o := newBuildChartOptions(name, version, opts)
+	meta, err := yaml.Marshal(o.metadata)
+	if err != nil {
+		return nil, err
+	}
+	files := []*loader.BufferedFile{{Name: chartutil.ChartfileName, Data: meta}}
+
+	values := o.rawValues
+	if values == nil && o.values != nil {
+		if values, err = yaml.Marshal(o.values); err != nil {
+			return nil, errors.Wrap(err, "cannot marshal values")
+		}
+	}
+	if values != nil {
+		files = append(files, &loader.BufferedFile{Name: chartutil.ValuesfileName, Data: values})
+	}
+	if o.schema != nil {
+		files = append(files, &loader.BufferedFile{Name: chartutil.SchemafileName, Data: o.schema})
+	}
+	files = append(files, o.files...)
+
+	ch, err := loader.LoadFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range o.subcharts {
+		ch.AddDependency(sub)
+	}
+	return ch, nil
+}
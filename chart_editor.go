@@ -0,0 +1,653 @@
+package helmclient
+
+import (
+	"bytes"
+	"github.com/Masterminds/semver/v3"
+	"github.com/outgnaY/helm-go-client/internal/fileutil"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	k8syaml "sigs.k8s.io/yaml"
+	"strings"
+)
+
+// preferred key order for mappings added by the editor, remaining keys follow
+// in alphabetical order
+var chartEditorKeyOrder = []string{"name", "email", "url", "version", "repository", "alias", "condition"}
+
+type EditChartOption struct {
+	f func(o *editChartOptions)
+}
+
+type editChartOptions struct {
+	edits []func(root *yaml.Node) error
+}
+
+func (o *editChartOptions) apply(opts []EditChartOption) {
+	for _, op := range opts {
+		op.f(o)
+	}
+}
+
+func newEditChartOptions(opts []EditChartOption) *editChartOptions {
+	options := &editChartOptions{}
+	options.apply(opts)
+	return options
+}
+
+func (o *editChartOptions) add(edit func(root *yaml.Node) error) {
+	o.edits = append(o.edits, edit)
+}
+
+func EditChartWithVersion(version string) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			if _, err := semver.StrictNewVersion(version); err != nil {
+				return errors.Wrapf(err, "invalid chart version %q", version)
+			}
+			setScalar(root, "version", version)
+			return nil
+		})
+	}}
+}
+
+// EditChartWithBumpVersion increments one part of the chart version, part is
+// one of major, minor or patch. Pre-release and build metadata are dropped.
+func EditChartWithBumpVersion(part string) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			node := mappingValue(root, "version")
+			if node == nil {
+				return errors.New("chart has no version to bump")
+			}
+			v, err := semver.NewVersion(node.Value)
+			if err != nil {
+				return errors.Wrapf(err, "invalid chart version %q", node.Value)
+			}
+			var next semver.Version
+			switch part {
+			case "major":
+				next = v.IncMajor()
+			case "minor":
+				next = v.IncMinor()
+			case "patch":
+				next = v.IncPatch()
+			default:
+				return errors.Errorf("unknown version part %q", part)
+			}
+			setScalar(root, "version", next.String())
+			return nil
+		})
+	}}
+}
+
+func EditChartWithAppVersion(appVersion string) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			setScalar(root, "appVersion", appVersion)
+			return nil
+		})
+	}}
+}
+
+func EditChartWithDescription(description string) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			setScalar(root, "description", description)
+			return nil
+		})
+	}}
+}
+
+// EditChartWithType sets the chart type, application or library.
+func EditChartWithType(chartType string) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			setScalar(root, "type", chartType)
+			return nil
+		})
+	}}
+}
+
+func EditChartWithKubeVersion(kubeVersion string) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			setScalar(root, "kubeVersion", kubeVersion)
+			return nil
+		})
+	}}
+}
+
+func EditChartWithAnnotation(key string, value string) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			annotations := mappingValue(root, "annotations")
+			if annotations == nil || annotations.Kind != yaml.MappingNode {
+				annotations = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				setNode(root, "annotations", annotations)
+			}
+			setScalar(annotations, key, value)
+			return nil
+		})
+	}}
+}
+
+func EditChartWithoutAnnotation(key string) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			if annotations := mappingValue(root, "annotations"); annotations != nil {
+				removeKey(annotations, key)
+				if len(annotations.Content) == 0 {
+					removeKey(root, "annotations")
+				}
+			}
+			return nil
+		})
+	}}
+}
+
+// EditChartWithMaintainers replaces the list of maintainers.
+func EditChartWithMaintainers(maintainers []*chart.Maintainer) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			if len(maintainers) == 0 {
+				removeKey(root, "maintainers")
+				return nil
+			}
+			node, err := toNode(maintainers)
+			if err != nil {
+				return err
+			}
+			setNode(root, "maintainers", node)
+			return nil
+		})
+	}}
+}
+
+// EditChartWithDependency adds a dependency, or replaces the dependency with
+// the same name and alias in place.
+func EditChartWithDependency(dependency *chart.Dependency) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			node, err := toNode(dependency)
+			if err != nil {
+				return err
+			}
+			deps := mappingValue(root, "dependencies")
+			if deps == nil || deps.Kind != yaml.SequenceNode {
+				deps = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+				setNode(root, "dependencies", deps)
+			}
+			if i := findDependency(deps, dependency.Name, dependency.Alias); i >= 0 {
+				// keep the comments attached to the replaced entry
+				node.HeadComment = deps.Content[i].HeadComment
+				node.LineComment = deps.Content[i].LineComment
+				node.FootComment = deps.Content[i].FootComment
+				deps.Content[i] = node
+				return nil
+			}
+			deps.Content = append(deps.Content, node)
+			return nil
+		})
+	}}
+}
+
+// EditChartWithoutDependency removes every dependency with the given name.
+func EditChartWithoutDependency(name string) EditChartOption {
+	return EditChartOption{f: func(o *editChartOptions) {
+		o.add(func(root *yaml.Node) error {
+			deps := mappingValue(root, "dependencies")
+			if deps == nil || findDependency(deps, name, "*") < 0 {
+				return errors.Errorf("chart has no dependency named %q", name)
+			}
+			content := deps.Content[:0]
+			for _, d := range deps.Content {
+				if n := mappingValue(d, "name"); n == nil || n.Value != name {
+					content = append(content, d)
+				}
+			}
+			deps.Content = content
+			if len(deps.Content) == 0 {
+				removeKey(root, "dependencies")
+			}
+			return nil
+		})
+	}}
+}
+
+// EditChart changes Chart.yaml of the chart directory at path. Only the edited
+// entries are written again, every other line, with its comments, blank lines,
+// indentation and quoting, is kept byte for byte. Entries added to an indented
+// list use the indentation of the entries already there. The file is only
+// written if the result is valid chart metadata.
+func EditChart(path string, opts []EditChartOption) error {
+	o := newEditChartOptions(opts)
+	file := filepath.Join(path, chartutil.ChartfileName)
+	if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+		file = path
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var orig, doc yaml.Node
+	if err := yaml.Unmarshal(data, &orig); err != nil {
+		return errors.Wrapf(err, "cannot parse %s", file)
+	}
+	if len(orig.Content) == 0 || orig.Content[0].Kind != yaml.MappingNode {
+		return errors.Errorf("%s is not a yaml mapping", file)
+	}
+	// edits are applied to a second copy, the untouched one tells which
+	// entries changed and where they are in the file
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return errors.Wrapf(err, "cannot parse %s", file)
+	}
+	for _, edit := range o.edits {
+		if err := edit(doc.Content[0]); err != nil {
+			return err
+		}
+	}
+
+	var out []byte
+	if isBlockMapping(orig.Content[0]) {
+		s := newChartFileSplicer(data)
+		lines, err := s.mapping(orig.Content[0], doc.Content[0], 0, len(s.lines))
+		if err != nil {
+			return err
+		}
+		out = []byte(strings.Join(lines, ""))
+		if !s.trailingNewline {
+			out = bytes.TrimSuffix(out, []byte("\n"))
+		}
+	} else {
+		// a flow mapping has no lines to keep
+		lines, err := encodeLines(&doc)
+		if err != nil {
+			return err
+		}
+		out = []byte(strings.Join(lines, ""))
+	}
+
+	md := new(chart.Metadata)
+	if err := k8syaml.Unmarshal(out, md); err != nil {
+		return err
+	}
+	if err := md.Validate(); err != nil {
+		return err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	return fileutil.AtomicWriteFile(file, bytes.NewReader(out), fi.Mode())
+}
+
+// chartFileSplicer builds the edited Chart.yaml out of the lines of the
+// original file, only the entries that differ are encoded again.
+type chartFileSplicer struct {
+	// lines of the original file, each one ends with a newline
+	lines           []string
+	trailingNewline bool
+}
+
+func newChartFileSplicer(data []byte) *chartFileSplicer {
+	s := &chartFileSplicer{trailingNewline: len(data) == 0 || bytes.HasSuffix(data, []byte("\n"))}
+	if len(data) == 0 {
+		return s
+	}
+	if !s.trailingNewline {
+		data = append(data, '\n')
+	}
+	s.lines = strings.SplitAfter(string(data), "\n")
+	s.lines = s.lines[:len(s.lines)-1]
+	return s
+}
+
+// leadStart returns the first of the blank and comment lines right in front of
+// line i, not going back further than lower. They move along with the entry
+// on line i.
+func (s *chartFileSplicer) leadStart(i int, lower int) int {
+	for i > lower {
+		line := strings.TrimSpace(s.lines[i-1])
+		if line != "" && !strings.HasPrefix(line, "#") {
+			break
+		}
+		i--
+	}
+	return i
+}
+
+// mapping returns the lines for edited, orig is the same block mapping before
+// the edits and takes the lines from..end of the file.
+func (s *chartFileSplicer) mapping(orig *yaml.Node, edited *yaml.Node, from int, end int) ([]string, error) {
+	n := len(orig.Content) / 2
+	starts := make([]int, n+1)
+	lower := from
+	for i := 0; i < n; i++ {
+		key := orig.Content[2*i].Line - 1
+		starts[i] = s.leadStart(key, lower)
+		lower = key + 1
+	}
+	starts[n] = end
+	// new keys line up with the existing ones
+	indent := strings.Repeat(" ", orig.Content[0].Column-1)
+
+	out := append([]string{}, s.lines[from:starts[0]]...)
+	for i := 0; i+1 < len(edited.Content); i += 2 {
+		key, value := edited.Content[i], edited.Content[i+1]
+		j := -1
+		for k := 0; k < n; k++ {
+			if orig.Content[2*k].Value == key.Value {
+				j = k
+				break
+			}
+		}
+		if j < 0 {
+			lines, err := renderEntry(key, value, indent)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, lines...)
+			continue
+		}
+		origKey, origValue := orig.Content[2*j], orig.Content[2*j+1]
+		start, stop, line := starts[j], starts[j+1], origKey.Line-1
+		same, err := sameNode(origValue, value)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case same:
+			out = append(out, s.lines[start:stop]...)
+		case origValue.Line > origKey.Line && isBlockMapping(origValue) && isBlockMapping(value):
+			lines, err := s.mapping(origValue, value, line+1, stop)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s.lines[start:line+1]...)
+			out = append(out, lines...)
+		case origValue.Line > origKey.Line && s.isBlockSequence(origValue) && value.Kind == yaml.SequenceNode && len(value.Content) > 0:
+			lines, err := s.sequence(origValue, value, line+1, stop)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s.lines[start:line+1]...)
+			out = append(out, lines...)
+		default:
+			lines, err := renderEntry(key, value, s.lines[line][:origKey.Column-1])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s.lines[start:line]...)
+			out = append(out, lines...)
+		}
+	}
+	return out, nil
+}
+
+// sequence returns the lines for edited, orig is the same block sequence
+// before the edits and takes the lines from..end of the file. Items are kept
+// as they are when unchanged, an item replaced in place is merged entry by
+// entry.
+func (s *chartFileSplicer) sequence(orig *yaml.Node, edited *yaml.Node, from int, end int) ([]string, error) {
+	n := len(orig.Content)
+	starts := make([]int, n+1)
+	lower := from
+	for i, item := range orig.Content {
+		starts[i] = s.leadStart(item.Line-1, lower)
+		lower = item.Line
+	}
+	starts[n] = end
+	first := s.lines[orig.Content[0].Line-1]
+	indent := first[:strings.Index(first, "-")]
+
+	// unchanged items first, preferring the one at the same position
+	matches := make([]int, len(edited.Content))
+	used := make([]bool, n)
+	for i, item := range edited.Content {
+		matches[i] = -1
+		for _, j := range preferIndex(i, n) {
+			if used[j] {
+				continue
+			}
+			same, err := sameNode(orig.Content[j], item)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				matches[i] = j
+				used[j] = true
+				break
+			}
+		}
+	}
+
+	out := append([]string{}, s.lines[from:starts[0]]...)
+	for i, item := range edited.Content {
+		if j := matches[i]; j >= 0 {
+			out = append(out, s.lines[starts[j]:starts[j+1]]...)
+			continue
+		}
+		if i < n && !used[i] && isBlockMapping(orig.Content[i]) && isBlockMapping(item) &&
+			orig.Content[i].Content[0].Value == item.Content[0].Value {
+			used[i] = true
+			lines, err := s.mapping(orig.Content[i], item, starts[i], starts[i+1])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, lines...)
+			continue
+		}
+		lines, err := renderItem(item, indent)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, lines...)
+	}
+	return out, nil
+}
+
+// isBlockSequence reports whether node is a non empty sequence with every item
+// starting on its own "- " line.
+func (s *chartFileSplicer) isBlockSequence(node *yaml.Node) bool {
+	if node.Kind != yaml.SequenceNode || node.Style&yaml.FlowStyle != 0 || len(node.Content) == 0 {
+		return false
+	}
+	for _, item := range node.Content {
+		if !strings.HasPrefix(strings.TrimLeft(s.lines[item.Line-1], " "), "- ") {
+			return false
+		}
+	}
+	return true
+}
+
+func isBlockMapping(node *yaml.Node) bool {
+	return node.Kind == yaml.MappingNode && node.Style&yaml.FlowStyle == 0 && len(node.Content) > 0
+}
+
+// preferIndex returns 0..n-1 with i moved to the front.
+func preferIndex(i int, n int) []int {
+	order := make([]int, 0, n)
+	if i < n {
+		order = append(order, i)
+	}
+	for j := 0; j < n; j++ {
+		if j != i {
+			order = append(order, j)
+		}
+	}
+	return order
+}
+
+func sameNode(a *yaml.Node, b *yaml.Node) (bool, error) {
+	x, err := yaml.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	y, err := yaml.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(x, y), nil
+}
+
+// renderEntry encodes key and value, the first line starts with prefix and
+// the following ones are indented to the key. Comments in front of the entry
+// are kept from the original lines, so they are not encoded again.
+func renderEntry(key *yaml.Node, value *yaml.Node, prefix string) ([]string, error) {
+	k := *key
+	k.HeadComment, k.FootComment = "", ""
+	v := *value
+	v.HeadComment, v.FootComment = "", ""
+	lines, err := encodeLines(&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{&k, &v}})
+	if err != nil {
+		return nil, err
+	}
+	return indentLines(lines, prefix, strings.Repeat(" ", len(prefix))), nil
+}
+
+// renderItem encodes a sequence item with its dash at indent.
+func renderItem(item *yaml.Node, indent string) ([]string, error) {
+	lines, err := encodeLines(&yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{item}})
+	if err != nil {
+		return nil, err
+	}
+	return indentLines(lines, indent, indent), nil
+}
+
+func encodeLines(node *yaml.Node) ([]string, error) {
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(out.String(), "\n")
+	return lines[:len(lines)-1], nil
+}
+
+func indentLines(lines []string, first string, rest string) []string {
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			lines[i] = first + line
+		case line != "\n":
+			lines[i] = rest + line
+		}
+	}
+	return lines
+}
+
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setNode(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			value.LineComment = m.Content[i+1].LineComment
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// setScalar sets a string value, keeping the quoting style of an existing one.
+func setScalar(m *yaml.Node, key string, value string) {
+	if node := mappingValue(m, key); node != nil && node.Kind == yaml.ScalarNode {
+		node.Value = value
+		node.Tag = "!!str"
+		return
+	}
+	setNode(m, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+func removeKey(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// findDependency returns the index of the dependency with name and alias, an
+// alias of "*" matches any alias.
+func findDependency(deps *yaml.Node, name string, alias string) int {
+	for i, d := range deps.Content {
+		n := mappingValue(d, "name")
+		if n == nil || n.Value != name {
+			continue
+		}
+		a := mappingValue(d, "alias")
+		if alias == "*" || (a == nil && alias == "") || (a != nil && a.Value == alias) {
+			return i
+		}
+	}
+	return -1
+}
+
+// toNode converts v to a yaml node through its json tags, the same way helm
+// marshals chart metadata.
+func toNode(v interface{}) (*yaml.Node, error) {
+	data, err := k8syaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	node := doc.Content[0]
+	sortMappingKeys(node)
+	return node, nil
+}
+
+func sortMappingKeys(node *yaml.Node) {
+	for _, n := range node.Content {
+		sortMappingKeys(n)
+	}
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	var sorted []*yaml.Node
+	for _, key := range chartEditorKeyOrder {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				sorted = append(sorted, node.Content[i], node.Content[i+1])
+			}
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if !containsString(chartEditorKeyOrder, node.Content[i].Value) {
+			sorted = append(sorted, node.Content[i], node.Content[i+1])
+		}
+	}
+	node.Content = sorted
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
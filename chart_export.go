@@ -59,7 +59,7 @@ func (c *chartExportClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) er
 }
 
 func (c *chartExportClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	registryClient, err := registry.NewClient(
 		registry.ClientOptDebug(env.settings.Debug),
 		registry.ClientOptWriter(os.Stdout),
@@ -25,7 +25,7 @@ func (c *chartListClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) erro
 }
 
 func (c *chartListClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	registryClient, err := registry.NewClient(
 		registry.ClientOptDebug(env.settings.Debug),
 		registry.ClientOptWriter(os.Stdout),
@@ -24,7 +24,7 @@ func (c *chartPushClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) erro
 }
 
 func (c *chartPushClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	registryClient, err := registry.NewClient(
 		registry.ClientOptDebug(env.settings.Debug),
 		registry.ClientOptWriter(os.Stdout),
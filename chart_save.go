@@ -28,7 +28,7 @@ func (c *chartSaveClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) erro
 }
 
 func (c *chartSaveClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	registryClient, err := registry.NewClient(
 		registry.ClientOptDebug(env.settings.Debug),
 		registry.ClientOptWriter(os.Stdout),
@@ -0,0 +1,169 @@
+package helmclient
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/outgnaY/helm-go-client/internal/ignore"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var utf8bom = []byte{0xEF, 0xBB, 0xBF}
+
+// ChartSource provides a chart that does not need to be located through a
+// repository or the local filesystem.
+type ChartSource struct {
+	name string
+	load func() (*chart.Chart, error)
+}
+
+// ChartFromObject uses an already loaded chart.
+func ChartFromObject(ch *chart.Chart) ChartSource {
+	return ChartSource{name: "<chart object>", load: func() (*chart.Chart, error) {
+		if ch == nil || ch.Metadata == nil {
+			return nil, errors.New("chart object has no metadata")
+		}
+		return ch, nil
+	}}
+}
+
+// ChartFromArchive loads a chart from the bytes of a chart archive (.tgz).
+func ChartFromArchive(data []byte) ChartSource {
+	return ChartFromReader(bytes.NewReader(data))
+}
+
+// ChartFromReader loads a chart from a stream of a chart archive (.tgz). The
+// reader is consumed by the first use of the source.
+func ChartFromReader(r io.Reader) ChartSource {
+	return ChartSource{name: "<chart archive>", load: func() (*chart.Chart, error) {
+		return loader.LoadArchive(r)
+	}}
+}
+
+// ChartFromFS loads a chart from the root of fsys, laid out like an unpacked
+// chart directory. The .helmignore file of the chart is honored.
+func ChartFromFS(fsys fs.FS) ChartSource {
+	return ChartSource{name: "<chart filesystem>", load: func() (*chart.Chart, error) {
+		return loadChartFS(fsys)
+	}}
+}
+
+func (s ChartSource) String() string {
+	return s.name
+}
+
+func (s ChartSource) Load() (*chart.Chart, error) {
+	if s.load == nil {
+		return nil, errors.New("empty chart source")
+	}
+	return s.load()
+}
+
+// loadChartFS follows loader.LoadDir, reading from a fs.FS instead of the disk.
+func loadChartFS(fsys fs.FS) (*chart.Chart, error) {
+	rules := ignore.Empty()
+	if data, err := fs.ReadFile(fsys, ignore.HelmIgnore); err == nil {
+		r, err := ignore.Parse(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		rules = r
+	}
+	rules.AddDefaults()
+
+	files := []*loader.BufferedFile{}
+	walk := func(name string, d fs.DirEntry, err error) error {
+		if name == "." {
+			// No need to process top level. Avoid bug with helmignore .* matching
+			// empty names. See issue 1779.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Directory-based ignore rules should involve skipping the entire
+			// contents of that directory.
+			if rules.Ignore(name, fi) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		// If a .helmignore file matches, skip this file.
+		if rules.Ignore(name, fi) {
+			return nil
+		}
+
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("cannot load irregular file %s as it has file mode type bits set", name)
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %s", name)
+		}
+
+		data = bytes.TrimPrefix(data, utf8bom)
+
+		files = append(files, &loader.BufferedFile{Name: name, Data: data})
+		return nil
+	}
+	if err := fs.WalkDir(fsys, ".", walk); err != nil {
+		return nil, err
+	}
+
+	return loader.LoadFiles(files)
+}
+
+// checkChartRequested runs the checks install does on a chart before rendering
+// it, except that missing dependencies can't be downloaded for a chart which
+// does not live on the disk.
+func checkChartRequested(ch *chart.Chart) error {
+	if err := checkIfInstallable(ch); err != nil {
+		return err
+	}
+
+	if ch.Metadata.Deprecated {
+		warning("This chart is deprecated")
+	}
+
+	if req := ch.Metadata.Dependencies; req != nil {
+		if err := action.CheckDependencies(ch, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveChartSource writes the chart of a source into a new temporary directory
+// for the commands which only accept chart paths. The returned function removes
+// the directory.
+func saveChartSource(source ChartSource) (string, func(), error) {
+	ch, err := source.Load()
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := ioutil.TempDir("", "helm-go-client-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	if err := chartutil.SaveDir(ch, dir); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return filepath.Join(dir, ch.Metadata.Name), cleanup, nil
+}
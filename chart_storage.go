@@ -0,0 +1,170 @@
+package helmclient
+
+import (
+	"bytes"
+	"github.com/outgnaY/helm-go-client/internal/fileutil"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChartObject is a file kept in a ChartStorage.
+type ChartObject struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ChartStorage keeps the chart archives and provenance files of a chart
+// repository served by RepoServer. Names are plain file names without a
+// directory, such as mychart-0.1.0.tgz.
+type ChartStorage interface {
+	// List returns the files in the storage.
+	List() ([]ChartObject, error)
+	// Get opens a file. It returns an error for which os.IsNotExist is true
+	// if there is no such file.
+	Get(name string) (io.ReadCloser, error)
+	// Put creates or replaces a file.
+	Put(name string, data []byte) error
+	// Delete removes a file. It returns an error for which os.IsNotExist is
+	// true if there is no such file.
+	Delete(name string) error
+}
+
+// dirChartStorage keeps the files in a directory.
+type dirChartStorage struct {
+	dir string
+}
+
+// NewDirChartStorage keeps the files of a repository in dir, such as a
+// directory RepoIndex generates an index for. Other files in dir, like an
+// index.yaml, are ignored.
+func NewDirChartStorage(dir string) ChartStorage {
+	return &dirChartStorage{dir: dir}
+}
+
+func (s *dirChartStorage) List() ([]ChartObject, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var objects []ChartObject
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || !isChartStorageFile(info.Name()) {
+			continue
+		}
+		objects = append(objects, ChartObject{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return objects, nil
+}
+
+func (s *dirChartStorage) Get(name string) (io.ReadCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *dirChartStorage) Put(name string, data []byte) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	return fileutil.AtomicWriteFile(path, bytes.NewReader(data), 0644)
+}
+
+func (s *dirChartStorage) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (s *dirChartStorage) path(name string) (string, error) {
+	if err := checkChartStorageName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+// memoryChartStorage keeps the files in memory.
+type memoryChartStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryChartObject
+}
+
+type memoryChartObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryChartStorage keeps the files of a repository in memory, for a
+// repository which only lives as long as the process, such as in tests.
+func NewMemoryChartStorage() ChartStorage {
+	return &memoryChartStorage{objects: map[string]memoryChartObject{}}
+}
+
+func (s *memoryChartStorage) List() ([]ChartObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	objects := make([]ChartObject, 0, len(s.objects))
+	for name, object := range s.objects {
+		objects = append(objects, ChartObject{Name: name, Size: int64(len(object.data)), ModTime: object.modTime})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+func (s *memoryChartStorage) Get(name string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[name]
+	if !ok {
+		return nil, &os.PathError{Op: "get", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(bytes.NewReader(object.data)), nil
+}
+
+func (s *memoryChartStorage) Put(name string, data []byte) error {
+	if err := checkChartStorageName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[name] = memoryChartObject{data: append([]byte(nil), data...), modTime: time.Now()}
+	return nil
+}
+
+func (s *memoryChartStorage) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[name]; !ok {
+		return &os.PathError{Op: "delete", Path: name, Err: os.ErrNotExist}
+	}
+	delete(s.objects, name)
+	return nil
+}
+
+// isChartStorageFile tells the chart archives and provenance files apart from
+// the other files of a directory.
+func isChartStorageFile(name string) bool {
+	return strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".tgz.prov")
+}
+
+func checkChartStorageName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || !isChartStorageFile(name) {
+		return errors.Errorf("invalid chart file name %q", name)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"helm.sh/helm/v3/pkg/cli"
 	"log"
 	"os"
+	"text/template"
 )
 
 func debug(format string, v ...interface{}) {
@@ -44,18 +45,23 @@ type HelmClient interface {
 	RegistryLogout() (registryLogoutClient, error)
 	SearchHub(opts []SearchHubOption) (searchHubClient, error)
 	SearchRepo(opts []SearchRepoOption) (searchRepoClient, error)
-	RepoUpdate() (repoUpdateClient, error)
+	SearchRepoIndex(opts []SearchRepoIndexOption) (*SearchRepoIndex, error)
+	RepoUpdate(opts []RepoUpdateOption) (repoUpdateClient, error)
 	RepoRemove() (repoRemoveClient, error)
 	RepoList() (repoListClient, error)
 	RepoAdd(opts []RepoAddOption) (repoAddClient, error)
 	RepoIndex(opts []RepoIndexOption) (repoIndexClient, error)
+	RepoMirror(opts []RepoMirrorOption) (repoMirrorClient, error)
 	Pull(opts []PullOption, chartPathOpts []ChartPathOption) (pullClient, error)
 	Template(opts []TemplateOption, valueOpts []ValueOption) (templateClient, error)
+	Capabilities() (capabilitiesClient, error)
+	UnitTest(opts []UnitTestOption, valueOpts []ValueOption) (unitTestClient, error)
 }
 
 type helmEnv struct {
 	settings     *cli.EnvSettings
 	clientGetter *RESTClientGetter
+	clientOpts   *clientOptions
 }
 
 func (env *helmEnv) Namespace() string {
@@ -65,6 +71,20 @@ func (env *helmEnv) Namespace() string {
 	return "default"
 }
 
+func (env *helmEnv) valuesDecryptors() []ValuesDecryptor {
+	if env.clientOpts == nil {
+		return nil
+	}
+	return env.clientOpts.valuesDecryptors
+}
+
+func (env *helmEnv) templateFuncs() template.FuncMap {
+	if env.clientOpts == nil {
+		return nil
+	}
+	return env.clientOpts.templateFuncs
+}
+
 type helmClientImpl struct {
 	env *helmEnv
 }
@@ -169,8 +189,12 @@ func (c *helmClientImpl) SearchRepo(opts []SearchRepoOption) (searchRepoClient,
 	return newSearchRepoClient(opts, c.env)
 }
 
-func (c *helmClientImpl) RepoUpdate() (repoUpdateClient, error) {
-	return newRepoUpdateClient(c.env)
+func (c *helmClientImpl) SearchRepoIndex(opts []SearchRepoIndexOption) (*SearchRepoIndex, error) {
+	return newSearchRepoIndex(opts, c.env)
+}
+
+func (c *helmClientImpl) RepoUpdate(opts []RepoUpdateOption) (repoUpdateClient, error) {
+	return newRepoUpdateClient(opts, c.env)
 }
 
 func (c *helmClientImpl) RepoRemove() (repoRemoveClient, error) {
@@ -189,6 +213,10 @@ func (c *helmClientImpl) RepoIndex(opts []RepoIndexOption) (repoIndexClient, err
 	return newRepoIndexClient(opts, c.env)
 }
 
+func (c *helmClientImpl) RepoMirror(opts []RepoMirrorOption) (repoMirrorClient, error) {
+	return newRepoMirrorClient(opts, c.env)
+}
+
 func (c *helmClientImpl) Pull(opts []PullOption, chartPathOpts []ChartPathOption) (pullClient, error) {
 	return newPullClient(opts, chartPathOpts, c.env)
 }
@@ -197,12 +225,21 @@ func (c *helmClientImpl) Template(opts []TemplateOption, valueOpts []ValueOption
 	return newTemplateClient(opts, valueOpts, c.env)
 }
 
+func (c *helmClientImpl) Capabilities() (capabilitiesClient, error) {
+	return newCapabilitiesClient(c.env)
+}
+
+func (c *helmClientImpl) UnitTest(opts []UnitTestOption, valueOpts []ValueOption) (unitTestClient, error) {
+	return newUnitTestClient(opts, valueOpts, c.env)
+}
+
 func NewHelmClient(kubeConfig string, namespace string) HelmClient {
 	settings := cli.New()
 	clientGetter := newRESTClientGetter(kubeConfig, namespace)
 	env := &helmEnv{
 		settings:     settings,
 		clientGetter: clientGetter,
+		clientOpts:   newClientOptions(nil),
 	}
 	return &helmClientImpl{
 		env: env,
@@ -210,30 +247,37 @@ func NewHelmClient(kubeConfig string, namespace string) HelmClient {
 }
 
 func NewHelmClientWithGlobalOpts(kubeConfig string, namespace string, globalOpts []GlobalOption) HelmClient {
+	return NewHelmClientWithClientOpts(kubeConfig, namespace, globalOpts, nil)
+}
+
+func NewHelmClientWithClientOpts(kubeConfig string, namespace string, globalOpts []GlobalOption, clientOpts []ClientOption) HelmClient {
 	settings := cli.New()
 	clientGetter := newRESTClientGetter(kubeConfig, namespace)
 	addGlobalOptions(globalOpts, (*globalOptions)(settings))
 	env := &helmEnv{
 		settings:     settings,
 		clientGetter: clientGetter,
+		clientOpts:   newClientOptions(clientOpts),
 	}
 	return &helmClientImpl{
 		env: env,
 	}
 }
 
-func rebuildEnv(globalOpts []GlobalOption, namespace string, getter *RESTClientGetter) *helmEnv {
+func rebuildEnv(globalOpts []GlobalOption, namespace string, old *helmEnv) *helmEnv {
 	settings := cli.New()
 	addGlobalOptions(globalOpts, (*globalOptions)(settings))
 	env := &helmEnv{
 		settings:     settings,
-		clientGetter: newRESTClientGetterFromOldWithNamespace(getter, namespace),
+		clientGetter: newRESTClientGetterFromOldWithNamespace(old.clientGetter, namespace),
+		// client options are not affected by global options
+		clientOpts: old.clientOpts,
 	}
 	return env
 }
 
-func rebuildEnvAndCfg(globalOpts []GlobalOption, namespace string, getter *RESTClientGetter) (*helmEnv, *action.Configuration, error) {
-	env := rebuildEnv(globalOpts, namespace, getter)
+func rebuildEnvAndCfg(globalOpts []GlobalOption, namespace string, old *helmEnv) (*helmEnv, *action.Configuration, error) {
+	env := rebuildEnv(globalOpts, namespace, old)
 	cfg := new(action.Configuration)
 	// must pass namespace explicitly cause cli.EnvSettings.namespace is private
 	err := cfg.Init(env.clientGetter, env.Namespace(), "", debug)
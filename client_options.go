@@ -0,0 +1,74 @@
+package helmclient
+
+import "text/template"
+
+// ClientOption configures behaviour of the library itself rather than of helm,
+// and is shared by every command created from the same HelmClient.
+type ClientOption struct {
+	f func(o *clientOptions)
+}
+
+type clientOptions struct {
+	valuesDecryptors    []ValuesDecryptor
+	templateFuncs       template.FuncMap
+	credentialProviders []CredentialProvider
+	repoStore           RepoStore
+}
+
+func (o *clientOptions) apply(opts []ClientOption) {
+	for _, op := range opts {
+		op.f(o)
+	}
+}
+
+func newClientOptions(opts []ClientOption) *clientOptions {
+	options := &clientOptions{
+		valuesDecryptors:    []ValuesDecryptor{},
+		templateFuncs:       template.FuncMap{},
+		credentialProviders: []CredentialProvider{},
+	}
+	options.apply(opts)
+	return options
+}
+
+// ClientWithValuesDecryptor registers a decryptor for encrypted values files.
+// Decryptors are tried in registration order and the first one that detects
+// the file format is used.
+func ClientWithValuesDecryptor(decryptor ValuesDecryptor) ClientOption {
+	return ClientOption{f: func(o *clientOptions) {
+		o.valuesDecryptors = append(o.valuesDecryptors, decryptor)
+	}}
+}
+
+// ClientWithTemplateFuncs registers extra template functions for rendering
+// charts in install, upgrade, template and lint. The names must not shadow a
+// function of text/template, sprig or Helm, creating those commands fails
+// otherwise.
+func ClientWithTemplateFuncs(funcs template.FuncMap) ClientOption {
+	return ClientOption{f: func(o *clientOptions) {
+		for name, fn := range funcs {
+			o.templateFuncs[name] = fn
+		}
+	}}
+}
+
+// ClientWithCredentialProvider registers a provider of repository credentials
+// for repo add, repo update, pull, install and upgrade. Providers are asked in
+// registration order and the first one with credentials for a repository is
+// used. Credentials given to a command explicitly take precedence.
+func ClientWithCredentialProvider(provider CredentialProvider) ClientOption {
+	return ClientOption{f: func(o *clientOptions) {
+		o.credentialProviders = append(o.credentialProviders, provider)
+	}}
+}
+
+// ClientWithRepoStore keeps the repository configuration in store instead of
+// the repositories file of the repository config global option. The repo
+// commands, search repo and the commands locating charts by repository all go
+// through it, so that clients sharing a store, such as the replicas of a
+// service sharing a Secret, see the same repositories.
+func ClientWithRepoStore(store RepoStore) ClientOption {
+	return ClientOption{f: func(o *clientOptions) {
+		o.repoStore = store
+	}}
+}
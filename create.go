@@ -2,20 +2,31 @@ package helmclient
 
 import (
 	"fmt"
+	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/helmpath"
 	"io"
 	"os"
 	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
 )
 
 const (
-	createDefaultStarter = ""
+	createDefaultStarter     = ""
+	createDefaultDescription = "A Helm chart for Kubernetes"
+	createDefaultVersion     = "0.1.0"
+	// the scaffold keeps its own appVersion, starters get createStarterAppVersion
+	createDefaultAppVersion  = ""
+	createDefaultType        = "application"
+	createDefaultKubeVersion = ""
+	createStarterAppVersion  = "0.1.0"
 )
 
 type createClient interface {
-	Create(name string) error
+	Create(name string) ([]string, error)
 }
 
 type createClientImpl struct {
@@ -28,9 +39,17 @@ type CreateOption struct {
 }
 
 type createOptions struct {
-	starter    string // --starter
-	name       string
-	starterDir string
+	starter       string // --starter
+	starterSource *ChartSource
+	description   string
+	version       string
+	appVersion    string
+	chartType     string
+	kubeVersion   string
+	maintainers   []*chart.Maintainer
+	templateVars  map[string]string
+	name          string
+	starterDir    string
 }
 
 func (o *createOptions) apply(opts []CreateOption) {
@@ -41,7 +60,12 @@ func (o *createOptions) apply(opts []CreateOption) {
 
 func newCreateOptions(opts []CreateOption) *createOptions {
 	options := &createOptions{
-		starter: createDefaultStarter,
+		starter:     createDefaultStarter,
+		description: createDefaultDescription,
+		version:     createDefaultVersion,
+		appVersion:  createDefaultAppVersion,
+		chartType:   createDefaultType,
+		kubeVersion: createDefaultKubeVersion,
 	}
 	options.apply(opts)
 	return options
@@ -53,13 +77,68 @@ func CreateWithStarter(starter string) CreateOption {
 	}}
 }
 
+// CreateWithStarterSource uses a starter which is not in the helm starters
+// directory, e.g. ChartFromFS with an embed.FS or ChartFromArchive. Note that
+// go:embed skips files starting with '_' such as _helpers.tpl unless the
+// pattern is prefixed with "all:".
+func CreateWithStarterSource(source ChartSource) CreateOption {
+	return CreateOption{f: func(o *createOptions) {
+		o.starterSource = &source
+	}}
+}
+
+func CreateWithDescription(description string) CreateOption {
+	return CreateOption{f: func(o *createOptions) {
+		o.description = description
+	}}
+}
+
+func CreateWithVersion(version string) CreateOption {
+	return CreateOption{f: func(o *createOptions) {
+		o.version = version
+	}}
+}
+
+func CreateWithAppVersion(appVersion string) CreateOption {
+	return CreateOption{f: func(o *createOptions) {
+		o.appVersion = appVersion
+	}}
+}
+
+// CreateWithType sets the chart type, application or library.
+func CreateWithType(chartType string) CreateOption {
+	return CreateOption{f: func(o *createOptions) {
+		o.chartType = chartType
+	}}
+}
+
+func CreateWithKubeVersion(kubeVersion string) CreateOption {
+	return CreateOption{f: func(o *createOptions) {
+		o.kubeVersion = kubeVersion
+	}}
+}
+
+func CreateWithMaintainers(maintainers []*chart.Maintainer) CreateOption {
+	return CreateOption{f: func(o *createOptions) {
+		o.maintainers = maintainers
+	}}
+}
+
+// CreateWithTemplateVars substitutes every <KEY> placeholder in the templates
+// and values of a starter, in addition to <CHARTNAME>.
+func CreateWithTemplateVars(templateVars map[string]string) CreateOption {
+	return CreateOption{f: func(o *createOptions) {
+		o.templateVars = templateVars
+	}}
+}
+
 func (c *createClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
 	// use old namespace
 	return c.OverrideGlobalOptsWithNamespace(globalOpts, c.env.Namespace())
 }
 
 func (c *createClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	c.env = env
 	return nil
 }
@@ -72,19 +151,31 @@ func newCreateClient(opts []CreateOption, env *helmEnv) (*createClientImpl, erro
 	}, nil
 }
 
-func (o *createOptions) run(out io.Writer) error {
+func (o *createOptions) run(out io.Writer) ([]string, error) {
 	fmt.Fprintf(out, "Creating %s\n", o.name)
 
 	chartname := filepath.Base(o.name)
+	appVersion := o.appVersion
+	if appVersion == "" {
+		appVersion = createStarterAppVersion
+	}
 	cfile := &chart.Metadata{
 		Name:        chartname,
-		Description: "A Helm chart for Kubernetes",
-		Type:        "application",
-		Version:     "0.1.0",
-		AppVersion:  "0.1.0",
+		Description: o.description,
+		Type:        o.chartType,
+		Version:     o.version,
+		AppVersion:  appVersion,
+		KubeVersion: o.kubeVersion,
+		Maintainers: o.maintainers,
 		APIVersion:  chart.APIVersionV2,
 	}
+	if err := cfile.Validate(); err != nil {
+		return nil, err
+	}
 
+	if o.starterSource != nil {
+		return o.createFrom(cfile, *o.starterSource)
+	}
 	if o.starter != "" {
 		// Create from the starter
 		lstarter := filepath.Join(o.starterDir, o.starter)
@@ -92,15 +183,132 @@ func (o *createOptions) run(out io.Writer) error {
 		if filepath.IsAbs(o.starter) {
 			lstarter = o.starter
 		}
-		return chartutil.CreateFrom(cfile, filepath.Dir(o.name), lstarter)
+		return o.createFrom(cfile, ChartSource{name: lstarter, load: func() (*chart.Chart, error) {
+			return loader.Load(lstarter)
+		}})
 	}
 
 	chartutil.Stderr = out
-	_, err := chartutil.Create(chartname, filepath.Dir(o.name))
-	return err
+	cdir, err := chartutil.Create(chartname, filepath.Dir(o.name))
+	if err != nil {
+		return nil, err
+	}
+	editOpts := []EditChartOption{
+		EditChartWithDescription(o.description),
+		EditChartWithVersion(o.version),
+		EditChartWithType(o.chartType),
+	}
+	if o.appVersion != "" {
+		editOpts = append(editOpts, EditChartWithAppVersion(o.appVersion))
+	}
+	if o.kubeVersion != "" {
+		editOpts = append(editOpts, EditChartWithKubeVersion(o.kubeVersion))
+	}
+	if len(o.maintainers) > 0 {
+		editOpts = append(editOpts, EditChartWithMaintainers(o.maintainers))
+	}
+	if err := EditChart(cdir, editOpts); err != nil {
+		return nil, err
+	}
+	return scaffoldFiles(cdir), nil
+}
+
+// scaffoldFiles lists the files chartutil.Create writes into dir, other
+// files already in dir are left alone and not reported.
+func scaffoldFiles(dir string) []string {
+	var files []string
+	for _, name := range []string{
+		chartutil.ChartfileName,
+		chartutil.ValuesfileName,
+		chartutil.IgnorefileName,
+		chartutil.IngressFileName,
+		chartutil.DeploymentName,
+		chartutil.ServiceName,
+		chartutil.ServiceAccountName,
+		chartutil.HorizontalPodAutoscalerName,
+		chartutil.NotesName,
+		chartutil.HelpersName,
+		chartutil.TestConnectionName,
+	} {
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files
+}
+
+// createFrom is chartutil.CreateFrom with a starter from any source and the
+// template variables substituted next to the chart name.
+func (o *createOptions) createFrom(cfile *chart.Metadata, source ChartSource) ([]string, error) {
+	schart, err := source.Load()
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not load %s", source)
+	}
+
+	schart.Metadata = cfile
+
+	var updatedTemplates []*chart.File
+
+	for _, template := range schart.Templates {
+		newData := o.transform(string(template.Data), schart.Name())
+		updatedTemplates = append(updatedTemplates, &chart.File{Name: template.Name, Data: newData})
+	}
+
+	schart.Templates = updatedTemplates
+	b, err := yaml.Marshal(schart.Values)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading values file")
+	}
+
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(o.transform(string(b), schart.Name()), &m); err != nil {
+		return nil, errors.Wrap(err, "transforming values file")
+	}
+	schart.Values = m
+
+	// SaveDir looks for the file values.yaml when saving rather than the values
+	// key in order to preserve the comments in the YAML. The name placeholder
+	// needs to be replaced on that file.
+	for _, f := range schart.Raw {
+		if f.Name == chartutil.ValuesfileName {
+			f.Data = o.transform(string(f.Data), schart.Name())
+		}
+	}
+
+	dest := filepath.Dir(o.name)
+	if err := chartutil.SaveDir(schart, dest); err != nil {
+		return nil, err
+	}
+	return savedChartFiles(schart, filepath.Join(dest, schart.Name())), nil
+}
+
+func (o *createOptions) transform(src, replacement string) []byte {
+	pairs := []string{"<CHARTNAME>", replacement}
+	for k, v := range o.templateVars {
+		pairs = append(pairs, "<"+k+">", v)
+	}
+	return []byte(strings.NewReplacer(pairs...).Replace(src))
+}
+
+// savedChartFiles lists the files chartutil.SaveDir writes for ch into dir.
+func savedChartFiles(ch *chart.Chart, dir string) []string {
+	files := []string{filepath.Join(dir, chartutil.ChartfileName)}
+	for _, f := range ch.Raw {
+		if f.Name == chartutil.ValuesfileName {
+			files = append(files, filepath.Join(dir, chartutil.ValuesfileName))
+		}
+	}
+	if ch.Schema != nil {
+		files = append(files, filepath.Join(dir, chartutil.SchemafileName))
+	}
+	for _, f := range append(ch.Templates, ch.Files...) {
+		files = append(files, filepath.Join(dir, f.Name))
+	}
+	for _, dep := range ch.Dependencies() {
+		files = append(files, savedChartFiles(dep, filepath.Join(dir, chartutil.ChartsDir, dep.Name()))...)
+	}
+	return files
 }
 
-func (c *createClientImpl) Create(name string) error {
+func (c *createClientImpl) Create(name string) ([]string, error) {
 	c.createOpts.name = name
 	c.createOpts.starterDir = helmpath.DataPath("starters")
 	return c.createOpts.run(os.Stdout)
@@ -0,0 +1,247 @@
+package helmclient
+
+import (
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// RepoCredentials authenticate against a chart repository.
+type RepoCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// CredentialProvider resolves the credentials of a chart repository when a
+// command makes a request to it, so that they need not be kept in the
+// repositories file. Implementations must not log or otherwise leak the
+// credentials.
+type CredentialProvider interface {
+	// Credentials returns the credentials of the repository with the given
+	// name and URL, or nil if the provider has none for it. name is empty for
+	// a repository which is only known by its URL.
+	Credentials(name string, url string) (*RepoCredentials, error)
+}
+
+// CredentialProviderFunc adapts a function, such as a call into a secret
+// manager, to a CredentialProvider.
+type CredentialProviderFunc func(name string, url string) (*RepoCredentials, error)
+
+func (f CredentialProviderFunc) Credentials(name string, url string) (*RepoCredentials, error) {
+	return f(name, url)
+}
+
+// staticCredentialProvider provides fixed credentials.
+type staticCredentialProvider struct {
+	credentials map[string]RepoCredentials
+}
+
+// NewStaticCredentialProvider provides fixed credentials, keyed by the name or
+// the URL of the repository.
+func NewStaticCredentialProvider(credentials map[string]RepoCredentials) CredentialProvider {
+	return &staticCredentialProvider{credentials: credentials}
+}
+
+func (p *staticCredentialProvider) Credentials(name string, url string) (*RepoCredentials, error) {
+	return lookupCredentials(p.credentials, name, url), nil
+}
+
+// envCredentialProvider reads credentials from environment variables.
+type envCredentialProvider struct {
+	prefix string
+}
+
+// NewEnvCredentialProvider reads the credentials of a repository from the
+// environment variables <prefix><NAME>_USERNAME and <prefix><NAME>_PASSWORD,
+// where NAME is the name of the repository in upper case with every character
+// other than a letter or a digit replaced by an underscore. With the prefix
+// HELM_REPO_, the repository my-charts uses HELM_REPO_MY_CHARTS_USERNAME and
+// HELM_REPO_MY_CHARTS_PASSWORD.
+func NewEnvCredentialProvider(prefix string) CredentialProvider {
+	return &envCredentialProvider{prefix: prefix}
+}
+
+func (p *envCredentialProvider) Credentials(name string, _ string) (*RepoCredentials, error) {
+	if name == "" {
+		return nil, nil
+	}
+	key := p.prefix + strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+	username, hasUsername := os.LookupEnv(key + "_USERNAME")
+	password, hasPassword := os.LookupEnv(key + "_PASSWORD")
+	if !hasUsername && !hasPassword {
+		return nil, nil
+	}
+	return &RepoCredentials{Username: username, Password: password}, nil
+}
+
+// fileCredentialProvider reads credentials from a file, again whenever it
+// changes.
+type fileCredentialProvider struct {
+	path string
+
+	mu          sync.Mutex
+	modTime     time.Time
+	size        int64
+	credentials map[string]RepoCredentials
+}
+
+// NewFileCredentialProvider reads credentials from a YAML file which maps the
+// names or the URLs of repositories to a username and a password:
+//
+//	my-charts:
+//	  username: user
+//	  password: secret
+//
+// The file is read again when its modification time or size changes, so that
+// rotated credentials, such as a mounted Kubernetes secret, are used without a
+// restart. A missing file provides no credentials.
+func NewFileCredentialProvider(path string) CredentialProvider {
+	return &fileCredentialProvider{path: path}
+}
+
+func (p *fileCredentialProvider) Credentials(name string, url string) (*RepoCredentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	info, err := os.Stat(p.path)
+	if os.IsNotExist(err) {
+		p.credentials = nil
+		p.modTime = time.Time{}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.credentials == nil || !info.ModTime().Equal(p.modTime) || info.Size() != p.size {
+		data, err := ioutil.ReadFile(p.path)
+		if err != nil {
+			return nil, err
+		}
+		credentials := map[string]RepoCredentials{}
+		if err := yaml.Unmarshal(data, &credentials); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse credentials file %s", p.path)
+		}
+		p.credentials = credentials
+		p.modTime = info.ModTime()
+		p.size = info.Size()
+	}
+	return lookupCredentials(p.credentials, name, url), nil
+}
+
+// lookupCredentials finds the credentials of a repository by its name, then
+// by its URL.
+func lookupCredentials(credentials map[string]RepoCredentials, name string, url string) *RepoCredentials {
+	if name != "" {
+		if c, ok := credentials[name]; ok {
+			return &c
+		}
+	}
+	if url != "" {
+		for _, key := range []string{url, strings.TrimSuffix(url, "/"), strings.TrimSuffix(url, "/") + "/"} {
+			if c, ok := credentials[key]; ok {
+				return &c
+			}
+		}
+	}
+	return nil
+}
+
+// repoCredentials asks the credential providers of the client in turn for
+// the credentials of a repository.
+func (env *helmEnv) repoCredentials(name string, url string) (*RepoCredentials, error) {
+	if env.clientOpts == nil {
+		return nil, nil
+	}
+	for _, p := range env.clientOpts.credentialProviders {
+		c, err := p.Credentials(name, url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get the credentials of repository %s", repoDisplayName(name, url))
+		}
+		if c != nil {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+// withRepoCredentials copies entry with the credentials of the providers, if
+// they have any for it.
+func withRepoCredentials(entry *repo.Entry, env *helmEnv) (*repo.Entry, error) {
+	c, err := env.repoCredentials(entry.Name, entry.URL)
+	if err != nil || c == nil {
+		return entry, err
+	}
+	out := *entry
+	out.Username = c.Username
+	out.Password = c.Password
+	return &out, nil
+}
+
+// chartPathCredentials copies opts with the credentials of the repository
+// chart is located in, unless the credentials were given explicitly.
+func chartPathCredentials(opts action.ChartPathOptions, chart string, env *helmEnv) (action.ChartPathOptions, error) {
+	if opts.Username != "" || opts.Password != "" {
+		return opts, nil
+	}
+	name, url := chartRepository(opts, chart, env.repoStore())
+	if name == "" && url == "" {
+		return opts, nil
+	}
+	c, err := env.repoCredentials(name, url)
+	if err != nil || c == nil {
+		return opts, err
+	}
+	opts.Username = c.Username
+	opts.Password = c.Password
+	return opts, nil
+}
+
+// chartRepository finds the repository LocateChart downloads chart from: the
+// repository URL of opts, the configured repository a chart URL is in, or
+// the repository named by a repo/chart reference.
+func chartRepository(opts action.ChartPathOptions, chart string, store RepoStore) (string, string) {
+	if opts.RepoURL != "" {
+		return "", opts.RepoURL
+	}
+	chart = strings.TrimSpace(chart)
+	if _, err := os.Stat(chart); err == nil {
+		return "", ""
+	}
+	f, err := store.Load()
+	if err != nil {
+		return "", ""
+	}
+	if u, err := url.Parse(chart); err == nil && u.IsAbs() && u.Host != "" {
+		for _, entry := range f.Repositories {
+			if strings.HasPrefix(chart, strings.TrimSuffix(entry.URL, "/")+"/") {
+				return entry.Name, entry.URL
+			}
+		}
+		return "", ""
+	}
+	if i := strings.Index(chart, "/"); i > 0 {
+		if entry := f.Get(chart[:i]); entry != nil {
+			return entry.Name, entry.URL
+		}
+	}
+	return "", ""
+}
+
+func repoDisplayName(name string, url string) string {
+	if name != "" {
+		return name
+	}
+	return url
+}
@@ -0,0 +1,310 @@
+package helmclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"io"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	memory "k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/util/flowcontrol"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+)
+
+const fakeClusterHost = "http://fake-cluster"
+
+// clusterScopedKinds are the built-in kinds which don't live in a namespace.
+var clusterScopedKinds = map[string]bool{
+	"APIService":                     true,
+	"CertificateSigningRequest":      true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"ComponentStatus":                true,
+	"CSIDriver":                      true,
+	"CSINode":                        true,
+	"CustomResourceDefinition":       true,
+	"IngressClass":                   true,
+	"MutatingWebhookConfiguration":   true,
+	"Namespace":                      true,
+	"Node":                           true,
+	"PersistentVolume":               true,
+	"PodSecurityPolicy":              true,
+	"PriorityClass":                  true,
+	"RuntimeClass":                   true,
+	"StorageClass":                   true,
+	"ValidatingWebhookConfiguration": true,
+	"VolumeAttachment":               true,
+}
+
+// FakeCluster is a simulated cluster state for the lookup template function.
+// Renders which use it never reach a real cluster.
+type FakeCluster struct {
+	objects []*unstructured.Unstructured
+}
+
+// NewFakeCluster creates a fake cluster holding objects.
+func NewFakeCluster(objects []*unstructured.Unstructured) *FakeCluster {
+	return &FakeCluster{objects: objects}
+}
+
+// LoadFakeCluster creates a fake cluster from YAML files. A file may hold
+// several documents, and List kinds such as the output of kubectl get -o yaml
+// are expanded into their items.
+func LoadFakeCluster(paths []string) (*FakeCluster, error) {
+	f := &FakeCluster{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		objs, err := decodeFakeObjects(file)
+		file.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", path)
+		}
+		f.objects = append(f.objects, objs...)
+	}
+	return f, nil
+}
+
+func decodeFakeObjects(r io.Reader) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				return objects, nil
+			}
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.IsList() {
+			if err := obj.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			return nil, errors.New("object has no apiVersion or kind")
+		}
+		objects = append(objects, obj)
+	}
+}
+
+// resources lists the resources served for a group version: the built-in
+// kinds of Kubernetes and the kinds of the objects.
+func (f *FakeCluster) resources(gv schema.GroupVersion) []metav1.APIResource {
+	namespaced := map[string]bool{}
+	var kinds []string
+	add := func(kind string, ns bool) {
+		if _, ok := namespaced[kind]; !ok {
+			kinds = append(kinds, kind)
+		}
+		namespaced[kind] = namespaced[kind] || ns
+	}
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		if gvk.GroupVersion() == gv && !strings.HasSuffix(gvk.Kind, "List") && !strings.HasSuffix(gvk.Kind, "Options") {
+			add(gvk.Kind, !clusterScopedKinds[gvk.Kind])
+		}
+	}
+	for _, obj := range f.objects {
+		if obj.GroupVersionKind().GroupVersion() == gv {
+			add(obj.GetKind(), obj.GetNamespace() != "")
+		}
+	}
+	resources := make([]metav1.APIResource, 0, len(kinds))
+	for _, kind := range kinds {
+		plural, singular := meta.UnsafeGuessKindToResource(gv.WithKind(kind))
+		resources = append(resources, metav1.APIResource{
+			Name:         plural.Resource,
+			SingularName: singular.Resource,
+			Namespaced:   namespaced[kind],
+			Kind:         kind,
+			Verbs:        metav1.Verbs{"get", "list"},
+		})
+	}
+	return resources
+}
+
+// serveHTTP answers the discovery and read requests lookup makes, the same way
+// the API server would.
+func (f *FakeCluster) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeFakeStatus(w, http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed, "the fake cluster is read only")
+		return
+	}
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var gv schema.GroupVersion
+	switch {
+	case len(segments) >= 2 && segments[0] == "api":
+		gv, segments = schema.GroupVersion{Version: segments[1]}, segments[2:]
+	case len(segments) >= 3 && segments[0] == "apis":
+		gv, segments = schema.GroupVersion{Group: segments[1], Version: segments[2]}, segments[3:]
+	default:
+		writeFakeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "the server could not find the requested resource")
+		return
+	}
+
+	resources := f.resources(gv)
+	if len(segments) == 0 {
+		if len(resources) == 0 {
+			writeFakeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "the server could not find the requested resource")
+			return
+		}
+		writeFakeJSON(w, &metav1.APIResourceList{
+			TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+			GroupVersion: gv.String(),
+			APIResources: resources,
+		})
+		return
+	}
+
+	var namespace, resource, name string
+	if len(segments) >= 3 && segments[0] == "namespaces" {
+		namespace, segments = segments[1], segments[2:]
+	}
+	resource = segments[0]
+	if len(segments) > 1 {
+		name = segments[1]
+	}
+	kind := ""
+	for _, res := range resources {
+		if res.Name == resource {
+			kind = res.Kind
+		}
+	}
+	if kind == "" {
+		writeFakeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "the server could not find the requested resource")
+		return
+	}
+
+	var items []interface{}
+	for _, obj := range f.objects {
+		if obj.GroupVersionKind() != gv.WithKind(kind) || (namespace != "" && obj.GetNamespace() != namespace) {
+			continue
+		}
+		if name == "" {
+			items = append(items, obj.Object)
+			continue
+		}
+		if obj.GetName() == name {
+			writeFakeJSON(w, obj.Object)
+			return
+		}
+	}
+	if name != "" {
+		writeFakeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("%s %q not found", resource, name))
+		return
+	}
+	if items == nil {
+		items = []interface{}{}
+	}
+	writeFakeJSON(w, map[string]interface{}{
+		"apiVersion": gv.String(),
+		"kind":       kind + "List",
+		"metadata":   map[string]interface{}{"resourceVersion": ""},
+		"items":      items,
+	})
+}
+
+func writeFakeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeFakeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     int32(code),
+	})
+}
+
+// fakeClusterTransport serves requests in memory, no listener is opened.
+type fakeClusterTransport struct {
+	cluster *FakeCluster
+}
+
+func (t *fakeClusterTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.cluster.serveHTTP(rec, r)
+	resp := rec.Result()
+	resp.Request = r
+	return resp, nil
+}
+
+// fakeClusterGetter stands in for RESTClientGetter with a fake cluster.
+type fakeClusterGetter struct {
+	cluster *FakeCluster
+}
+
+func (c *fakeClusterGetter) ToRESTConfig() (*rest.Config, error) {
+	return &rest.Config{
+		Host:        fakeClusterHost,
+		Transport:   &fakeClusterTransport{cluster: c.cluster},
+		RateLimiter: flowcontrol.NewFakeAlwaysRateLimiter(),
+	}, nil
+}
+
+func (c *fakeClusterGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	config, _ := c.ToRESTConfig()
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewMemCacheClient(discoveryClient), nil
+}
+
+func (c *fakeClusterGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	discoveryClient, err := c.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	return restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient), nil
+}
+
+// useFakeCluster makes install render with lookup answered by f. Helm only
+// gives templates a cluster client outside of dry runs, so the action runs as
+// a real install against fake clients and the release is turned back into a
+// dry run afterwards by finishFakeCluster. cfg must already use fake clients.
+func useFakeCluster(cli *action.Install, cfg *action.Configuration, f *FakeCluster) {
+	cli.DryRun = false
+	cfg.RESTClientGetter = &fakeClusterGetter{cluster: f}
+}
+
+func finishFakeCluster(cli *action.Install, rel *release.Release) {
+	cli.DryRun = true
+	if rel == nil {
+		return
+	}
+	for _, h := range rel.Hooks {
+		h.LastRun = release.HookExecution{}
+	}
+	if rel.Info != nil && rel.Info.Status == release.StatusDeployed {
+		rel.SetStatus(release.StatusPendingInstall, "Dry run complete")
+	}
+}
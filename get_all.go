@@ -51,7 +51,7 @@ func (c *getAllClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
 }
 
 func (c *getAllClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
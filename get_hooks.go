@@ -49,7 +49,7 @@ func (c *getHooksClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error
 }
 
 func (c *getHooksClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
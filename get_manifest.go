@@ -8,6 +8,7 @@ const (
 
 type getManifestClient interface {
 	GetManifest(name string) (string, error)
+	Images(name string) ([]*ImageReference, error)
 }
 
 type getManifestClientImpl struct {
@@ -46,7 +47,7 @@ func (c *getManifestClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) er
 }
 
 func (c *getManifestClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
@@ -46,7 +46,7 @@ func (c *getNotesClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error
 }
 
 func (c *getNotesClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
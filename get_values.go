@@ -60,7 +60,7 @@ func (c *getValuesClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) erro
 }
 
 func (c *getValuesClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
@@ -54,7 +54,7 @@ func (c *historyClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error
 }
 
 func (c *historyClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
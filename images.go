@@ -0,0 +1,205 @@
+package helmclient
+
+import (
+	"fmt"
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"strings"
+)
+
+// podSpecFields are the fields holding the pod spec of the kinds which run
+// containers.
+var podSpecFields = map[string][]string{
+	"Pod":                   {"spec"},
+	"PodTemplate":           {"template", "spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// containerFields are the lists of containers in a pod spec.
+var containerFields = []string{"initContainers", "containers", "ephemeralContainers"}
+
+// ImageReference is a container image run by a resource of a release.
+type ImageReference struct {
+	// Image is the reference as written in the manifest.
+	Image string
+	// Repository is the fully qualified repository of the image, such as
+	// docker.io/library/nginx. It is empty if Image is not a valid reference.
+	Repository string
+	Tag        string
+	Digest     string
+	Release    string
+	// Template is the path of the template which rendered the resource,
+	// relative to the chart root.
+	Template  string
+	Kind      string
+	Namespace string
+	Name      string
+	// Path is the field path of the container in the resource, such as
+	// spec.template.spec.initContainers[0].
+	Path      string
+	Container string
+	// Hook is set for the resources of hooks.
+	Hook bool
+}
+
+// Resource is the path of the resource running the image, in the form
+// kind/namespace/name.
+func (r *ImageReference) Resource() string {
+	return fmt.Sprintf("%s/%s/%s", r.Kind, r.Namespace, r.Name)
+}
+
+func (r *ImageReference) String() string {
+	return fmt.Sprintf("%s %s:%s", r.Image, r.Resource(), r.Path)
+}
+
+// releaseImages extracts the images of the resources and hooks of rel.
+func releaseImages(rel *release.Release) ([]*ImageReference, error) {
+	docs, err := renderedDocuments(rel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "release %s", rel.Name)
+	}
+	var images []*ImageReference
+	for _, doc := range docs {
+		base := ImageReference{Release: rel.Name, Template: doc.Template, Namespace: rel.Namespace, Hook: doc.Hook}
+		images = append(images, resourceImages(doc.Object, base)...)
+	}
+	return images, nil
+}
+
+// resourceImages extracts the images of obj, or of its items if it is a list.
+func resourceImages(obj map[string]interface{}, base ImageReference) []*ImageReference {
+	u := &unstructured.Unstructured{Object: obj}
+	if u.IsList() {
+		var images []*ImageReference
+		items, _, _ := unstructured.NestedFieldNoCopy(obj, "items")
+		list, _ := items.([]interface{})
+		for _, item := range list {
+			if m, ok := item.(map[string]interface{}); ok {
+				images = append(images, resourceImages(m, base)...)
+			}
+		}
+		return images
+	}
+	fields, ok := podSpecFields[u.GetKind()]
+	if !ok {
+		return nil
+	}
+	base.Kind = u.GetKind()
+	base.Name = u.GetName()
+	if u.GetNamespace() != "" {
+		base.Namespace = u.GetNamespace()
+	}
+	var images []*ImageReference
+	for _, field := range containerFields {
+		value, _, _ := unstructured.NestedFieldNoCopy(obj, append(append([]string{}, fields...), field)...)
+		containers, _ := value.([]interface{})
+		for i, c := range containers {
+			container, _ := c.(map[string]interface{})
+			image, _ := container["image"].(string)
+			if image == "" {
+				continue
+			}
+			ref := base
+			ref.Image = image
+			ref.Container, _ = container["name"].(string)
+			ref.Path = fmt.Sprintf("%s.%s[%d]", strings.Join(fields, "."), field, i)
+			parseImageReference(&ref)
+			images = append(images, &ref)
+		}
+	}
+	return images
+}
+
+// parseImageReference fills in the repository, tag and digest of ref.Image.
+func parseImageReference(ref *ImageReference) {
+	named, err := reference.ParseNormalizedNamed(ref.Image)
+	if err != nil {
+		return
+	}
+	ref.Repository = named.Name()
+	if tagged, ok := named.(reference.Tagged); ok {
+		ref.Tag = tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		ref.Digest = digested.Digest().String()
+	}
+}
+
+// Images renders the chart located by args like Template and extracts the
+// images of the rendered resources and of the hooks Template prints, which
+// are all of them unless hooks are disabled or tests are skipped.
+func (c *templateClientImpl) Images(args []string) ([]*ImageReference, error) {
+	rel, err := c.renderArgs(args, c.valueOpts)
+	if err != nil {
+		return nil, err
+	}
+	return releaseImages(c.outputRelease(rel))
+}
+
+// ImagesChart renders a chart which is not located through the chart path
+// options like TemplateChart and extracts the images like Images.
+func (c *templateClientImpl) ImagesChart(name string, source ChartSource) ([]*ImageReference, error) {
+	rel, err := c.render(name, source, c.valueOpts)
+	if err != nil {
+		return nil, err
+	}
+	return releaseImages(c.outputRelease(rel))
+}
+
+// outputRelease copies rel with the hooks Template prints.
+func (c *templateClientImpl) outputRelease(rel *release.Release) *release.Release {
+	out := *rel
+	out.Hooks = nil
+	if c.cli.DisableHooks {
+		return &out
+	}
+	for _, h := range rel.Hooks {
+		if c.skipTests && isTestHook(h) {
+			continue
+		}
+		out.Hooks = append(out.Hooks, h)
+	}
+	return &out
+}
+
+// Images extracts the images of the resources and hooks of a release.
+func (c *getManifestClientImpl) Images(name string) ([]*ImageReference, error) {
+	rel, err := c.cli.Run(name)
+	if err != nil {
+		return nil, err
+	}
+	return releaseImages(rel)
+}
+
+// Images extracts the images of the resources and hooks of every listed
+// release. A release whose manifest can't be parsed doesn't stop the others,
+// the images of the others are returned together with an error listing the
+// releases which failed.
+func (c *listClientImpl) Images() ([]*ImageReference, error) {
+	releases, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	var images []*ImageReference
+	var failed []string
+	for _, rel := range releases {
+		relImages, err := releaseImages(rel)
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		images = append(images, relImages...)
+	}
+	if len(failed) > 0 {
+		return images, errors.Errorf("%d release(s) inspected, %d failed: %s", len(releases), len(failed), strings.Join(failed, "; "))
+	}
+	return images, nil
+}
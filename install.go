@@ -32,16 +32,22 @@ const (
 	installDefaultSkipCRDs                 = false
 	installDefaultSubNotes                 = false
 	installDefaultDisableOpenAPIValidation = false
+	installDefaultCapabilities             = ""
 )
 
 type installClient interface {
 	Install(args []string) (*release.Release, error)
+	InstallChart(name string, source ChartSource) (*release.Release, error)
 }
 
 type installClientImpl struct {
 	cli       *action.Install
+	cfg       *action.Configuration
 	env       *helmEnv
 	valueOpts *valueOptions
+	// capabilities is the path of a capabilities snapshot
+	capabilities string
+	fakeCluster  *FakeCluster
 }
 
 type InstallOption struct {
@@ -65,6 +71,8 @@ type installOptions struct {
 	skipCRDs                 bool
 	subNotes                 bool
 	disableOpenAPIValidation bool
+	capabilities             string
+	fakeCluster              *FakeCluster
 }
 
 func (o *installOptions) apply(opts []InstallOption) {
@@ -91,6 +99,8 @@ func newInstallOptions(opts []InstallOption) *installOptions {
 		skipCRDs:                 installDefaultSkipCRDs,
 		subNotes:                 installDefaultSubNotes,
 		disableOpenAPIValidation: installDefaultDisableOpenAPIValidation,
+		capabilities:             installDefaultCapabilities,
+		fakeCluster:              nil,
 	}
 	options.apply(opts)
 	return options
@@ -186,6 +196,25 @@ func InstallWithSubNotes(subNotes bool) InstallOption {
 	}}
 }
 
+// InstallWithCapabilitiesFile renders a dry run as if connected to the cluster
+// captured in a capabilities snapshot, without reaching any cluster. The
+// manifests are not validated against a cluster then, as with
+// InstallWithDisableOpenAPIValidation(true).
+func InstallWithCapabilitiesFile(path string) InstallOption {
+	return InstallOption{f: func(o *installOptions) {
+		o.capabilities = path
+	}}
+}
+
+// InstallWithFakeCluster answers the lookup function of a dry run from a fake
+// cluster state. The dry run then never reaches a cluster, it renders with the
+// default capabilities unless InstallWithCapabilitiesFile is given.
+func InstallWithFakeCluster(fakeCluster *FakeCluster) InstallOption {
+	return InstallOption{f: func(o *installOptions) {
+		o.fakeCluster = fakeCluster
+	}}
+}
+
 func InstallWithDisableOpenAPIValidation(disableOpenAPIValidation bool) InstallOption {
 	return InstallOption{f: func(o *installOptions) {
 		o.disableOpenAPIValidation = disableOpenAPIValidation
@@ -198,7 +227,7 @@ func (c *installClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error
 }
 
 func (c *installClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
@@ -206,6 +235,7 @@ func (c *installClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalO
 	// copy args
 	copyInstallClientOptions(c.cli, client)
 	c.cli = client
+	c.cfg = cfg
 	c.env = env
 	return nil
 }
@@ -231,6 +261,9 @@ func copyInstallClientOptions(oldCli *action.Install, newCli *action.Install) {
 }
 
 func newInstallClient(opts []InstallOption, valueOpts []ValueOption, chartPathOpts []ChartPathOption, env *helmEnv) (*installClientImpl, error) {
+	if err := validateTemplateFuncs(env.templateFuncs()); err != nil {
+		return nil, err
+	}
 	o := newInstallOptions(opts)
 	cfg := new(action.Configuration)
 	err := cfg.Init(env.clientGetter, env.Namespace(), "", debug)
@@ -262,9 +295,12 @@ func newInstallClient(opts []InstallOption, valueOpts []ValueOption, chartPathOp
 	addChartPathOptions(chartPathOpts, c)
 	mergeChartPathOptions(c, &client.ChartPathOptions)
 	return &installClientImpl{
-		cli:       client,
-		env:       env,
-		valueOpts: v,
+		cli:          client,
+		cfg:          cfg,
+		env:          env,
+		valueOpts:    v,
+		capabilities: o.capabilities,
+		fakeCluster:  o.fakeCluster,
 	}, nil
 }
 
@@ -272,10 +308,56 @@ func (c *installClientImpl) Install(args []string) (*release.Release, error) {
 	if len(args) == 0 {
 		return nil, fmt.Errorf("install requires at least 1 argument")
 	}
-	return runInstall(args, c.cli, (*values.Options)(c.valueOpts), os.Stdout, c.env)
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+	rel, err := runInstall(args, c.cli, c.cfg, (*values.Options)(c.valueOpts), os.Stdout, c.env)
+	return c.finish(rel), err
+}
+
+// InstallChart installs a chart which is not located through the chart path
+// options. An empty name is allowed together with InstallWithGenerateName.
+func (c *installClientImpl) InstallChart(name string, source ChartSource) (*release.Release, error) {
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+	rel, err := runInstallChart(name, source, c.cli, c.cfg, (*values.Options)(c.valueOpts), c.env)
+	return c.finish(rel), err
 }
 
-func runInstall(args []string, client *action.Install, valueOpts *values.Options, out io.Writer, env *helmEnv) (*release.Release, error) {
+// prepare makes a dry run with a capabilities snapshot or a fake cluster work
+// without a cluster.
+func (c *installClientImpl) prepare() error {
+	if c.capabilities == "" && c.fakeCluster == nil {
+		return nil
+	}
+	if !c.cli.DryRun {
+		return errors.New("a capabilities snapshot or a fake cluster can only be used with a dry run")
+	}
+	caps, err := loadCapabilities(c.capabilities, nil, nil)
+	if err != nil {
+		return err
+	}
+	if caps != nil {
+		useOfflineCapabilities(c.cfg, caps, c.env.Namespace())
+		c.cli.DisableOpenAPIValidation = true
+	} else {
+		c.cli.ClientOnly = true
+	}
+	if c.fakeCluster != nil {
+		useFakeCluster(c.cli, c.cfg, c.fakeCluster)
+	}
+	return nil
+}
+
+func (c *installClientImpl) finish(rel *release.Release) *release.Release {
+	if c.fakeCluster != nil {
+		finishFakeCluster(c.cli, rel)
+	}
+	return rel
+}
+
+func runInstall(args []string, client *action.Install, cfg *action.Configuration, valueOpts *values.Options, out io.Writer, env *helmEnv) (*release.Release, error) {
 	debug("Original chart version: %q", client.Version)
 	if client.Version == "" && client.Devel {
 		debug("setting version to >0.0.0-0")
@@ -287,7 +369,16 @@ func runInstall(args []string, client *action.Install, valueOpts *values.Options
 	}
 	client.ReleaseName = name
 
-	cp, err := client.ChartPathOptions.LocateChart(chart, env.settings)
+	chartPathOpts, err := chartPathCredentials(client.ChartPathOptions, chart, env)
+	if err != nil {
+		return nil, err
+	}
+	settings, cleanup, err := env.chartRepoSettings(chartPathOpts, chart)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	cp, err := chartPathOpts.LocateChart(chart, settings)
 	if err != nil {
 		return nil, err
 	}
@@ -295,10 +386,22 @@ func runInstall(args []string, client *action.Install, valueOpts *values.Options
 	debug("CHART PATH: %s\n", cp)
 
 	p := getter.All(env.settings)
-	vals, err := valueOpts.MergeValues(p)
+	vals, err := (*valueOptions)(valueOpts).mergeValues(p, env.valuesDecryptors())
 	if err != nil {
 		return nil, err
 	}
+	chartRequested, err := loadInstallChart(cp, client, p, out, env)
+	if err != nil {
+		return nil, err
+	}
+
+	client.Namespace = env.settings.Namespace()
+	return runInstallAction(client, cfg, chartRequested, vals, env)
+}
+
+// loadInstallChart loads the chart located at cp for install, updating its
+// dependencies from the repositories of env if the client asks for it.
+func loadInstallChart(cp string, client *action.Install, p getter.Providers, out io.Writer, env *helmEnv) (*chart.Chart, error) {
 	// Check chart dependencies to make sure all are present in /charts
 	chartRequested, err := loader.Load(cp)
 	if err != nil {
@@ -319,15 +422,20 @@ func runInstall(args []string, client *action.Install, valueOpts *values.Options
 		// https://github.com/helm/helm/issues/2209
 		if err := action.CheckDependencies(chartRequested, req); err != nil {
 			if client.DependencyUpdate {
+				settings, cleanup, err := env.dependencyRepoSettings(req)
+				if err != nil {
+					return nil, err
+				}
+				defer cleanup()
 				man := &downloader.Manager{
 					Out:              out,
 					ChartPath:        cp,
 					Keyring:          client.ChartPathOptions.Keyring,
 					SkipUpdate:       false,
 					Getters:          p,
-					RepositoryConfig: env.settings.RepositoryConfig,
-					RepositoryCache:  env.settings.RepositoryCache,
-					Debug:            env.settings.Debug,
+					RepositoryConfig: settings.RepositoryConfig,
+					RepositoryCache:  settings.RepositoryCache,
+					Debug:            settings.Debug,
 				}
 				if err := man.Update(); err != nil {
 					return nil, err
@@ -341,9 +449,38 @@ func runInstall(args []string, client *action.Install, valueOpts *values.Options
 			}
 		}
 	}
+	return chartRequested, nil
+}
 
-	client.Namespace = env.settings.Namespace()
-	return client.Run(chartRequested, vals)
+func runInstallChart(name string, source ChartSource, client *action.Install, cfg *action.Configuration, valueOpts *values.Options, env *helmEnv) (*release.Release, error) {
+	args := []string{source.String()}
+	if name != "" {
+		args = []string{name, source.String()}
+	}
+	name, _, err := client.NameAndChart(args)
+	if err != nil {
+		return nil, err
+	}
+	client.ReleaseName = name
+
+	p := getter.All(env.settings)
+	vals, err := (*valueOptions)(valueOpts).mergeValues(p, env.valuesDecryptors())
+	if err != nil {
+		return nil, err
+	}
+	chartRequested, err := source.Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChartRequested(chartRequested); err != nil {
+		return nil, err
+	}
+
+	// a namespace set by the caller takes precedence
+	if client.Namespace == "" {
+		client.Namespace = env.settings.Namespace()
+	}
+	return runInstallAction(client, cfg, chartRequested, vals, env)
 }
 
 // checkIfInstallable validates if a chart can be installed
@@ -0,0 +1,141 @@
+package artifacthub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/outgnaY/helm-go-client/internal/version"
+)
+
+// APIPath is the path of the API relative to the base URL.
+const APIPath = "api/v1"
+
+// ErrHostnameNotProvided indicates the url is missing a hostname
+var ErrHostnameNotProvided = errors.New("no hostname provided")
+
+// ErrNotFound is returned when the Artifact Hub has no such package, version
+// or asset.
+var ErrNotFound = errors.New("not found")
+
+// Client represents a client capable of communicating with the Artifact Hub
+// API.
+type Client struct {
+
+	// The base URL for requests, such as https://artifacthub.io
+	BaseURL string
+
+	// The HTTP client requests are made with
+	HTTPClient *http.Client
+}
+
+// New creates a new client
+func New(u string) (*Client, error) {
+
+	// Validate we have a URL
+	if err := validate(u); err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		BaseURL:    u,
+		HTTPClient: http.DefaultClient,
+	}, nil
+}
+
+// Validate if the base URL is valid.
+func validate(u string) error {
+
+	// Check if it is parsable
+	p, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+
+	// Check that a host is attached
+	if p.Hostname() == "" {
+		return ErrHostnameNotProvided
+	}
+
+	return nil
+}
+
+// endpoint is the URL of an API endpoint, with the path elements escaped.
+func (c *Client) endpoint(query url.Values, elem ...string) (string, error) {
+	p, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	raw := path.Join(p.EscapedPath(), APIPath)
+	decoded := path.Join(p.Path, APIPath)
+	for _, e := range elem {
+		raw = path.Join(raw, url.PathEscape(e))
+		decoded = path.Join(decoded, e)
+	}
+	if raw[0] != '/' {
+		raw, decoded = "/"+raw, "/"+decoded
+	}
+	p.Path = decoded
+	p.RawPath = raw
+	p.RawQuery = query.Encode()
+	return p.String(), nil
+}
+
+// get requests an endpoint and returns the response, whose body must be
+// closed.
+func (c *Client) get(query url.Values, elem ...string) (*http.Response, error) {
+	u, err := c.endpoint(query, elem...)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the user agent so that the Artifact Hub can identify where the
+	// request is coming from
+	req.Header.Set("User-Agent", version.GetUserAgent())
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		res.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s : %w", u, ErrNotFound)
+	case res.StatusCode != http.StatusOK:
+		res.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s : %s", u, res.Status)
+	}
+	return res, nil
+}
+
+// getJSON requests an endpoint and decodes the JSON response into v.
+func (c *Client) getJSON(v interface{}, query url.Values, elem ...string) error {
+	res, err := c.get(query, elem...)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// getRaw requests an endpoint and returns the response body.
+func (c *Client) getRaw(elem ...string) ([]byte, error) {
+	res, err := c.get(nil, elem...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(io.LimitReader(res.Body, maxAssetSize))
+}
+
+// maxAssetSize limits the size of the values and schemas read.
+const maxAssetSize = 10 << 20
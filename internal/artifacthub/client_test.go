@@ -0,0 +1,32 @@
+package artifacthub
+
+import (
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	c, err := New("https://artifacthub.io")
+	if err != nil {
+		t.Errorf("error creating client: %s", err)
+	}
+	if c.BaseURL != "https://artifacthub.io" {
+		t.Errorf("incorrect BaseURL. Expected \"https://artifacthub.io\" but got %q", c.BaseURL)
+	}
+	if _, err := New("/no/host"); err != ErrHostnameNotProvided {
+		t.Errorf("expected %q but got %v", ErrHostnameNotProvided, err)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	c, err := New("https://example.com/hub/")
+	if err != nil {
+		t.Fatalf("error creating client: %s", err)
+	}
+	u, err := c.endpoint(nil, "packages", "helm", "my repo", "a/b")
+	if err != nil {
+		t.Fatalf("error making endpoint: %s", err)
+	}
+	if expected := "https://example.com/hub/api/v1/packages/helm/my%20repo/a%2Fb"; u != expected {
+		t.Errorf("expected %q but got %q", expected, u)
+	}
+}
@@ -0,0 +1,6 @@
+// Package artifacthub contains a client for the REST API of the Artifact Hub,
+// https://artifacthub.io/docs/api/, or of a compatible service.
+//
+// It searches packages and retrieves their details, versions, default values,
+// values schema and security report.
+package artifacthub
@@ -0,0 +1,99 @@
+package artifacthub
+
+// Package is the detail of a version of a package
+type Package struct {
+	PackageSummary
+	DisplayName       string             `json:"display_name"`
+	Keywords          []string           `json:"keywords"`
+	HomeURL           string             `json:"home_url"`
+	Readme            string             `json:"readme"`
+	License           string             `json:"license"`
+	ContentURL        string             `json:"content_url"`
+	Digest            string             `json:"digest"`
+	Prerelease        bool               `json:"prerelease"`
+	HasValuesSchema   bool               `json:"has_values_schema"`
+	Maintainers       []Maintainer       `json:"maintainers"`
+	Links             []Link             `json:"links"`
+	AvailableVersions []AvailableVersion `json:"available_versions"`
+	// ContainsSecurityUpdates is whether the version fixes vulnerabilities
+	ContainsSecurityUpdates bool `json:"contains_security_updates"`
+}
+
+// Maintainer is a maintainer of a package
+type Maintainer struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Link is a link of a package
+type Link struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// AvailableVersion is a version of a package
+type AvailableVersion struct {
+	Version                 string `json:"version"`
+	ContainsSecurityUpdates bool   `json:"contains_security_updates"`
+	Prerelease              bool   `json:"prerelease"`
+	// TS is the time the version was created, in seconds since the epoch
+	TS int64 `json:"ts"`
+}
+
+// SecurityReport is the security report of a package version, the Trivy
+// report of each of its images by image name
+type SecurityReport map[string]ImageReport
+
+// ImageReport is the Trivy report of an image
+type ImageReport struct {
+	Results []ImageReportResult `json:"Results"`
+}
+
+// ImageReportResult is the part of a report for a target of an image
+type ImageReportResult struct {
+	Target          string          `json:"Target"`
+	Vulnerabilities []Vulnerability `json:"Vulnerabilities"`
+}
+
+// Vulnerability is a vulnerability found in an image
+type Vulnerability struct {
+	VulnerabilityID  string `json:"VulnerabilityID"`
+	PkgName          string `json:"PkgName"`
+	InstalledVersion string `json:"InstalledVersion"`
+	FixedVersion     string `json:"FixedVersion"`
+	Severity         string `json:"Severity"`
+	Title            string `json:"Title"`
+}
+
+// Package retrieves a version of a package of a kind in a repository, the
+// latest version if version is empty
+func (c *Client) Package(kind string, repository string, name string, version string) (*Package, error) {
+	elem := []string{"packages", kind, repository, name}
+	if version != "" {
+		elem = append(elem, version)
+	}
+	p := &Package{}
+	if err := c.getJSON(p, nil, elem...); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Values retrieves the default values of a package version
+func (c *Client) Values(packageID string, version string) ([]byte, error) {
+	return c.getRaw("packages", packageID, version, "values")
+}
+
+// ValuesSchema retrieves the values schema of a package version
+func (c *Client) ValuesSchema(packageID string, version string) ([]byte, error) {
+	return c.getRaw("packages", packageID, version, "values-schema")
+}
+
+// SecurityReport retrieves the security report of a package version
+func (c *Client) SecurityReport(packageID string, version string) (SecurityReport, error) {
+	report := SecurityReport{}
+	if err := c.getJSON(&report, nil, "packages", packageID, version, "security-report"); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,131 @@
+package artifacthub
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+// Kinds of repositories, as numbered by the Artifact Hub.
+const (
+	KindHelm   = 0
+	KindFalco  = 1
+	KindOPA    = 2
+	KindOLM    = 3
+	KindTekton = 7
+)
+
+// SearchOptions are the filters of a package search. Zero values don't
+// filter.
+type SearchOptions struct {
+	// Query is the text to search for
+	Query string
+	// Kinds are the kinds of repositories the packages are in
+	Kinds []int
+	// Official only finds official packages
+	Official bool
+	// VerifiedPublisher only finds packages of verified publishers
+	VerifiedPublisher bool
+	// Repositories are the names of the repositories the packages are in
+	Repositories []string
+	// Deprecated also finds deprecated packages
+	Deprecated bool
+	// Limit is the number of packages of a page, Offset the number of
+	// packages before it
+	Limit  int
+	Offset int
+}
+
+// SearchResult is a page of packages found by a search.
+type SearchResult struct {
+	Packages []PackageSummary `json:"packages"`
+	// Total is the number of packages found on all pages
+	Total int `json:"-"`
+}
+
+// PackageSummary is a package found by a search
+type PackageSummary struct {
+	PackageID             string                 `json:"package_id"`
+	Name                  string                 `json:"name"`
+	NormalizedName        string                 `json:"normalized_name"`
+	LogoImageID           string                 `json:"logo_image_id"`
+	Stars                 int                    `json:"stars"`
+	Description           string                 `json:"description"`
+	Version               string                 `json:"version"`
+	AppVersion            string                 `json:"app_version"`
+	Deprecated            bool                   `json:"deprecated"`
+	Signed                bool                   `json:"signed"`
+	Official              bool                   `json:"official"`
+	SecurityReportSummary *SecurityReportSummary `json:"security_report_summary"`
+	// TS is the time the version was created, in seconds since the epoch
+	TS         int64      `json:"ts"`
+	Repository Repository `json:"repository"`
+}
+
+// Repository is the repository of a package
+type Repository struct {
+	RepositoryID      string `json:"repository_id"`
+	Kind              int    `json:"kind"`
+	Name              string `json:"name"`
+	DisplayName       string `json:"display_name"`
+	URL               string `json:"url"`
+	VerifiedPublisher bool   `json:"verified_publisher"`
+	Official          bool   `json:"official"`
+	UserAlias         string `json:"user_alias"`
+	OrganizationName  string `json:"organization_name"`
+}
+
+// SecurityReportSummary is the number of vulnerabilities of a package
+// version by severity
+type SecurityReportSummary struct {
+	Critical int `json:"critical"`
+	High     int `json:"high"`
+	Medium   int `json:"medium"`
+	Low      int `json:"low"`
+	Unknown  int `json:"unknown"`
+}
+
+// Search searches the packages of the Artifact Hub
+func (c *Client) Search(opts SearchOptions) (*SearchResult, error) {
+	query := url.Values{}
+	query.Set("facets", "false")
+	if opts.Query != "" {
+		query.Set("ts_query_web", opts.Query)
+	}
+	for _, kind := range opts.Kinds {
+		query.Add("kind", strconv.Itoa(kind))
+	}
+	if opts.Official {
+		query.Set("official", "true")
+	}
+	if opts.VerifiedPublisher {
+		query.Set("verified_publisher", "true")
+	}
+	for _, repository := range opts.Repositories {
+		query.Add("repo", repository)
+	}
+	if opts.Deprecated {
+		query.Set("deprecated", "true")
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+
+	res, err := c.get(query, "packages", "search")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	result := &SearchResult{}
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	result.Total = len(result.Packages)
+	if total, err := strconv.Atoi(res.Header.Get("Pagination-Total-Count")); err == nil {
+		result.Total = total
+	}
+	return result, nil
+}
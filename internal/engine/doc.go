@@ -14,18 +14,13 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package monocular
+/*Package engine implements the Go text template engine as needed for Helm.
 
-import (
-	"testing"
-)
+When Helm renders templates it does so with additional functions and different
+modes (e.g., strict, lint mode). This package handles the helm specific
+implementation.
 
-func TestNew(t *testing.T) {
-	c, err := New("https://hub.helm.sh")
-	if err != nil {
-		t.Errorf("error creating client: %s", err)
-	}
-	if c.BaseURL != "https://hub.helm.sh" {
-		t.Errorf("incorrect BaseURL. Expected \"https://hub.helm.sh\" but got %q", c.BaseURL)
-	}
-}
+It is a copy of helm.sh/helm/v3/pkg/engine which accepts custom template
+functions, as later versions of Helm do.
+*/
+package engine
@@ -1,29 +1,121 @@
 package helmclient
 
 import (
-	"errors"
 	"fmt"
+	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/action"
-	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/lint/support"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
 const (
 	lintDefaultStrict        = false
 	lintDefaultWithSubcharts = false
+	lintDefaultFormat        = LintFormatText
+	lintDefaultKubeVersion   = ""
+	lintDefaultConcurrency   = 4
+	lintDefaultCapabilities  = ""
+)
+
+var lintDefaultExtraAPIs = []string{}
+
+// Rule names of the messages reported by the built-in rules of Helm.
+const (
+	LintRuleHelmChartfile    = "helm/chartfile"
+	LintRuleHelmValues       = "helm/values"
+	LintRuleHelmTemplates    = "helm/templates"
+	LintRuleHelmDependencies = "helm/dependencies"
+	LintRuleHelm             = "helm"
 )
 
 type lintClient interface {
-	Lint(args []string) error
+	Lint(args []string) ([]*LintResult, error)
+	LintChart(source ChartSource) (*LintResult, error)
+	LintMatrix(path string, sets map[string][]ValueOption) (map[string]*LintResult, error)
+	LintValuesDir(path string, dir string) (map[string]*LintResult, error)
 }
 
 type lintClientImpl struct {
-	cli       *action.Lint
-	env       *helmEnv
-	valueOpts *valueOptions
+	cli         *action.Lint
+	env         *helmEnv
+	valueOpts   *valueOptions
+	rules       []LintRule
+	format      LintFormat
+	out         io.Writer
+	kubeVersion string
+	extraAPIs   []string
+	concurrency int
+	// capabilities is the path of a capabilities snapshot
+	capabilities string
+}
+
+// lintRun is the state shared by the charts linted in one call.
+type lintRun struct {
+	vals  map[string]interface{}
+	caps  *chartutil.Capabilities
+	rules []LintRule
+	// customCaps is set when caps are not the default capabilities Helm's
+	// templates rule renders with
+	customCaps bool
+}
+
+// LintSeverity uses the same levels as Helm's lint support package.
+type LintSeverity int
+
+const (
+	LintSeverityUnknown = LintSeverity(support.UnknownSev)
+	LintSeverityInfo    = LintSeverity(support.InfoSev)
+	LintSeverityWarning = LintSeverity(support.WarningSev)
+	LintSeverityError   = LintSeverity(support.ErrorSev)
+)
+
+func (s LintSeverity) String() string {
+	switch s {
+	case LintSeverityInfo:
+		return "INFO"
+	case LintSeverityWarning:
+		return "WARNING"
+	case LintSeverityError:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+// LintMessage is a single problem found in a chart.
+type LintMessage struct {
+	// Rule is the name of the rule which reported the message, one of the
+	// LintRuleHelm* constants for the rules built into Helm.
+	Rule     string
+	Severity LintSeverity
+	// Path is the file the message is about, relative to the chart root.
+	Path string
+	Err  error
+}
+
+func (m LintMessage) String() string {
+	if m.Path == "" {
+		return fmt.Sprintf("[%s] %s", m.Severity, m.Err)
+	}
+	return fmt.Sprintf("[%s] %s: %s", m.Severity, m.Path, m.Err)
+}
+
+// LintResult holds the messages of one linted chart.
+type LintResult struct {
+	Path string
+	// ValueSet names the values the chart was linted with in matrix mode.
+	ValueSet string
+	Messages []LintMessage
+	// Failed is set when the chart has a message at error severity, or at
+	// warning severity in strict mode.
+	Failed bool
 }
 
 type LintOption struct {
@@ -33,6 +125,13 @@ type LintOption struct {
 type lintOptions struct {
 	strict        bool
 	withSubcharts bool
+	rules         []LintRule
+	format        LintFormat
+	out           io.Writer
+	kubeVersion   string
+	extraAPIs     []string
+	concurrency   int
+	capabilities  string
 }
 
 func (o *lintOptions) apply(opts []LintOption) {
@@ -45,6 +144,13 @@ func newLintOptions(opts []LintOption) *lintOptions {
 	options := &lintOptions{
 		strict:        lintDefaultStrict,
 		withSubcharts: lintDefaultWithSubcharts,
+		rules:         []LintRule{},
+		format:        lintDefaultFormat,
+		out:           os.Stdout,
+		kubeVersion:   lintDefaultKubeVersion,
+		extraAPIs:     lintDefaultExtraAPIs,
+		concurrency:   lintDefaultConcurrency,
+		capabilities:  lintDefaultCapabilities,
 	}
 	options.apply(opts)
 	return options
@@ -62,13 +168,65 @@ func LintWithWithSubcharts(withSubcharts bool) LintOption {
 	}}
 }
 
+// LintWithRule adds a custom rule, it runs on every linted chart after the
+// rules of Helm.
+func LintWithRule(rule LintRule) LintOption {
+	return LintOption{f: func(o *lintOptions) {
+		o.rules = append(o.rules, rule)
+	}}
+}
+
+// LintWithReport writes the lint report to out in the given format instead of
+// the text report on stdout.
+func LintWithReport(format LintFormat, out io.Writer) LintOption {
+	return LintOption{f: func(o *lintOptions) {
+		o.format = format
+		o.out = out
+	}}
+}
+
+// LintWithKubeVersion renders the templates for the given Kubernetes version,
+// for the templates rule of Helm as well, and reports the APIs deprecated or
+// removed in it, as well as a kubeVersion constraint of the chart the version
+// does not satisfy.
+func LintWithKubeVersion(kubeVersion string) LintOption {
+	return LintOption{f: func(o *lintOptions) {
+		o.kubeVersion = kubeVersion
+	}}
+}
+
+// LintWithExtraAPIs adds API versions to the capabilities used for rendering.
+// An API listed here is not reported as removed, the cluster still serves it.
+func LintWithExtraAPIs(extraAPIs []string) LintOption {
+	return LintOption{f: func(o *lintOptions) {
+		o.extraAPIs = extraAPIs
+	}}
+}
+
+// LintWithCapabilitiesFile lints for the cluster captured in a capabilities
+// snapshot, as LintWithKubeVersion does for a bare version. APIs the snapshot
+// lists are not reported as removed.
+func LintWithCapabilitiesFile(path string) LintOption {
+	return LintOption{f: func(o *lintOptions) {
+		o.capabilities = path
+	}}
+}
+
+// LintWithConcurrency limits how many value sets LintMatrix and LintValuesDir
+// lint at the same time.
+func LintWithConcurrency(concurrency int) LintOption {
+	return LintOption{f: func(o *lintOptions) {
+		o.concurrency = concurrency
+	}}
+}
+
 func (c *lintClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
 	// use old namespace
 	return c.OverrideGlobalOptsWithNamespace(globalOpts, c.env.Namespace())
 }
 
 func (c *lintClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	client := action.NewLint()
 	// copy args
 	copyLintClientOptions(c.cli, client)
@@ -84,6 +242,9 @@ func copyLintClientOptions(oldCli *action.Lint, newCli *action.Lint) {
 }
 
 func newLintClient(opts []LintOption, valueOpts []ValueOption, env *helmEnv) (*lintClientImpl, error) {
+	if err := validateTemplateFuncs(env.templateFuncs()); err != nil {
+		return nil, err
+	}
 	o := newLintOptions(opts)
 	cfg := new(action.Configuration)
 	err := cfg.Init(env.clientGetter, env.Namespace(), "", debug)
@@ -100,13 +261,23 @@ func newLintClient(opts []LintOption, valueOpts []ValueOption, env *helmEnv) (*l
 	}
 	addValueOptions(valueOpts, v)
 	return &lintClientImpl{
-		cli:       client,
-		env:       env,
-		valueOpts: v,
+		cli:          client,
+		env:          env,
+		valueOpts:    v,
+		rules:        o.rules,
+		format:       o.format,
+		out:          o.out,
+		kubeVersion:  o.kubeVersion,
+		extraAPIs:    o.extraAPIs,
+		concurrency:  o.concurrency,
+		capabilities: o.capabilities,
 	}, nil
 }
 
-func (c *lintClientImpl) Lint(args []string) error {
+// Lint lints the charts at the given paths and writes a report. The
+// results are returned in the order of the paths, followed by the subcharts.
+// If any chart fails, the results are returned together with a summary error.
+func (c *lintClientImpl) Lint(args []string) ([]*LintResult, error) {
 	paths := []string{"."}
 	if len(args) > 0 {
 		paths = args
@@ -125,48 +296,255 @@ func (c *lintClientImpl) Lint(args []string) error {
 			})
 		}
 	}
-	c.cli.Namespace = c.env.Namespace()
-	vals, err := ((*values.Options)(c.valueOpts)).MergeValues(getter.All(c.env.settings))
+	run, err := c.prepare(c.valueOpts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var message strings.Builder
-	failed := 0
+	results := make([]*LintResult, 0, len(paths))
 	for _, path := range paths {
-		fmt.Fprintf(&message, "==> Linting %s\n", path)
+		results = append(results, c.lintPath(path, run))
+	}
+	if err := c.writeReport(results, run); err != nil {
+		return results, err
+	}
+	return results, lintSummary(results)
+}
+
+// LintChart lints a chart which does not live on the disk. Helm lints chart
+// directories only, so the chart is written to a temporary directory first.
+func (c *lintClientImpl) LintChart(source ChartSource) (*LintResult, error) {
+	path, cleanup, err := saveChartSource(source)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	run, err := c.prepare(c.valueOpts)
+	if err != nil {
+		return nil, err
+	}
+	// the directory is named after the chart, use that in the report
+	result := c.lintPath(path, run)
+	result.Path = filepath.Base(path)
+	if err := c.writeReport([]*LintResult{result}, run); err != nil {
+		return result, err
+	}
+	return result, lintSummary([]*LintResult{result})
+}
 
-		result := c.cli.Run([]string{path}, vals)
+func (c *lintClientImpl) prepare(valueOpts *valueOptions) (*lintRun, error) {
+	c.cli.Namespace = c.env.Namespace()
+	vals, err := valueOpts.mergeValues(getter.All(c.env.settings), c.env.valuesDecryptors())
+	if err != nil {
+		return nil, err
+	}
+	run := &lintRun{vals: vals, caps: chartutil.DefaultCapabilities.Copy(), rules: c.rules}
+	if c.kubeVersion == "" && len(c.extraAPIs) == 0 && c.capabilities == "" {
+		return run, nil
+	}
+	var kubeVersion *chartutil.KubeVersion
+	if c.kubeVersion != "" {
+		parsedKubeVersion, err := chartutil.ParseKubeVersion(c.kubeVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kube version '%s': %s", c.kubeVersion, err)
+		}
+		kubeVersion = parsedKubeVersion
+	}
+	caps, err := loadCapabilities(c.capabilities, kubeVersion, c.extraAPIs)
+	if err != nil {
+		return nil, err
+	}
+	served := c.extraAPIs
+	if caps != nil {
+		run.caps = caps
+		served = caps.APIVersions
+	} else {
+		if kubeVersion != nil {
+			run.caps.KubeVersion = *kubeVersion
+		}
+		run.caps.APIVersions = append(append(chartutil.VersionSet{}, run.caps.APIVersions...), c.extraAPIs...)
+	}
+	run.rules = append([]LintRule{kubeVersionRule, newDeprecatedAPIsRule(served)}, c.rules...)
+	run.customCaps = true
+	return run, nil
+}
+
+func (c *lintClientImpl) writeReport(results []*LintResult, run *lintRun) error {
+	report := &lintReport{results: results, strict: c.cli.Strict}
+	for _, rule := range run.rules {
+		report.rules = append(report.rules, rule.Name())
+	}
+	return writeLintReport(c.out, c.format, report)
+}
 
-		// All the Errors that are generated by a chart
-		// that failed a lint will be included in the
-		// results.Messages so we only need to print
-		// the Errors if there are no Messages.
-		if len(result.Messages) == 0 {
-			for _, err := range result.Errors {
-				fmt.Fprintf(&message, "Error %s\n", err)
+func (c *lintClientImpl) lintPath(path string, run *lintRun) *LintResult {
+	result := &LintResult{Path: path}
+	lintPath, original, cleanup, err := c.prerender(path, run)
+	if err != nil {
+		result.Messages = []LintMessage{{Rule: LintRuleHelmTemplates, Severity: LintSeverityError, Path: "templates/", Err: err}}
+		result.Failed = true
+		return result
+	}
+	defer cleanup()
+	helmResult := c.cli.Run([]string{lintPath}, run.vals)
+
+	// All the Errors that are generated by a chart that failed a lint are
+	// included in the Messages, except when the chart could not be read at all.
+	if len(helmResult.Messages) == 0 {
+		for _, err := range helmResult.Errors {
+			result.Messages = append(result.Messages, LintMessage{Rule: LintRuleHelm, Severity: LintSeverityError, Err: err})
+		}
+	}
+	chartDir, _ := filepath.Abs(lintPath)
+	for _, msg := range helmResult.Messages {
+		if original != nil && isTemplateSourceCheck(msg.Err) {
+			// reported for the original templates below
+			continue
+		}
+		msgPath := msg.Path
+		// the dependencies rule reports the absolute chart directory
+		if filepath.IsAbs(msgPath) {
+			if rel, err := filepath.Rel(chartDir, msgPath); err == nil {
+				msgPath = filepath.ToSlash(rel)
+			}
+			if msgPath == "." {
+				msgPath = ""
 			}
 		}
+		result.Messages = append(result.Messages, LintMessage{
+			Rule:     helmLintRule(msg.Path),
+			Severity: LintSeverity(msg.Severity),
+			Path:     msgPath,
+			Err:      msg.Err,
+		})
+	}
 
-		for _, msg := range result.Messages {
-			fmt.Fprintf(&message, "%s\n", msg)
+	if original != nil {
+		result.Messages = append(result.Messages, checkTemplateSources(original)...)
+	}
+
+	if len(run.rules) > 0 && helmResult.TotalChartsLinted > 0 {
+		result.Messages = append(result.Messages, c.runRules(lintPath, run)...)
+	}
+
+	lowestTolerance := LintSeverityError
+	if c.cli.Strict {
+		lowestTolerance = LintSeverityWarning
+	}
+	for _, msg := range result.Messages {
+		if msg.Severity >= lowestTolerance {
+			result.Failed = true
 		}
+	}
+	return result
+}
 
-		if len(result.Errors) != 0 {
-			failed++
+// prerender renders the chart at path with the custom template functions and
+// the capabilities of the run and writes the output to a temporary directory
+// for the rules of Helm, which don't know the functions and always render with
+// the default capabilities. The original chart is returned next to it for the
+// checks which look at the template source. Without custom functions or
+// capabilities the chart is linted as it is.
+func (c *lintClientImpl) prerender(path string, run *lintRun) (string, *chart.Chart, func(), error) {
+	funcs := c.env.templateFuncs()
+	if len(funcs) == 0 && !run.customCaps {
+		return path, nil, func() {}, nil
+	}
+	ch, err := loader.Load(path)
+	if err != nil {
+		// the rules of Helm report why
+		return path, nil, func() {}, nil
+	}
+	static, err := prerenderLintChart(ch, run.vals, c.cli.Namespace, run.caps, funcs)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	lintPath, cleanup, err := saveChartSource(ChartFromObject(static))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return lintPath, ch, cleanup, nil
+}
+
+// templateSourceChecks are the checks of the templates rule of Helm which look
+// at the template source instead of the rendered output. A prerendered chart
+// has lost its source, so they run on the original chart instead.
+var templateSourceChecks = []struct {
+	severity LintSeverity
+	search   *regexp.Regexp
+	err      error
+}{
+	{
+		severity: LintSeverityWarning,
+		search:   regexp.MustCompile(`"?helm\.sh/hook"?:\s+crd-install`),
+		err:      errors.New("manifest is a crd-install hook. This hook is no longer supported in v3 and all CRDs should also exist the crds/ directory at the top level of the chart"),
+	},
+	{
+		severity: LintSeverityError,
+		search:   regexp.MustCompile(`\.Release\.Time`),
+		err:      errors.New(".Release.Time has been removed in v3, please replace with the `now` function in your templates"),
+	},
+}
+
+func isTemplateSourceCheck(err error) bool {
+	for _, check := range templateSourceChecks {
+		if err != nil && err.Error() == check.err.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTemplateSources runs templateSourceChecks on the templates of ch, the
+// way the templates rule of Helm does.
+func checkTemplateSources(ch *chart.Chart) []LintMessage {
+	var messages []LintMessage
+	for _, t := range ch.Templates {
+		for _, check := range templateSourceChecks {
+			if check.search.Match(t.Data) {
+				messages = append(messages, LintMessage{Rule: LintRuleHelmTemplates, Severity: check.severity, Path: t.Name, Err: check.err})
+			}
 		}
+	}
+	return messages
+}
 
-		// Adding extra new line here to break up the
-		// results, stops this from being a big wall of
-		// text and makes it easier to follow.
-		fmt.Fprint(&message, "\n")
+func (c *lintClientImpl) runRules(path string, run *lintRun) []LintMessage {
+	ch, err := loader.Load(path)
+	if err != nil {
+		// already reported by the rules of Helm
+		return nil
+	}
+	target := &LintTarget{Path: path, Chart: ch, Capabilities: run.caps}
+	target.Values, target.Objects, err = renderLintObjects(ch, run.vals, c.cli.Namespace, run.caps)
+	if err != nil {
+		debug("custom lint rules run without rendered objects: %s", err)
 	}
-	fmt.Fprint(os.Stdout, message.String())
+	return runLintRules(run.rules, target)
+}
 
-	summary := fmt.Sprintf("%d chart(s) linted, %d chart(s) failed", len(paths), failed)
+// helmLintRule tells which built-in rule reported a message from its path.
+func helmLintRule(path string) string {
+	switch {
+	case path == "Chart.yaml":
+		return LintRuleHelmChartfile
+	case path == "values.yaml":
+		return LintRuleHelmValues
+	case strings.HasPrefix(path, "templates/"):
+		return LintRuleHelmTemplates
+	}
+	return LintRuleHelmDependencies
+}
+
+func lintSummary(results []*LintResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.Failed {
+			failed++
+		}
+	}
 	if failed > 0 {
-		return errors.New(summary)
+		return fmt.Errorf("%d chart(s) linted, %d chart(s) failed", len(results), failed)
 	}
-	fmt.Fprintln(os.Stdout, summary)
 	return nil
 }
 
@@ -0,0 +1,168 @@
+package helmclient
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// Rule names of the checks Lint adds when linting for a target cluster.
+const (
+	LintRuleKubeVersion    = "kube/version"
+	LintRuleDeprecatedAPIs = "kube/deprecated-apis"
+)
+
+// apiDeprecation is an entry of the Kubernetes deprecated API migration guide.
+type apiDeprecation struct {
+	apiVersion   string
+	kind         string
+	deprecatedIn string
+	removedIn    string
+	replacement  string
+}
+
+// apiDeprecations is the bundled deprecation table, following
+// https://kubernetes.io/docs/reference/using-api/deprecation-guide/.
+var apiDeprecations = []apiDeprecation{
+	{"extensions/v1beta1", "Deployment", "1.9", "1.16", "apps/v1"},
+	{"extensions/v1beta1", "DaemonSet", "1.9", "1.16", "apps/v1"},
+	{"extensions/v1beta1", "ReplicaSet", "1.9", "1.16", "apps/v1"},
+	{"extensions/v1beta1", "NetworkPolicy", "1.9", "1.16", "networking.k8s.io/v1"},
+	{"extensions/v1beta1", "PodSecurityPolicy", "1.10", "1.16", "policy/v1beta1"},
+	{"apps/v1beta1", "Deployment", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta1", "StatefulSet", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta2", "Deployment", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta2", "DaemonSet", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta2", "ReplicaSet", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta2", "StatefulSet", "1.9", "1.16", "apps/v1"},
+
+	{"extensions/v1beta1", "Ingress", "1.14", "1.22", "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "Ingress", "1.19", "1.22", "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "IngressClass", "1.19", "1.22", "networking.k8s.io/v1"},
+	{"apiextensions.k8s.io/v1beta1", "CustomResourceDefinition", "1.16", "1.22", "apiextensions.k8s.io/v1"},
+	{"admissionregistration.k8s.io/v1beta1", "MutatingWebhookConfiguration", "1.16", "1.22", "admissionregistration.k8s.io/v1"},
+	{"admissionregistration.k8s.io/v1beta1", "ValidatingWebhookConfiguration", "1.16", "1.22", "admissionregistration.k8s.io/v1"},
+	{"apiregistration.k8s.io/v1beta1", "APIService", "1.19", "1.22", "apiregistration.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRole", "1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRoleBinding", "1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "Role", "1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "RoleBinding", "1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	{"scheduling.k8s.io/v1beta1", "PriorityClass", "1.14", "1.22", "scheduling.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "CSIDriver", "1.19", "1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "CSINode", "1.17", "1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "StorageClass", "1.19", "1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "VolumeAttachment", "1.19", "1.22", "storage.k8s.io/v1"},
+	{"certificates.k8s.io/v1beta1", "CertificateSigningRequest", "1.19", "1.22", "certificates.k8s.io/v1"},
+	{"coordination.k8s.io/v1beta1", "Lease", "1.19", "1.22", "coordination.k8s.io/v1"},
+
+	{"batch/v1beta1", "CronJob", "1.21", "1.25", "batch/v1"},
+	{"discovery.k8s.io/v1beta1", "EndpointSlice", "1.21", "1.25", "discovery.k8s.io/v1"},
+	{"events.k8s.io/v1beta1", "Event", "1.19", "1.25", "events.k8s.io/v1"},
+	{"autoscaling/v2beta1", "HorizontalPodAutoscaler", "1.22", "1.25", "autoscaling/v2"},
+	{"policy/v1beta1", "PodDisruptionBudget", "1.21", "1.25", "policy/v1"},
+	{"policy/v1beta1", "PodSecurityPolicy", "1.21", "1.25", ""},
+	{"node.k8s.io/v1beta1", "RuntimeClass", "1.20", "1.25", "node.k8s.io/v1"},
+
+	{"autoscaling/v2beta2", "HorizontalPodAutoscaler", "1.23", "1.26", "autoscaling/v2"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "FlowSchema", "1.23", "1.26", "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "PriorityLevelConfiguration", "1.23", "1.26", "flowcontrol.apiserver.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "CSIStorageCapacity", "1.24", "1.27", "storage.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "FlowSchema", "1.26", "1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "PriorityLevelConfiguration", "1.26", "1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "FlowSchema", "1.29", "1.32", "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "PriorityLevelConfiguration", "1.29", "1.32", "flowcontrol.apiserver.k8s.io/v1"},
+}
+
+func findAPIDeprecation(apiVersion string, kind string) *apiDeprecation {
+	for i := range apiDeprecations {
+		if apiDeprecations[i].apiVersion == apiVersion && apiDeprecations[i].kind == kind {
+			return &apiDeprecations[i]
+		}
+	}
+	return nil
+}
+
+// kubeMinorVersion drops the patch level and any pre-release suffix, so that
+// for example v1.22.0-gke.1 counts as 1.22.
+func kubeMinorVersion(version string) (*semver.Version, error) {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return semver.NewVersion(fmt.Sprintf("%d.%d", v.Major(), v.Minor()))
+}
+
+// kubeVersionRule checks the kubeVersion constraint of the chart against the
+// target version, like install does.
+var kubeVersionRule = LintRuleFunc(LintRuleKubeVersion, func(target *LintTarget) []LintMessage {
+	constraint := target.Chart.Metadata.KubeVersion
+	if constraint == "" || target.Capabilities == nil {
+		return nil
+	}
+	kubeVersion := target.Capabilities.KubeVersion.String()
+	if chartutil.IsCompatibleRange(constraint, kubeVersion) {
+		return nil
+	}
+	return []LintMessage{{
+		Severity: LintSeverityError,
+		Path:     chartutil.ChartfileName,
+		Err:      fmt.Errorf("chart requires kubeVersion: %s which is incompatible with Kubernetes %s", constraint, kubeVersion),
+	}}
+})
+
+// deprecatedAPIsRule reports rendered objects whose API is deprecated or
+// removed in the target version.
+type deprecatedAPIsRule struct {
+	// served are known to be served by the cluster, even if the table says
+	// otherwise
+	served chartutil.VersionSet
+}
+
+func newDeprecatedAPIsRule(served []string) LintRule {
+	return &deprecatedAPIsRule{served: served}
+}
+
+func (r *deprecatedAPIsRule) Name() string {
+	return LintRuleDeprecatedAPIs
+}
+
+func (r *deprecatedAPIsRule) Check(target *LintTarget) []LintMessage {
+	if target.Capabilities == nil {
+		return nil
+	}
+	kubeVersion, err := kubeMinorVersion(target.Capabilities.KubeVersion.String())
+	if err != nil {
+		return nil
+	}
+	var messages []LintMessage
+	for _, obj := range target.Objects {
+		apiVersion, kind := obj.Object.GetAPIVersion(), obj.Object.GetKind()
+		dep := findAPIDeprecation(apiVersion, kind)
+		if dep == nil {
+			continue
+		}
+		removedIn := semver.MustParse(dep.removedIn)
+		deprecatedIn := semver.MustParse(dep.deprecatedIn)
+		served := r.served.Has(apiVersion) || r.served.Has(apiVersion+"/"+kind)
+
+		var msg LintMessage
+		switch {
+		case !kubeVersion.LessThan(removedIn) && !served:
+			msg = LintMessage{Severity: LintSeverityError, Err: fmt.Errorf("%s %s is removed in Kubernetes v%s%s", apiVersion, kind, dep.removedIn, dep.advice())}
+		case !kubeVersion.LessThan(deprecatedIn):
+			msg = LintMessage{Severity: LintSeverityWarning, Err: fmt.Errorf("%s %s is deprecated since Kubernetes v%s and removed in v%s%s", apiVersion, kind, dep.deprecatedIn, dep.removedIn, dep.advice())}
+		default:
+			continue
+		}
+		msg.Path = obj.Path
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func (d *apiDeprecation) advice() string {
+	if d.replacement == "" {
+		return ", it has no replacement"
+	}
+	return fmt.Sprintf(", use %s %s instead", d.replacement, d.kind)
+}
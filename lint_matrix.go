@@ -0,0 +1,99 @@
+package helmclient
+
+import (
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LintMatrix lints the chart at path once for every named value set, like
+// ct lint does with the ci values of a chart. The options of a set are applied
+// on top of the value options of the client. Sets are linted concurrently, up
+// to the limit given by LintWithConcurrency, and the report lists them in the
+// order of their names.
+func (c *lintClientImpl) LintMatrix(path string, sets map[string][]ValueOption) (map[string]*LintResult, error) {
+	if len(sets) == 0 {
+		return nil, errors.New("no value sets to lint with")
+	}
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	concurrency := c.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	results := make([]*LintResult, len(names))
+	// the runs only differ in their values, any of them describes the rules
+	reportRun := &lintRun{rules: c.rules}
+	runs := make([]*lintRun, len(names))
+	for i, name := range names {
+		run, err := c.prepare(c.valueOpts.with(sets[name]))
+		if err != nil {
+			results[i] = &LintResult{
+				Path:     path,
+				ValueSet: name,
+				Messages: []LintMessage{{Rule: LintRuleHelmValues, Severity: LintSeverityError, Err: err}},
+				Failed:   true,
+			}
+			continue
+		}
+		runs[i] = run
+		reportRun = run
+	}
+
+	var wg sync.WaitGroup
+	for i, run := range runs {
+		if run == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, run *lintRun) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			result := c.lintPath(path, run)
+			result.ValueSet = names[i]
+			results[i] = result
+		}(i, run)
+	}
+	wg.Wait()
+
+	byName := make(map[string]*LintResult, len(results))
+	for _, result := range results {
+		byName[result.ValueSet] = result
+	}
+	if err := c.writeReport(results, reportRun); err != nil {
+		return byName, err
+	}
+	return byName, lintSummary(results)
+}
+
+// LintValuesDir runs LintMatrix with a value set for every .yaml or .yml file
+// in dir, named after the file without its extension. The file is added to the
+// values files of the client.
+func (c *lintClientImpl) LintValuesDir(path string, dir string) (map[string]*LintResult, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sets := map[string][]ValueOption{}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		valueFiles := append(append([]string{}, c.valueOpts.ValueFiles...), filepath.Join(dir, f.Name()))
+		sets[strings.TrimSuffix(f.Name(), ext)] = []ValueOption{WithValueFiles(valueFiles)}
+	}
+	if len(sets) == 0 {
+		return nil, errors.Errorf("no values files found in %s", dir)
+	}
+	return c.LintMatrix(path, sets)
+}
@@ -0,0 +1,326 @@
+package helmclient
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LintFormat selects how Lint reports its results.
+type LintFormat string
+
+const (
+	LintFormatText  LintFormat = "text"
+	LintFormatSARIF LintFormat = "sarif"
+	LintFormatJUnit LintFormat = "junit"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	lintToolName = "helm-lint"
+	lintToolURI  = "https://helm.sh/docs/helm/helm_lint/"
+)
+
+var helmLintRules = []string{LintRuleHelmChartfile, LintRuleHelmValues, LintRuleHelmTemplates, LintRuleHelmDependencies}
+
+var helmLintRuleDescriptions = map[string]string{
+	LintRuleHelm:             "The chart can be read",
+	LintRuleHelmChartfile:    "Chart.yaml is well formed",
+	LintRuleHelmValues:       "values.yaml is well formed and matches values.schema.json",
+	LintRuleHelmTemplates:    "Templates render to valid Kubernetes manifests",
+	LintRuleHelmDependencies: "Dependencies are declared and present",
+	LintRuleKubeVersion:      "The chart supports the target Kubernetes version",
+	LintRuleDeprecatedAPIs:   "Rendered objects use APIs served by the target Kubernetes version",
+}
+
+// lintReport has what the report writers need besides the results.
+type lintReport struct {
+	results []*LintResult
+	// rules are the names of the custom rules, the built-in rules always run
+	rules  []string
+	strict bool
+}
+
+func writeLintReport(w io.Writer, format LintFormat, report *lintReport) error {
+	switch format {
+	case LintFormatText, "":
+		writeLintText(w, report.results)
+		return nil
+	case LintFormatSARIF:
+		return writeLintSARIF(w, report)
+	case LintFormatJUnit:
+		return writeLintJUnit(w, report)
+	}
+	return fmt.Errorf("unknown lint format %q", format)
+}
+
+func writeLintText(w io.Writer, results []*LintResult) {
+	var message strings.Builder
+	failed := 0
+	for _, result := range results {
+		if result.ValueSet != "" {
+			fmt.Fprintf(&message, "==> Linting %s with values %s\n", result.Path, result.ValueSet)
+		} else {
+			fmt.Fprintf(&message, "==> Linting %s\n", result.Path)
+		}
+		for _, msg := range result.Messages {
+			fmt.Fprintf(&message, "%s\n", msg)
+		}
+		if result.Failed {
+			failed++
+		}
+
+		// Adding extra new line here to break up the
+		// results, stops this from being a big wall of
+		// text and makes it easier to follow.
+		fmt.Fprint(&message, "\n")
+	}
+	fmt.Fprintf(&message, "%d chart(s) linted, %d chart(s) failed\n", len(results), failed)
+	fmt.Fprint(w, message.String())
+}
+
+// failing tells whether msg fails the chart.
+func (r *lintReport) failing(msg LintMessage) bool {
+	if r.strict {
+		return msg.Severity >= LintSeverityWarning
+	}
+	return msg.Severity >= LintSeverityError
+}
+
+// lintLocation is the path of the file a message is about relative to the
+// chart root, in slash form. Messages about the chart as a whole point at its
+// Chart.yaml.
+func lintLocation(msg LintMessage) string {
+	if msg.Path == "" {
+		return chartutil.ChartfileName
+	}
+	return path.Clean(filepath.ToSlash(msg.Path))
+}
+
+// sarifChartRoot returns the artifact location of the root of the linted chart
+// at chartPath, locations of results are relative to it.
+func sarifChartRoot(chartPath string) sarifArtifactLocation {
+	root := sarifArtifactLocation{Description: &sarifMessage{Text: chartPath}}
+	if filepath.IsAbs(chartPath) {
+		root.URI = (&url.URL{Scheme: "file", Path: strings.TrimSuffix(filepath.ToSlash(chartPath), "/") + "/"}).String()
+	}
+	return root
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult                    `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string        `json:"id"`
+	ShortDescription *sarifMessage `json:"shortDescription,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	RuleIndex  int               `json:"ruleIndex"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI         string        `json:"uri,omitempty"`
+	URIBaseID   string        `json:"uriBaseId,omitempty"`
+	Description *sarifMessage `json:"description,omitempty"`
+}
+
+func sarifLevel(severity LintSeverity) string {
+	switch severity {
+	case LintSeverityError:
+		return "error"
+	case LintSeverityWarning:
+		return "warning"
+	case LintSeverityInfo:
+		return "note"
+	}
+	return "none"
+}
+
+func writeLintSARIF(w io.Writer, report *lintReport) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           lintToolName,
+			InformationURI: lintToolURI,
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	ruleIndex := map[string]int{}
+	addRule := func(id string) int {
+		if i, ok := ruleIndex[id]; ok {
+			return i
+		}
+		rule := sarifRule{ID: id}
+		if desc, ok := helmLintRuleDescriptions[id]; ok {
+			rule.ShortDescription = &sarifMessage{Text: desc}
+		}
+		ruleIndex[id] = len(run.Tool.Driver.Rules)
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+		return ruleIndex[id]
+	}
+	for _, id := range append(helmLintRules[:len(helmLintRules):len(helmLintRules)], report.rules...) {
+		addRule(id)
+	}
+	// every linted chart gets a base id, in matrix mode the results of a chart
+	// share it
+	baseIDs := map[string]string{}
+	for _, result := range report.results {
+		var properties map[string]string
+		if result.ValueSet != "" {
+			properties = map[string]string{"valueSet": result.ValueSet}
+		}
+		baseID, ok := baseIDs[result.Path]
+		if !ok {
+			baseID = fmt.Sprintf("CHART%d", len(baseIDs)+1)
+			baseIDs[result.Path] = baseID
+			if run.OriginalURIBaseIDs == nil {
+				run.OriginalURIBaseIDs = map[string]sarifArtifactLocation{}
+			}
+			run.OriginalURIBaseIDs[baseID] = sarifChartRoot(result.Path)
+		}
+		for _, msg := range result.Messages {
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    msg.Rule,
+				RuleIndex: addRule(msg.Rule),
+				Level:     sarifLevel(msg.Severity),
+				Message:   sarifMessage{Text: msg.Err.Error()},
+				Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: lintLocation(msg), URIBaseID: baseID},
+				}}},
+				Properties: properties,
+			})
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&sarifLog{Schema: sarifSchema, Version: sarifVersion, Runs: []sarifRun{run}})
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeLintJUnit writes a test suite per chart with a test case per rule. A
+// rule fails when it reported a message which fails the chart, messages of
+// lower severity are kept in the output of the test case.
+func writeLintJUnit(w io.Writer, report *lintReport) error {
+	suites := junitTestSuites{Name: lintToolName}
+	for _, result := range report.results {
+		name := result.Path
+		if result.ValueSet != "" {
+			name = fmt.Sprintf("%s[%s]", result.Path, result.ValueSet)
+		}
+		suite := junitTestSuite{Name: name}
+		rules := append(helmLintRules[:len(helmLintRules):len(helmLintRules)], report.rules...)
+		byRule := map[string][]LintMessage{}
+		for _, msg := range result.Messages {
+			if _, ok := byRule[msg.Rule]; !ok && !containsString(rules, msg.Rule) {
+				rules = append(rules, msg.Rule)
+			}
+			byRule[msg.Rule] = append(byRule[msg.Rule], msg)
+		}
+		for _, rule := range rules {
+			tc := junitTestCase{Name: rule, ClassName: name}
+			var failures, output []string
+			for _, msg := range byRule[rule] {
+				if report.failing(msg) {
+					failures = append(failures, msg.String())
+				} else {
+					output = append(output, msg.String())
+				}
+			}
+			if len(failures) > 0 {
+				tc.Failure = &junitFailure{
+					Message: fmt.Sprintf("%d problem(s) found", len(failures)),
+					Type:    LintSeverityError.String(),
+					Text:    strings.Join(failures, "\n"),
+				}
+				suite.Failures++
+			}
+			tc.SystemOut = strings.Join(output, "\n")
+			suite.TestCases = append(suite.TestCases, tc)
+		}
+		suite.Tests = len(suite.TestCases)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Suites = append(suites.Suites, suite)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(&suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
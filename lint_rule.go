@@ -0,0 +1,135 @@
+package helmclient
+
+import (
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"path"
+	"sort"
+	"strings"
+)
+
+const lintReleaseName = "test-release"
+
+// LintRule is a custom check run by Lint next to the rules built into Helm.
+type LintRule interface {
+	// Name identifies the rule in lint results and reports.
+	Name() string
+	// Check returns the problems found in target. The Rule of a returned
+	// message is filled in with Name when left empty, messages without Err
+	// are dropped.
+	Check(target *LintTarget) []LintMessage
+}
+
+// LintTarget is what a LintRule checks: one chart together with the objects
+// rendered from it.
+type LintTarget struct {
+	// Path is the chart path as given to Lint.
+	Path   string
+	Chart  *chart.Chart
+	Values chartutil.Values
+	// Capabilities are the ones the chart was rendered with.
+	Capabilities *chartutil.Capabilities
+	// Objects is empty when the chart fails to render, the render error is
+	// already reported by Helm.
+	Objects []*LintObject
+}
+
+// LintObject is a Kubernetes object rendered from a template.
+type LintObject struct {
+	// Path is the template file relative to the chart root, e.g.
+	// templates/deployment.yaml or charts/sub/templates/service.yaml.
+	Path   string
+	Object *unstructured.Unstructured
+}
+
+type lintRuleFunc struct {
+	name  string
+	check func(target *LintTarget) []LintMessage
+}
+
+// LintRuleFunc adapts a function to the LintRule interface.
+func LintRuleFunc(name string, check func(target *LintTarget) []LintMessage) LintRule {
+	return &lintRuleFunc{name: name, check: check}
+}
+
+func (r *lintRuleFunc) Name() string {
+	return r.name
+}
+
+func (r *lintRuleFunc) Check(target *LintTarget) []LintMessage {
+	return r.check(target)
+}
+
+// runLintRules checks a loaded chart with the custom rules.
+func runLintRules(rules []LintRule, target *LintTarget) []LintMessage {
+	var messages []LintMessage
+	for _, rule := range rules {
+		for _, msg := range rule.Check(target) {
+			if msg.Err == nil {
+				continue
+			}
+			if msg.Rule == "" {
+				msg.Rule = rule.Name()
+			}
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// renderLintObjects renders the chart the same way the template rule of Helm
+// does and decodes every document of the output. Documents which are not valid
+// YAML are skipped, Helm reports them.
+func renderLintObjects(ch *chart.Chart, vals map[string]interface{}, namespace string, caps *chartutil.Capabilities) (chartutil.Values, []*LintObject, error) {
+	options := chartutil.ReleaseOptions{
+		Name:      lintReleaseName,
+		Namespace: namespace,
+	}
+	cvals, err := chartutil.CoalesceValues(ch, vals)
+	if err != nil {
+		return nil, nil, err
+	}
+	valuesToRender, err := chartutil.ToRenderValues(ch, cvals, options, caps)
+	if err != nil {
+		return cvals, nil, err
+	}
+	var e engine.Engine
+	e.LintMode = true
+	rendered, err := e.Render(ch, valuesToRender)
+	if err != nil {
+		return cvals, nil, err
+	}
+
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		if strings.HasSuffix(name, "NOTES.txt") || strings.HasPrefix(path.Base(name), "_") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var objects []*LintObject
+	for _, name := range names {
+		decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(rendered[name]), 4096)
+		for {
+			obj := map[string]interface{}{}
+			// io.EOF ends the file, any other error can't be recovered from
+			// within the same file
+			if err := decoder.Decode(&obj); err != nil {
+				break
+			}
+			if len(obj) == 0 {
+				continue
+			}
+			objects = append(objects, &LintObject{
+				Path:   strings.TrimPrefix(name, ch.Name()+"/"),
+				Object: &unstructured.Unstructured{Object: obj},
+			})
+		}
+	}
+	return cvals, objects, nil
+}
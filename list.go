@@ -27,6 +27,7 @@ const (
 
 type listClient interface {
 	List() ([]*release.Release, error)
+	Images() ([]*ImageReference, error)
 }
 
 type listClientImpl struct {
@@ -187,7 +188,7 @@ func (c *listClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
 }
 
 func (c *listClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
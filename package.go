@@ -3,6 +3,7 @@ package helmclient
 import (
 	"fmt"
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/cli/values"
 	"helm.sh/helm/v3/pkg/downloader"
 	"helm.sh/helm/v3/pkg/getter"
@@ -124,7 +125,7 @@ func (c *packageClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error
 }
 
 func (c *packageClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	client := action.NewPackage()
 	// copy args
 	copyPackageClientOptions(c.cli, client)
@@ -191,17 +192,7 @@ func (c *packageClientImpl) Package(args []string) error {
 		}
 
 		if c.cli.DependencyUpdate {
-			downloadManager := &downloader.Manager{
-				Out:              ioutil.Discard,
-				ChartPath:        path,
-				Keyring:          c.cli.Keyring,
-				Getters:          p,
-				Debug:            c.env.settings.Debug,
-				RepositoryConfig: c.env.settings.RepositoryConfig,
-				RepositoryCache:  c.env.settings.RepositoryCache,
-			}
-
-			if err := downloadManager.Update(); err != nil {
+			if err := c.updateDependencies(path, p); err != nil {
 				return err
 			}
 		}
@@ -214,6 +205,30 @@ func (c *packageClientImpl) Package(args []string) error {
 	return nil
 }
 
+// updateDependencies updates the dependencies of the chart in path with the
+// settings of the repositories they come from.
+func (c *packageClientImpl) updateDependencies(path string, p getter.Providers) error {
+	ch, err := loader.LoadDir(path)
+	if err != nil {
+		return err
+	}
+	settings, cleanup, err := c.env.dependencyRepoSettings(ch.Metadata.Dependencies)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	downloadManager := &downloader.Manager{
+		Out:              ioutil.Discard,
+		ChartPath:        path,
+		Keyring:          c.cli.Keyring,
+		Getters:          p,
+		Debug:            c.env.settings.Debug,
+		RepositoryConfig: settings.RepositoryConfig,
+		RepositoryCache:  c.env.settings.RepositoryCache,
+	}
+	return downloadManager.Update()
+}
+
 func mergePackageOptions(o *packageOptions, cli *action.Package) {
 	cli.Sign = o.sign
 	cli.Key = o.key
@@ -89,7 +89,7 @@ func (c *pullClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
 }
 
 func (c *pullClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
@@ -141,14 +141,22 @@ func newPullClient(opts []PullOption, chartPathOpts []ChartPathOption, env *helm
 }
 
 func (c *pullClientImpl) Pull(args []string) error {
-	c.cli.Settings = c.env.settings
+	settings := c.cli.Settings
+	defer func() { c.cli.Settings = settings }()
 	if c.cli.Version == "" && c.cli.Devel {
 		debug("setting version to >0.0.0-0")
 		c.cli.Version = ">0.0.0-0"
 	}
 
+	chartPathOpts := c.cli.ChartPathOptions
+	defer func() { c.cli.ChartPathOptions = chartPathOpts }()
 	for i := 0; i < len(args); i++ {
-		output, err := c.cli.Run(args[i])
+		opts, err := chartPathCredentials(chartPathOpts, args[i], c.env)
+		if err != nil {
+			return err
+		}
+		c.cli.ChartPathOptions = opts
+		output, err := c.pull(args[i])
 		if err != nil {
 			return err
 		}
@@ -157,6 +165,17 @@ func (c *pullClientImpl) Pull(args []string) error {
 	return nil
 }
 
+// pull pulls chart with the settings of the repository it is pulled from.
+func (c *pullClientImpl) pull(chart string) (string, error) {
+	settings, cleanup, err := c.env.chartRepoSettings(c.cli.ChartPathOptions, chart)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	c.cli.Settings = settings
+	return c.cli.Run(chart)
+}
+
 func mergePullOptions(o *pullOptions, cli *action.Pull) {
 	cli.Devel = o.devel
 	cli.Untar = o.untar
@@ -55,7 +55,7 @@ func (c *registryLoginClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption)
 }
 
 func (c *registryLoginClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	registryClient, err := registry.NewClient(
 		registry.ClientOptDebug(env.settings.Debug),
 		registry.ClientOptWriter(os.Stdout),
@@ -24,7 +24,7 @@ func (c *registryLogoutClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption)
 }
 
 func (c *registryLogoutClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	registryClient, err := registry.NewClient(
 		registry.ClientOptDebug(env.settings.Debug),
 		registry.ClientOptWriter(os.Stdout),
@@ -1,21 +1,14 @@
 package helmclient
 
 import (
-	"context"
 	"fmt"
-	"github.com/gofrs/flock"
 	"github.com/pkg/errors"
-	"golang.org/x/term"
-	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/getter"
 	"helm.sh/helm/v3/pkg/repo"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"sigs.k8s.io/yaml"
 	"strings"
-	"time"
 )
 
 const (
@@ -29,6 +22,7 @@ const (
 	repoAddDefaultCaFile                = ""
 	repoAddDefaultInsecureSkipTLSverify = false
 	repoAddDefaultDeprecateNoUpdate     = false
+	repoAddDefaultPersistCredentials    = false
 )
 
 // Repositories that have been permanently deleted and no longer work
@@ -64,9 +58,10 @@ type repoAddOptions struct {
 	caFile                string
 	insecureSkipTLSverify bool
 
-	repoFile  string
 	repoCache string
 
+	persistCredentials bool
+
 	// Deprecated, but cannot be removed until Helm 4
 	deprecatedNoUpdate bool
 }
@@ -89,6 +84,7 @@ func newRepoAddOptions(opts []RepoAddOption) *repoAddOptions {
 		caFile:                repoAddDefaultCaFile,
 		insecureSkipTLSverify: repoAddDefaultInsecureSkipTLSverify,
 		deprecatedNoUpdate:    repoAddDefaultDeprecateNoUpdate,
+		persistCredentials:    repoAddDefaultPersistCredentials,
 	}
 	options.apply(opts)
 	return options
@@ -142,13 +138,22 @@ func RepoAddWithDeprecatedNoUpdate(deprecatedNoUpdate bool) RepoAddOption {
 	}}
 }
 
+// RepoAddWithPersistCredentials writes the password of the repository to the
+// repositories file, as Helm does. By default only the username is written and
+// later commands get the password from a credential provider.
+func RepoAddWithPersistCredentials(persistCredentials bool) RepoAddOption {
+	return RepoAddOption{f: func(o *repoAddOptions) {
+		o.persistCredentials = persistCredentials
+	}}
+}
+
 func (c *repoAddClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
 	// use old namespace
 	return c.OverrideGlobalOptsWithNamespace(globalOpts, c.env.Namespace())
 }
 
 func (c *repoAddClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	c.env = env
 	return nil
 }
@@ -161,7 +166,7 @@ func newRepoAddClient(opts []RepoAddOption, env *helmEnv) (*repoAddClientImpl, e
 	}, nil
 }
 
-func (o *repoAddOptions) run(out io.Writer, settings *cli.EnvSettings) error {
+func (o *repoAddOptions) run(out io.Writer, env *helmEnv) error {
 	// Block deprecated repos
 	if !o.allowDeprecatedRepos {
 		for oldURL, newURL := range deprecatedRepos {
@@ -171,50 +176,19 @@ func (o *repoAddOptions) run(out io.Writer, settings *cli.EnvSettings) error {
 		}
 	}
 
-	// Ensure the file directory exists as it is required for file locking
-	err := os.MkdirAll(filepath.Dir(o.repoFile), os.ModePerm)
-	if err != nil && !os.IsExist(err) {
-		return err
-	}
-
-	// Acquire a file lock for process synchronization
-	repoFileExt := filepath.Ext(o.repoFile)
-	var lockPath string
-	if len(repoFileExt) > 0 && len(repoFileExt) < len(o.repoFile) {
-		lockPath = strings.Replace(o.repoFile, repoFileExt, ".lock", 1)
-	} else {
-		lockPath = o.repoFile + ".lock"
-	}
-	fileLock := flock.New(lockPath)
-	lockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	locked, err := fileLock.TryLockContext(lockCtx, time.Second)
-	if err == nil && locked {
-		defer fileLock.Unlock()
-	}
-	if err != nil {
-		return err
-	}
-
-	b, err := ioutil.ReadFile(o.repoFile)
-	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-
-	var f repo.File
-	if err := yaml.Unmarshal(b, &f); err != nil {
-		return err
-	}
-
-	if o.username != "" && o.password == "" {
-		fd := int(os.Stdin.Fd())
-		fmt.Fprint(out, "Password: ")
-		password, err := term.ReadPassword(fd)
-		fmt.Fprintln(out)
+	// never prompt for a password, the credential providers stand in for it
+	username, password := o.username, o.password
+	if password == "" {
+		creds, err := env.repoCredentials(o.name, o.url)
 		if err != nil {
 			return err
 		}
-		o.password = string(password)
+		if creds != nil && (username == "" || username == creds.Username) {
+			username, password = creds.Username, creds.Password
+		}
+	}
+	if username != "" && password == "" {
+		return errors.Errorf("no password for repository %q, set one with RepoAddWithPassword or a credential provider", o.name)
 	}
 
 	c := repo.Entry{
@@ -229,39 +203,56 @@ func (o *repoAddOptions) run(out io.Writer, settings *cli.EnvSettings) error {
 		InsecureSkipTLSverify: o.insecureSkipTLSverify,
 	}
 
-	// If the repo exists do one of two things:
-	// 1. If the configuration for the name is the same continue without error
-	// 2. When the config is different require --force-update
-	if !o.forceUpdate && f.Has(o.name) {
-		existing := f.Get(o.name)
-		if c != *existing {
+	if !o.persistCredentials {
+		c.Password = ""
+	}
+	skipped := false
+	err := env.repoStore().Update(func(f *repo.File) error {
+		// If the repo exists do one of two things:
+		// 1. If the configuration for the name is the same continue without error
+		// 2. When the config is different require --force-update
+		skipped = false
+		if !o.forceUpdate && f.Has(o.name) {
+			existing := f.Get(o.name)
+			if c != *existing {
+
+				// The input coming in for the name is different from what is already
+				// configured. Return an error.
+				return errors.Errorf("repository name (%s) already exists, please specify a different name", o.name)
+			}
 
-			// The input coming in for the name is different from what is already
-			// configured. Return an error.
-			return errors.Errorf("repository name (%s) already exists, please specify a different name", o.name)
+			// The add is idempotent so do nothing
+			skipped = true
+			return nil
 		}
 
-		// The add is idempotent so do nothing
-		fmt.Fprintf(out, "%q already exists with the same configuration, skipping\n", o.name)
-		return nil
-	}
+		// download with the credentials, whether they are written or not
+		withCredentials := c
+		withCredentials.Username = username
+		withCredentials.Password = password
+		r, err := repo.NewChartRepository(&withCredentials, getter.All(env.settings))
+		if err != nil {
+			return err
+		}
 
-	r, err := repo.NewChartRepository(&c, getter.All(settings))
+		if o.repoCache != "" {
+			r.CachePath = o.repoCache
+		}
+		if _, err := r.DownloadIndexFile(); err != nil {
+			return errors.Wrapf(err, "looks like %q is not a valid chart repository or cannot be reached", o.url)
+		}
+		// the next update downloads the new index unconditionally
+		os.Remove(filepath.Join(r.CachePath, cacheValidatorsFile(o.name)))
+
+		f.Update(&c)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-
-	if o.repoCache != "" {
-		r.CachePath = o.repoCache
-	}
-	if _, err := r.DownloadIndexFile(); err != nil {
-		return errors.Wrapf(err, "looks like %q is not a valid chart repository or cannot be reached", o.url)
-	}
-
-	f.Update(&c)
-
-	if err := f.WriteFile(o.repoFile, 0644); err != nil {
-		return err
+	if skipped {
+		fmt.Fprintf(out, "%q already exists with the same configuration, skipping\n", o.name)
+		return nil
 	}
 	fmt.Fprintf(out, "%q has been added to your repositories\n", o.name)
 	return nil
@@ -273,7 +264,6 @@ func (c *repoAddClientImpl) RepoAdd(args []string) error {
 	}
 	c.repoAddOpts.name = args[0]
 	c.repoAddOpts.url = args[1]
-	c.repoAddOpts.repoFile = c.env.settings.RepositoryConfig
 	c.repoAddOpts.repoCache = c.env.settings.RepositoryCache
-	return c.repoAddOpts.run(os.Stdout, c.env.settings)
+	return c.repoAddOpts.run(os.Stdout, c.env)
 }
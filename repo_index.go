@@ -1,19 +1,55 @@
 package helmclient
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/outgnaY/helm-go-client/internal/fileutil"
+	"github.com/outgnaY/helm-go-client/internal/urlutil"
 	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
 	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	repoIndexDefaultUrl   = ""
-	repoIndexDefaultMerge = ""
+	repoIndexDefaultUrl         = ""
+	repoIndexDefaultMerge       = ""
+	repoIndexDefaultConcurrency = 4
+	repoIndexDefaultDryRun      = false
+	repoIndexDefaultStateFile   = ""
 )
 
+// repoIndexStateDir is the directory in the repository cache which keeps the
+// state of indexed directories, unless a state file is given.
+const repoIndexStateDir = "index-state"
+
 type repoIndexClient interface {
-	RepoIndex(dir string) error
+	RepoIndex(dir string) (*RepoIndexReport, error)
+	RepoPrune(dir string) (*RepoPruneResult, error)
+}
+
+// RepoIndexReport lists how RepoIndex changed the index of a directory.
+type RepoIndexReport struct {
+	Added   []*repo.ChartVersion
+	Removed []*repo.ChartVersion
+	// Changed are the new entries of chart versions whose digest changed.
+	Changed []*repo.ChartVersion
+	// Hashed is the number of archives which were read, Reused the number of
+	// archives whose entry was taken over from the previous index.
+	Hashed int
+	Reused int
 }
 
 type repoIndexClientImpl struct {
@@ -26,9 +62,13 @@ type RepoIndexOption struct {
 }
 
 type repoIndexOptions struct {
-	dir   string
-	url   string
-	merge string
+	dir         string
+	url         string
+	merge       string
+	concurrency int
+	retention   []RetentionOption
+	dryRun      bool
+	stateFile   string
 }
 
 func (o *repoIndexOptions) apply(opts []RepoIndexOption) {
@@ -39,8 +79,11 @@ func (o *repoIndexOptions) apply(opts []RepoIndexOption) {
 
 func newRepoIndexOptions(opts []RepoIndexOption) *repoIndexOptions {
 	options := &repoIndexOptions{
-		url:   repoIndexDefaultUrl,
-		merge: repoIndexDefaultMerge,
+		url:         repoIndexDefaultUrl,
+		merge:       repoIndexDefaultMerge,
+		concurrency: repoIndexDefaultConcurrency,
+		dryRun:      repoIndexDefaultDryRun,
+		stateFile:   repoIndexDefaultStateFile,
 	}
 	options.apply(opts)
 	return options
@@ -58,13 +101,45 @@ func RepoIndexWithMerge(merge string) RepoIndexOption {
 	}}
 }
 
+// RepoIndexWithConcurrency limits how many archives are read at the same
+// time.
+func RepoIndexWithConcurrency(concurrency int) RepoIndexOption {
+	return RepoIndexOption{f: func(o *repoIndexOptions) {
+		o.concurrency = concurrency
+	}}
+}
+
+// RepoIndexWithStateFile sets the file the state of the indexed archives is
+// kept in. The state lists the archives with their size, modification time
+// and digest. By default it is kept in the repository cache, in a file named
+// after the indexed directory, so that it isn't served along with the charts.
+func RepoIndexWithStateFile(stateFile string) RepoIndexOption {
+	return RepoIndexOption{f: func(o *repoIndexOptions) {
+		o.stateFile = stateFile
+	}}
+}
+
+// RepoIndexWithRetention sets the retention policies RepoPrune applies.
+func RepoIndexWithRetention(opts []RetentionOption) RepoIndexOption {
+	return RepoIndexOption{f: func(o *repoIndexOptions) {
+		o.retention = opts
+	}}
+}
+
+// RepoIndexWithDryRun makes RepoPrune only report what it would remove.
+func RepoIndexWithDryRun(dryRun bool) RepoIndexOption {
+	return RepoIndexOption{f: func(o *repoIndexOptions) {
+		o.dryRun = dryRun
+	}}
+}
+
 func (c *repoIndexClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
 	// use old namespace
 	return c.OverrideGlobalOptsWithNamespace(globalOpts, c.env.Namespace())
 }
 
 func (c *repoIndexClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	c.env = env
 	return nil
 }
@@ -77,22 +152,128 @@ func newRepoIndexClient(opts []RepoIndexOption, env *helmEnv) (*repoIndexClientI
 	}, nil
 }
 
-func (o *repoIndexOptions) run() error {
+func (o *repoIndexOptions) run(env *helmEnv) (*RepoIndexReport, error) {
 	path, err := filepath.Abs(o.dir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return index(path, o.url, o.merge)
+	return index(path, o.url, o.merge, indexStateFile(o.stateFile, path, env), o.concurrency)
+}
+
+// indexStateFile returns stateFile, or if it is empty the default state file
+// of the absolute directory dir in the repository cache.
+func indexStateFile(stateFile string, dir string, env *helmEnv) string {
+	if stateFile != "" {
+		return stateFile
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(env.settings.RepositoryCache, repoIndexStateDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// repoIndexState is the state of an archive when it was last indexed. Name
+// and Version are empty for an archive which is not a chart.
+type repoIndexState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Name    string    `json:"name,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Digest  string    `json:"digest,omitempty"`
+}
+
+// indexedArchive is an archive of the directory and its entry.
+type indexedArchive struct {
+	path    string
+	file    string
+	url     string
+	info    os.FileInfo
+	version *repo.ChartVersion
+	err     error
+}
+
+// dirIndex is the index of a directory with the state of its archives.
+type dirIndex struct {
+	dir string
+	// stateFile is the path of the state of the archives
+	stateFile string
+	previous  *repo.IndexFile
+	index     *repo.IndexFile
+	state     map[string]repoIndexState
+	report    *RepoIndexReport
+}
+
+func index(dir, url, mergeTo, stateFile string, concurrency int) (*RepoIndexReport, error) {
+	d, err := indexDirectory(dir, url, mergeTo, stateFile, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	return d.report, d.write()
 }
 
-func index(dir, url, mergeTo string) error {
+// indexDirectory generates the index of dir, reusing the entries of the
+// previous index for the archives which didn't change according to the state
+// kept in stateFile.
+func indexDirectory(dir, url, mergeTo, stateFile string, concurrency int) (*dirIndex, error) {
 	out := filepath.Join(dir, "index.yaml")
 
-	i, err := repo.IndexDirectory(dir, url)
+	previous, err := repo.LoadIndexFile(out)
 	if err != nil {
-		return err
+		if !os.IsNotExist(errors.Cause(err)) {
+			warning("ignoring the previous index: %s", err)
+		}
+		previous = repo.NewIndexFile()
+	}
+	state := loadIndexState(stateFile)
+
+	archives, err := findArchives(dir, url)
+	if err != nil {
+		return nil, err
+	}
+	report := &RepoIndexReport{}
+	var stale []*indexedArchive
+	for _, archive := range archives {
+		s, ok := state[archive.file]
+		if !ok || s.Size != archive.info.Size() || !s.ModTime.Equal(archive.info.ModTime()) {
+			stale = append(stale, archive)
+			continue
+		}
+		if s.Name == "" {
+			// not a chart, as before
+			report.Reused++
+			continue
+		}
+		cv, err := previous.Get(s.Name, s.Version)
+		if err != nil || cv.Digest != s.Digest {
+			stale = append(stale, archive)
+			continue
+		}
+		reused := *cv
+		reused.URLs = []string{archive.url}
+		archive.version = &reused
+		report.Reused++
 	}
+	hashArchives(stale, concurrency)
+	report.Hashed = len(stale)
+
+	i := repo.NewIndexFile()
+	newState := map[string]repoIndexState{}
+	for _, archive := range archives {
+		if archive.err != nil {
+			return nil, archive.err
+		}
+		s := repoIndexState{Size: archive.info.Size(), ModTime: archive.info.ModTime()}
+		if cv := archive.version; cv != nil {
+			// an archive indexed anew keeps its creation time if it is the
+			// same, such as when the state is missing
+			if old, err := previous.Get(cv.Name, cv.Version); err == nil && old.Digest == cv.Digest {
+				cv.Created = old.Created
+			}
+			i.Entries[cv.Name] = append(i.Entries[cv.Name], cv)
+			s.Name, s.Version, s.Digest = cv.Name, cv.Version, cv.Digest
+		}
+		newState[archive.file] = s
+	}
+
 	if mergeTo != "" {
 		// if index.yaml is missing then create an empty one to merge into
 		var i2 *repo.IndexFile
@@ -102,16 +283,178 @@ func index(dir, url, mergeTo string) error {
 		} else {
 			i2, err = repo.LoadIndexFile(mergeTo)
 			if err != nil {
-				return errors.Wrap(err, "merge failed")
+				return nil, errors.Wrap(err, "merge failed")
 			}
 		}
 		i.Merge(i2)
 	}
 	i.SortEntries()
-	return i.WriteFile(out, 0644)
+	compareIndexes(previous, i, report)
+	return &dirIndex{dir: dir, stateFile: stateFile, previous: previous, index: i, state: newState, report: report}, nil
+}
+
+// write writes the index and the state of the archives atomically.
+func (d *dirIndex) write() error {
+	data, err := yaml.Marshal(d.index)
+	if err != nil {
+		return err
+	}
+	if err := fileutil.AtomicWriteFile(filepath.Join(d.dir, "index.yaml"), bytes.NewReader(data), 0644); err != nil {
+		return err
+	}
+	data, err = json.Marshal(d.state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.stateFile), 0755); err != nil {
+		return err
+	}
+	return fileutil.AtomicWriteFile(d.stateFile, bytes.NewReader(data), 0644)
+}
+
+// findArchives finds the archives in dir and its subdirectories, like
+// repo.IndexDirectory.
+func findArchives(dir string, baseURL string) ([]*indexedArchive, error) {
+	archives, err := filepath.Glob(filepath.Join(dir, "*.tgz"))
+	if err != nil {
+		return nil, err
+	}
+	moreArchives, err := filepath.Glob(filepath.Join(dir, "**/*.tgz"))
+	if err != nil {
+		return nil, err
+	}
+	archives = append(archives, moreArchives...)
+
+	var found []*indexedArchive
+	for _, arch := range archives {
+		info, err := os.Stat(arch)
+		if err != nil {
+			return nil, err
+		}
+		file, err := filepath.Rel(dir, arch)
+		if err != nil {
+			return nil, err
+		}
+		parentDir, fname := filepath.Split(file)
+		// filepath.Split appends an extra slash to the end of parentDir. We want to strip that out.
+		parentDir = strings.TrimSuffix(parentDir, string(os.PathSeparator))
+		parentURL, err := urlutil.URLJoin(baseURL, parentDir)
+		if err != nil {
+			parentURL = path.Join(baseURL, parentDir)
+		}
+		u := fname
+		if parentURL != "" {
+			if u, err = urlutil.URLJoin(parentURL, fname); err != nil {
+				u = path.Join(parentURL, fname)
+			}
+		}
+		found = append(found, &indexedArchive{path: arch, file: filepath.ToSlash(file), url: u, info: info})
+	}
+	return found, nil
+}
+
+// hashArchives reads the metadata and the digest of archives with a pool of
+// concurrency workers.
+func hashArchives(archives []*indexedArchive, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	queue := make(chan *indexedArchive)
+	var wg sync.WaitGroup
+	for n := 0; n < concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for archive := range queue {
+				hashArchive(archive)
+			}
+		}()
+	}
+	for _, archive := range archives {
+		queue <- archive
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// hashArchive reads the metadata and the digest of archive. An archive which
+// can't be loaded is assumed not to be a chart.
+func hashArchive(archive *indexedArchive) {
+	c, err := loader.Load(archive.path)
+	if err != nil {
+		return
+	}
+	hash, err := provenance.DigestFile(archive.path)
+	if err != nil {
+		archive.err = err
+		return
+	}
+	md := c.Metadata
+	if md.APIVersion == "" {
+		md.APIVersion = chart.APIVersionV1
+	}
+	if err := md.Validate(); err != nil {
+		archive.err = errors.Wrapf(err, "failed adding to %s to index", archive.file)
+		return
+	}
+	archive.version = &repo.ChartVersion{
+		URLs:     []string{archive.url},
+		Metadata: md,
+		Digest:   hash,
+		Created:  time.Now(),
+	}
+}
+
+func loadIndexState(path string) map[string]repoIndexState {
+	state := map[string]repoIndexState{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		warning("ignoring the index state %s: %s", path, err)
+		return map[string]repoIndexState{}
+	}
+	return state
+}
+
+// compareIndexes fills in the added, removed and changed chart versions of
+// report.
+func compareIndexes(previous *repo.IndexFile, current *repo.IndexFile, report *RepoIndexReport) {
+	for name, versions := range current.Entries {
+		for _, cv := range versions {
+			old, err := previous.Get(name, cv.Version)
+			switch {
+			case err != nil:
+				report.Added = append(report.Added, cv)
+			case old.Digest != cv.Digest:
+				report.Changed = append(report.Changed, cv)
+			}
+		}
+	}
+	for name, versions := range previous.Entries {
+		for _, cv := range versions {
+			if !current.Has(name, cv.Version) {
+				report.Removed = append(report.Removed, cv)
+			}
+		}
+	}
+	for _, list := range [][]*repo.ChartVersion{report.Added, report.Removed, report.Changed} {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Name != list[j].Name {
+				return list[i].Name < list[j].Name
+			}
+			return list[i].Version < list[j].Version
+		})
+	}
 }
 
-func (c *repoIndexClientImpl) RepoIndex(dir string) error {
+// RepoIndex writes the index of the charts in dir to dir/index.yaml and
+// reports how it changed. Archives whose size and modification time are
+// unchanged since the last run keep their entry, the others are read
+// concurrently, up to the limit given by RepoIndexWithConcurrency. The state of
+// the last run is kept outside of dir, see RepoIndexWithStateFile.
+func (c *repoIndexClientImpl) RepoIndex(dir string) (*RepoIndexReport, error) {
 	c.repoIndexOpts.dir = dir
-	return c.repoIndexOpts.run()
+	return c.repoIndexOpts.run(c.env)
 }
@@ -19,7 +19,7 @@ func (c *repoListClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error
 }
 
 func (c *repoListClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	c.env = env
 	return nil
 }
@@ -31,9 +31,12 @@ func newRepoListClient(env *helmEnv) (*repoListClientImpl, error) {
 }
 
 func (c *repoListClientImpl) RepoList() ([]*repo.Entry, error) {
-	f, err := repo.LoadFile(c.env.settings.RepositoryConfig)
+	f, err := c.env.repoStore().Load()
 	if isNotExist(err) {
 		return nil, errors.New("no repositories to show")
 	}
+	if err != nil {
+		return nil, err
+	}
 	return f.Repositories, nil
 }
@@ -0,0 +1,500 @@
+package helmclient
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"github.com/outgnaY/helm-go-client/internal/version"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	repoMirrorDefaultUrl         = ""
+	repoMirrorDefaultConcurrency = 4
+	repoMirrorDefaultTimeout     = 120 * time.Second
+	repoMirrorDefaultStateFile   = ""
+)
+
+// RepoMirrorStatus is the outcome of mirroring a chart version.
+type RepoMirrorStatus string
+
+const (
+	// RepoMirrorFetched is a chart version which was downloaded.
+	RepoMirrorFetched RepoMirrorStatus = "fetched"
+	// RepoMirrorUnchanged is a chart version whose archive was already
+	// mirrored, so that it was not downloaded again.
+	RepoMirrorUnchanged RepoMirrorStatus = "unchanged"
+	// RepoMirrorFailed is a chart version which could not be mirrored.
+	RepoMirrorFailed RepoMirrorStatus = "failed"
+)
+
+// RepoMirrorResult is the outcome of mirroring one chart version.
+type RepoMirrorResult struct {
+	// Repo is the name of the repository the chart version is mirrored from.
+	Repo    string
+	Version *repo.ChartVersion
+	// File is the name of the archive in the mirror.
+	File   string
+	Status RepoMirrorStatus
+	// Err is set when Status is RepoMirrorFailed.
+	Err error
+	// Provenance is whether the mirror has the provenance file of the chart
+	// version.
+	Provenance bool
+}
+
+// RepoMirrorReport lists what RepoMirror mirrored.
+type RepoMirrorReport struct {
+	Results []*RepoMirrorResult
+	// Index is the regenerated index of the mirror.
+	Index *repo.IndexFile
+}
+
+type repoMirrorClient interface {
+	RepoMirror(dir string) (*RepoMirrorReport, error)
+	RepoMirrorToStorage(storage ChartStorage) (*RepoMirrorReport, error)
+}
+
+type repoMirrorClientImpl struct {
+	repoMirrorOpts *repoMirrorOptions
+	env            *helmEnv
+}
+
+type RepoMirrorOption struct {
+	f func(o *repoMirrorOptions)
+}
+
+// repoMirrorSelector selects the versions of the charts whose repo/chart
+// name matches pattern.
+type repoMirrorSelector struct {
+	pattern    string
+	constraint string
+}
+
+type repoMirrorOptions struct {
+	selectors   []repoMirrorSelector
+	url         string
+	concurrency int
+	timeout     time.Duration
+	stateFile   string
+}
+
+func (o *repoMirrorOptions) apply(opts []RepoMirrorOption) {
+	for _, op := range opts {
+		op.f(o)
+	}
+}
+
+func newRepoMirrorOptions(opts []RepoMirrorOption) *repoMirrorOptions {
+	options := &repoMirrorOptions{
+		url:         repoMirrorDefaultUrl,
+		concurrency: repoMirrorDefaultConcurrency,
+		timeout:     repoMirrorDefaultTimeout,
+		stateFile:   repoMirrorDefaultStateFile,
+	}
+	options.apply(opts)
+	return options
+}
+
+// RepoMirrorWithChart selects the charts to mirror. pattern is matched with
+// path.Match against repo/chart names of the configured repositories, such
+// as "stable/*" or "*/nginx". constraint is a semantic version constraint
+// the mirrored versions satisfy, all versions if it is empty. The option may
+// be given several times, chart versions selected by any of them are
+// mirrored.
+func RepoMirrorWithChart(pattern string, constraint string) RepoMirrorOption {
+	return RepoMirrorOption{f: func(o *repoMirrorOptions) {
+		o.selectors = append(o.selectors, repoMirrorSelector{pattern: pattern, constraint: constraint})
+	}}
+}
+
+// RepoMirrorWithUrl sets the URL the mirror is served at, which the URLs in
+// its index are relative to.
+func RepoMirrorWithUrl(url string) RepoMirrorOption {
+	return RepoMirrorOption{f: func(o *repoMirrorOptions) {
+		o.url = url
+	}}
+}
+
+// RepoMirrorWithConcurrency limits how many chart versions are downloaded at
+// the same time.
+func RepoMirrorWithConcurrency(concurrency int) RepoMirrorOption {
+	return RepoMirrorOption{f: func(o *repoMirrorOptions) {
+		o.concurrency = concurrency
+	}}
+}
+
+// RepoMirrorWithTimeout limits the time each download may take, zero for no
+// limit.
+func RepoMirrorWithTimeout(timeout time.Duration) RepoMirrorOption {
+	return RepoMirrorOption{f: func(o *repoMirrorOptions) {
+		o.timeout = timeout
+	}}
+}
+
+// RepoMirrorWithStateFile sets the file the state of the archives in the
+// mirror is kept in, like RepoIndexWithStateFile. By default it is kept in the
+// repository cache, outside of the served directory.
+func RepoMirrorWithStateFile(stateFile string) RepoMirrorOption {
+	return RepoMirrorOption{f: func(o *repoMirrorOptions) {
+		o.stateFile = stateFile
+	}}
+}
+
+func (c *repoMirrorClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
+	// use old namespace
+	return c.OverrideGlobalOptsWithNamespace(globalOpts, c.env.Namespace())
+}
+
+func (c *repoMirrorClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
+	env := rebuildEnv(globalOpts, namespace, c.env)
+	c.env = env
+	return nil
+}
+
+func newRepoMirrorClient(opts []RepoMirrorOption, env *helmEnv) (*repoMirrorClientImpl, error) {
+	o := newRepoMirrorOptions(opts)
+	return &repoMirrorClientImpl{
+		repoMirrorOpts: o,
+		env:            env,
+	}, nil
+}
+
+// mirroredChart is a chart version to mirror and the repository it is
+// downloaded from.
+type mirroredChart struct {
+	entry  *repo.Entry
+	result *RepoMirrorResult
+}
+
+func (o *repoMirrorOptions) run(storage ChartStorage, env *helmEnv) ([]*RepoMirrorResult, error) {
+	charts, err := o.selectCharts(env)
+	if err != nil {
+		return nil, err
+	}
+	providers := getter.All(env.settings)
+	concurrency := o.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	results := make([]*RepoMirrorResult, len(charts))
+	var wg sync.WaitGroup
+	for i, c := range charts {
+		results[i] = c.result
+		if c.result.Status == RepoMirrorFailed {
+			continue
+		}
+		wg.Add(1)
+		go func(c *mirroredChart) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := o.mirror(c, storage, providers); err != nil {
+				c.result.Status = RepoMirrorFailed
+				c.result.Err = err
+				debug("Unable to mirror %s from the %q chart repository: %s", c.result.File, c.entry.Name, err)
+				return
+			}
+			debug("Mirrored %s from the %q chart repository: %s", c.result.File, c.entry.Name, c.result.Status)
+		}(c)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// selectCharts finds the chart versions the selectors select in the indexes
+// of the configured repositories, in the order of the repositories.
+func (o *repoMirrorOptions) selectCharts(env *helmEnv) ([]*mirroredChart, error) {
+	if len(o.selectors) == 0 {
+		return nil, errors.New("no charts selected to mirror")
+	}
+	constraints := make([]*semver.Constraints, len(o.selectors))
+	for i, selector := range o.selectors {
+		if _, err := path.Match(selector.pattern, ""); err != nil || !strings.Contains(selector.pattern, "/") {
+			return nil, errors.Errorf("invalid chart pattern %q, expected repo/chart", selector.pattern)
+		}
+		if selector.constraint == "" {
+			continue
+		}
+		c, err := semver.NewConstraint(selector.constraint)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid version constraint %q", selector.constraint)
+		}
+		constraints[i] = c
+	}
+	f, err := env.repoStore().Load()
+	switch {
+	case isNotExist(err) || (err == nil && len(f.Repositories) == 0):
+		return nil, errors.New("no repositories configured")
+	case err != nil:
+		return nil, errors.Wrap(err, "failed loading repositories")
+	}
+
+	var charts []*mirroredChart
+	// the repository each archive is mirrored from
+	files := map[string]string{}
+	for _, entry := range f.Repositories {
+		selected := false
+		for _, selector := range o.selectors {
+			repoPattern := selector.pattern[:strings.Index(selector.pattern, "/")]
+			if ok, _ := path.Match(repoPattern, entry.Name); ok {
+				selected = true
+			}
+		}
+		if !selected {
+			continue
+		}
+		entry, err := withRepoCredentials(entry, env)
+		if err != nil {
+			return nil, err
+		}
+		index, err := cachedRepoIndex(entry, env)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load the index of repository %q", entry.Name)
+		}
+		names := make([]string, 0, len(index.Entries))
+		for name := range index.Entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, cv := range index.Entries[name] {
+				if !o.selects(entry.Name, cv, constraints) {
+					continue
+				}
+				result := &RepoMirrorResult{
+					Repo:    entry.Name,
+					Version: cv,
+					File:    fmt.Sprintf("%s-%s.tgz", cv.Name, cv.Version),
+				}
+				if other, ok := files[result.File]; ok {
+					result.Status = RepoMirrorFailed
+					result.Err = errors.Errorf("%s is mirrored from repository %q already", result.File, other)
+				} else {
+					files[result.File] = entry.Name
+				}
+				charts = append(charts, &mirroredChart{entry: entry, result: result})
+			}
+		}
+	}
+	return charts, nil
+}
+
+// selects is whether any selector selects the chart version cv of the
+// repository repoName.
+func (o *repoMirrorOptions) selects(repoName string, cv *repo.ChartVersion, constraints []*semver.Constraints) bool {
+	for i, selector := range o.selectors {
+		if ok, _ := path.Match(selector.pattern, repoName+"/"+cv.Name); !ok {
+			continue
+		}
+		if constraints[i] == nil {
+			return true
+		}
+		if v, err := semver.NewVersion(cv.Version); err == nil && constraints[i].Check(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// cachedRepoIndex loads the cached index of entry, downloading it first if
+// the repository was never updated.
+func cachedRepoIndex(entry *repo.Entry, env *helmEnv) (*repo.IndexFile, error) {
+	fname := filepath.Join(env.settings.RepositoryCache, helmpath.CacheIndexFile(entry.Name))
+	if _, err := os.Stat(fname); os.IsNotExist(err) {
+		r, err := repo.NewChartRepository(entry, getter.All(env.settings))
+		if err != nil {
+			return nil, err
+		}
+		r.CachePath = env.settings.RepositoryCache
+		if fname, err = r.DownloadIndexFile(); err != nil {
+			return nil, err
+		}
+	}
+	return repo.LoadIndexFile(fname)
+}
+
+// mirror downloads the archive of a chart version and its provenance file to
+// storage, unless storage has them already.
+func (o *repoMirrorOptions) mirror(c *mirroredChart, storage ChartStorage, providers getter.Providers) error {
+	result := c.result
+	cv := result.Version
+	if len(cv.URLs) == 0 {
+		return errors.Errorf("chart %q version %q has no downloadable URLs", cv.Name, cv.Version)
+	}
+	chartURL, err := repo.ResolveReferenceURL(c.entry.URL, cv.URLs[0])
+	if err != nil {
+		return errors.Wrap(err, "failed to make chart URL absolute")
+	}
+	matches, err := storedArchiveMatches(storage, result.File, cv.Digest)
+	if err != nil {
+		return err
+	}
+	result.Status = RepoMirrorUnchanged
+	if !matches {
+		data, err := o.download(chartURL, c.entry, providers)
+		if err != nil {
+			return err
+		}
+		if cv.Digest != "" {
+			digest, err := provenance.Digest(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			if digest != cv.Digest {
+				return errors.Errorf("digest of %s is %s, the index has %s", chartURL, digest, cv.Digest)
+			}
+		}
+		if err := storage.Put(result.File, data); err != nil {
+			return err
+		}
+		result.Status = RepoMirrorFetched
+	}
+
+	prov := result.File + ".prov"
+	if result.Status == RepoMirrorUnchanged {
+		if r, err := storage.Get(prov); err == nil {
+			r.Close()
+			result.Provenance = true
+			return nil
+		}
+	}
+	data, err := o.download(chartURL+".prov", c.entry, providers)
+	if err != nil {
+		// charts need not be signed
+		debug("No provenance file for %s: %s", chartURL, err)
+		if err := storage.Delete(prov); err != nil && !os.IsNotExist(errors.Cause(err)) {
+			return err
+		}
+		return nil
+	}
+	if err := storage.Put(prov, data); err != nil {
+		return err
+	}
+	result.Provenance = true
+	return nil
+}
+
+// download gets ref with the getter of its scheme and the credentials and TLS
+// configuration of entry.
+func (o *repoMirrorOptions) download(ref string, entry *repo.Entry, providers getter.Providers) ([]byte, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid chart URL format: %s", ref)
+	}
+	g, err := providers.ByScheme(u.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	opts := []getter.Option{
+		getter.WithURL(entry.URL),
+		getter.WithBasicAuth(entry.Username, entry.Password),
+		getter.WithPassCredentialsAll(entry.PassCredentialsAll),
+		getter.WithTLSClientConfig(entry.CertFile, entry.KeyFile, entry.CAFile),
+		getter.WithInsecureSkipVerifyTLS(entry.InsecureSkipTLSverify),
+		getter.WithUserAgent(version.GetUserAgent()),
+	}
+	if o.timeout > 0 {
+		opts = append(opts, getter.WithTimeout(o.timeout))
+	}
+	data, err := g.Get(ref, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// storedArchiveMatches is whether storage has the archive name with digest,
+// with any digest if it is empty.
+func storedArchiveMatches(storage ChartStorage, name string, digest string) (bool, error) {
+	r, err := storage.Get(name)
+	if os.IsNotExist(errors.Cause(err)) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	if digest == "" {
+		return true, nil
+	}
+	stored, err := provenance.Digest(r)
+	if err != nil {
+		return false, err
+	}
+	return stored == digest, nil
+}
+
+func repoMirrorSummary(results []*RepoMirrorResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.Status == RepoMirrorFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d chart(s) mirrored, %d failed", len(results)-failed, failed)
+	}
+	return nil
+}
+
+// RepoMirror mirrors the chart versions selected by RepoMirrorWithChart from
+// the configured repositories into dir, together with their provenance files
+// if the repositories have them. The index of dir is regenerated like
+// RepoIndex does, with URLs relative to the URL given by RepoMirrorWithUrl.
+// Archives dir has already are not downloaded again, unless their digest
+// differs from the one in the index of their repository. Only the charts and
+// the index are written to dir, see RepoMirrorWithStateFile. If any chart
+// version fails, the report is returned together with a summary error.
+func (c *repoMirrorClientImpl) RepoMirror(dir string) (*RepoMirrorReport, error) {
+	o := c.repoMirrorOpts
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	results, err := o.run(NewDirChartStorage(dir), c.env)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d, err := indexDirectory(dir, o.url, "", indexStateFile(o.stateFile, dir, c.env), o.concurrency)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.write(); err != nil {
+		return nil, err
+	}
+	return &RepoMirrorReport{Results: results, Index: d.index}, repoMirrorSummary(results)
+}
+
+// RepoMirrorToStorage mirrors the selected chart versions like RepoMirror
+// into storage, such as the storage of a RepoServer. The returned index is
+// the one a RepoServer serving storage at the URL given by RepoMirrorWithUrl
+// would serve.
+func (c *repoMirrorClientImpl) RepoMirrorToStorage(storage ChartStorage) (*RepoMirrorReport, error) {
+	o := c.repoMirrorOpts
+	results, err := o.run(storage, c.env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := NewRepoServer(storage, []RepoServerOption{RepoServerWithURL(o.url)}).Index()
+	if err != nil {
+		return nil, err
+	}
+	return &RepoMirrorReport{Results: results, Index: index}, repoMirrorSummary(results)
+}
@@ -0,0 +1,217 @@
+package helmclient
+
+import (
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	retentionDefaultKeepLast         = 0
+	retentionDefaultKeepNewerThan    = 0
+	retentionDefaultPrereleaseMaxAge = 0
+)
+
+type RetentionOption struct {
+	f func(o *retentionOptions)
+}
+
+type retentionOptions struct {
+	keepLast         int
+	keepNewerThan    time.Duration
+	prereleaseMaxAge time.Duration
+	deployed         []*release.Release
+	now              time.Time
+}
+
+func (o *retentionOptions) apply(opts []RetentionOption) {
+	for _, op := range opts {
+		op.f(o)
+	}
+}
+
+func newRetentionOptions(opts []RetentionOption) *retentionOptions {
+	options := &retentionOptions{
+		keepLast:         retentionDefaultKeepLast,
+		keepNewerThan:    retentionDefaultKeepNewerThan,
+		prereleaseMaxAge: retentionDefaultPrereleaseMaxAge,
+	}
+	options.apply(opts)
+	if options.now.IsZero() {
+		options.now = time.Now()
+	}
+	return options
+}
+
+// RetentionWithKeepLast keeps the n highest versions of every chart.
+func RetentionWithKeepLast(n int) RetentionOption {
+	return RetentionOption{f: func(o *retentionOptions) {
+		o.keepLast = n
+	}}
+}
+
+// RetentionWithKeepNewerThan keeps the versions created less than age ago.
+func RetentionWithKeepNewerThan(age time.Duration) RetentionOption {
+	return RetentionOption{f: func(o *retentionOptions) {
+		o.keepNewerThan = age
+	}}
+}
+
+// RetentionWithPrereleaseMaxAge drops the pre-release versions created more
+// than age ago, even if another policy would keep them.
+func RetentionWithPrereleaseMaxAge(age time.Duration) RetentionOption {
+	return RetentionOption{f: func(o *retentionOptions) {
+		o.prereleaseMaxAge = age
+	}}
+}
+
+// RetentionWithDeployedReleases always keeps the chart versions of releases,
+// such as the ones List returns for every cluster the repository serves.
+func RetentionWithDeployedReleases(releases []*release.Release) RetentionOption {
+	return RetentionOption{f: func(o *retentionOptions) {
+		o.deployed = append(o.deployed, releases...)
+	}}
+}
+
+// RetentionWithTime sets the time the ages are measured from, the current
+// time by default.
+func RetentionWithTime(now time.Time) RetentionOption {
+	return RetentionOption{f: func(o *retentionOptions) {
+		o.now = now
+	}}
+}
+
+// PruneIndex applies retention policies to the chart versions of index. A
+// version is kept if it is deployed, or, unless it is a pre-release older
+// than the pre-release maximum age, if it is one of the last versions or
+// newer than the age to keep. Without a policy for the last versions or the
+// age, every version is kept apart from the old pre-releases. Ages are
+// measured from the creation time of the entries. index is not changed, the
+// retained versions are returned in a new index together with the removed
+// ones.
+func PruneIndex(index *repo.IndexFile, opts []RetentionOption) (*repo.IndexFile, []*repo.ChartVersion) {
+	o := newRetentionOptions(opts)
+	deployed := map[[2]string]bool{}
+	for _, rel := range o.deployed {
+		if rel != nil && rel.Chart != nil && rel.Chart.Metadata != nil {
+			deployed[[2]string{rel.Chart.Metadata.Name, rel.Chart.Metadata.Version}] = true
+		}
+	}
+
+	pruned := repo.NewIndexFile()
+	pruned.APIVersion = index.APIVersion
+	pruned.Generated = index.Generated
+	pruned.PublicKeys = index.PublicKeys
+	var removed []*repo.ChartVersion
+	names := make([]string, 0, len(index.Entries))
+	for name := range index.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		versions := append(repo.ChartVersions(nil), index.Entries[name]...)
+		// highest version first, like SortEntries
+		sort.Sort(sort.Reverse(versions))
+		var kept repo.ChartVersions
+		for n, cv := range versions {
+			if o.retain(cv, n, deployed) {
+				kept = append(kept, cv)
+			} else {
+				removed = append(removed, cv)
+			}
+		}
+		if len(kept) > 0 {
+			pruned.Entries[name] = kept
+		}
+	}
+	return pruned, removed
+}
+
+// retain applies the policies to cv, the nth highest version of its chart.
+func (o *retentionOptions) retain(cv *repo.ChartVersion, n int, deployed map[[2]string]bool) bool {
+	if deployed[[2]string{cv.Name, cv.Version}] {
+		return true
+	}
+	age := o.now.Sub(cv.Created)
+	if o.prereleaseMaxAge > 0 && age > o.prereleaseMaxAge {
+		if v, err := semver.NewVersion(cv.Version); err == nil && v.Prerelease() != "" {
+			return false
+		}
+	}
+	if o.keepLast <= 0 && o.keepNewerThan <= 0 {
+		return true
+	}
+	return (o.keepLast > 0 && n < o.keepLast) || (o.keepNewerThan > 0 && age < o.keepNewerThan)
+}
+
+// RepoPruneResult is the outcome of RepoPrune.
+type RepoPruneResult struct {
+	// Index is the index of the retained chart versions.
+	Index   *repo.IndexFile
+	Removed []*repo.ChartVersion
+	// Archives are the paths of the archives and provenance files of the
+	// removed versions.
+	Archives []string
+}
+
+func (o *repoIndexOptions) prune(env *helmEnv) (*RepoPruneResult, error) {
+	dir, err := filepath.Abs(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	d, err := indexDirectory(dir, o.url, o.merge, indexStateFile(o.stateFile, dir, env), o.concurrency)
+	if err != nil {
+		return nil, err
+	}
+	index, removed := PruneIndex(d.index, o.retention)
+
+	// the archives of the removed versions, known from their state
+	drop := map[[2]string]bool{}
+	for _, cv := range removed {
+		drop[[2]string{cv.Name, cv.Version}] = true
+	}
+	var archives []string
+	for file, s := range d.state {
+		if s.Name == "" || !drop[[2]string{s.Name, s.Version}] {
+			continue
+		}
+		archive := filepath.Join(dir, filepath.FromSlash(file))
+		archives = append(archives, archive)
+		if _, err := os.Stat(archive + ".prov"); err == nil {
+			archives = append(archives, archive+".prov")
+		}
+		if !o.dryRun {
+			delete(d.state, file)
+		}
+	}
+	sort.Strings(archives)
+	result := &RepoPruneResult{Index: index, Removed: removed, Archives: archives}
+	if o.dryRun {
+		return result, nil
+	}
+
+	// write the index first, so that it never refers to a deleted archive
+	d.index = index
+	if err := d.write(); err != nil {
+		return nil, err
+	}
+	for _, archive := range archives {
+		if err := os.Remove(archive); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// RepoPrune indexes dir like RepoIndex and applies the retention policies
+// given by RepoIndexWithRetention to the index. The index of the retained
+// versions is written to dir/index.yaml and the archives of the removed
+// versions are deleted, unless RepoIndexWithDryRun is set.
+func (c *repoIndexClientImpl) RepoPrune(dir string) (*RepoPruneResult, error) {
+	c.repoIndexOpts.dir = dir
+	return c.repoIndexOpts.prune(c.env)
+}
@@ -21,7 +21,6 @@ type repoRemoveClientImpl struct {
 
 type repoRemoveOptions struct {
 	names     []string
-	repoFile  string
 	repoCache string
 }
 
@@ -36,7 +35,7 @@ func (c *repoRemoveClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) err
 }
 
 func (c *repoRemoveClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	c.env = env
 	return nil
 }
@@ -49,17 +48,23 @@ func newRepoRemoveClient(env *helmEnv) (*repoRemoveClientImpl, error) {
 	}, nil
 }
 
-func (o *repoRemoveOptions) run(out io.Writer) error {
-	r, err := repo.LoadFile(o.repoFile)
+func (o *repoRemoveOptions) run(out io.Writer, store RepoStore) error {
+	r, err := store.Load()
 	if isNotExist(err) || len(r.Repositories) == 0 {
 		return errors.New("no repositories configured")
 	}
+	if err != nil {
+		return err
+	}
 
 	for _, name := range o.names {
-		if !r.Remove(name) {
-			return errors.Errorf("no repo named %q found", name)
-		}
-		if err := r.WriteFile(o.repoFile, 0644); err != nil {
+		err := store.Update(func(f *repo.File) error {
+			if !f.Remove(name) {
+				return errors.Errorf("no repo named %q found", name)
+			}
+			return nil
+		})
+		if err != nil {
 			return err
 		}
 
@@ -78,6 +83,11 @@ func removeRepoCache(root, name string) error {
 		os.Remove(idx)
 	}
 
+	validators := filepath.Join(root, cacheValidatorsFile(name))
+	if _, err := os.Stat(validators); err == nil {
+		os.Remove(validators)
+	}
+
 	idx = filepath.Join(root, helmpath.CacheIndexFile(name))
 	if _, err := os.Stat(idx); os.IsNotExist(err) {
 		return nil
@@ -88,8 +98,7 @@ func removeRepoCache(root, name string) error {
 }
 
 func (c *repoRemoveClientImpl) RepoRemove(args []string) error {
-	c.repoRemoveOpts.repoFile = c.env.settings.RepositoryConfig
 	c.repoRemoveOpts.repoCache = c.env.settings.RepositoryCache
 	c.repoRemoveOpts.names = args
-	return c.repoRemoveOpts.run(os.Stdout)
+	return c.repoRemoveOpts.run(os.Stdout, c.env.repoStore())
 }
@@ -0,0 +1,623 @@
+package helmclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/outgnaY/helm-go-client/internal/urlutil"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	repoServerDefaultURL            = ""
+	repoServerDefaultProvenance     = false
+	repoServerDefaultAllowOverwrite = false
+	repoServerDefaultMaxUploadSize  = 20 << 20
+)
+
+// BasicAuthFunc checks the basic auth credentials of a request to a
+// RepoServer. username and password are empty for a request without
+// credentials.
+type BasicAuthFunc func(username string, password string) bool
+
+// StaticBasicAuth accepts a single username and password.
+func StaticBasicAuth(username string, password string) BasicAuthFunc {
+	return func(u string, p string) bool {
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(username)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		return userOK && passwordOK
+	}
+}
+
+type RepoServerOption struct {
+	f func(o *repoServerOptions)
+}
+
+type repoServerOptions struct {
+	url            string
+	provenance     bool
+	allowOverwrite bool
+	maxUploadSize  int64
+	readAuth       BasicAuthFunc
+	writeAuth      BasicAuthFunc
+}
+
+func (o *repoServerOptions) apply(opts []RepoServerOption) {
+	for _, op := range opts {
+		op.f(o)
+	}
+}
+
+func newRepoServerOptions(opts []RepoServerOption) *repoServerOptions {
+	options := &repoServerOptions{
+		url:            repoServerDefaultURL,
+		provenance:     repoServerDefaultProvenance,
+		allowOverwrite: repoServerDefaultAllowOverwrite,
+		maxUploadSize:  repoServerDefaultMaxUploadSize,
+	}
+	options.apply(opts)
+	return options
+}
+
+// RepoServerWithURL sets the URL the repository is served at, to make the
+// chart URLs of the index absolute. They are relative to the index by
+// default.
+func RepoServerWithURL(url string) RepoServerOption {
+	return RepoServerOption{f: func(o *repoServerOptions) {
+		o.url = url
+	}}
+}
+
+// RepoServerWithProvenance serves the provenance files of the charts, and
+// accepts them on upload.
+func RepoServerWithProvenance(provenance bool) RepoServerOption {
+	return RepoServerOption{f: func(o *repoServerOptions) {
+		o.provenance = provenance
+	}}
+}
+
+// RepoServerWithAllowOverwrite lets an upload replace a chart version which
+// already exists, instead of failing with 409 Conflict.
+func RepoServerWithAllowOverwrite(allowOverwrite bool) RepoServerOption {
+	return RepoServerOption{f: func(o *repoServerOptions) {
+		o.allowOverwrite = allowOverwrite
+	}}
+}
+
+// RepoServerWithMaxUploadSize limits the size of an upload in bytes.
+func RepoServerWithMaxUploadSize(maxUploadSize int64) RepoServerOption {
+	return RepoServerOption{f: func(o *repoServerOptions) {
+		o.maxUploadSize = maxUploadSize
+	}}
+}
+
+// RepoServerWithReadAuth requires basic auth credentials accepted by auth to
+// get the index, the charts and the chart API. Reading is open by default.
+func RepoServerWithReadAuth(auth BasicAuthFunc) RepoServerOption {
+	return RepoServerOption{f: func(o *repoServerOptions) {
+		o.readAuth = auth
+	}}
+}
+
+// RepoServerWithWriteAuth requires basic auth credentials accepted by auth to
+// upload and delete charts. Writing is open by default, like in a ChartMuseum
+// without authentication.
+func RepoServerWithWriteAuth(auth BasicAuthFunc) RepoServerOption {
+	return RepoServerOption{f: func(o *repoServerOptions) {
+		o.writeAuth = auth
+	}}
+}
+
+// RepoServer is an http.Handler serving a chart repository from a
+// ChartStorage. Mount it under a prefix with http.StripPrefix. It serves
+//
+//	GET /index.yaml                        the index, generated from the storage
+//	GET /charts/<file>                     a chart archive or provenance file
+//	GET /health                            {"healthy": true}
+//	GET /api/charts[/<name>[/<version>]]   the chart versions in the index
+//	POST /api/charts                       upload a chart, and its provenance file
+//	POST /api/prov                         upload a provenance file
+//	DELETE /api/charts/<name>/<version>    delete a chart version
+//
+// The API is compatible with the one of ChartMuseum: a chart is uploaded as
+// the request body or as the chart field of a multipart form, with its
+// provenance file in the prov field.
+//
+// The index is generated when the files in the storage change, reading only
+// the charts which are new or changed since the last time.
+type RepoServer struct {
+	storage ChartStorage
+	opts    *repoServerOptions
+
+	// writeMu serializes the uploads and deletes
+	writeMu sync.Mutex
+
+	mu        sync.Mutex
+	charts    map[string]*repoServerChart
+	indexKey  string
+	index     *repo.IndexFile
+	indexData []byte
+	indexTime time.Time
+}
+
+// repoServerChart is the index entry of a chart archive, kept as long as the
+// archive doesn't change.
+type repoServerChart struct {
+	size    int64
+	modTime time.Time
+	version *repo.ChartVersion
+}
+
+// NewRepoServer serves the chart repository kept in storage.
+func NewRepoServer(storage ChartStorage, opts []RepoServerOption) *RepoServer {
+	return &RepoServer{
+		storage: storage,
+		opts:    newRepoServerOptions(opts),
+		charts:  map[string]*repoServerChart{},
+	}
+}
+
+// Index returns the index of the repository. It is shared, so it must not be
+// changed.
+func (s *RepoServer) Index() (*repo.IndexFile, error) {
+	index, _, _, err := s.cachedIndex()
+	return index, err
+}
+
+// cachedIndex returns the index, generating it again if the files in the
+// storage changed.
+func (s *RepoServer) cachedIndex() (*repo.IndexFile, []byte, time.Time, error) {
+	objects, err := s.storage.List()
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	sum := sha256.New()
+	for _, object := range objects {
+		fmt.Fprintf(sum, "%s %d %d\n", object.Name, object.Size, object.ModTime.UnixNano())
+	}
+	key := fmt.Sprintf("%x", sum.Sum(nil))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index != nil && s.indexKey == key {
+		return s.index, s.indexData, s.indexTime, nil
+	}
+
+	index := repo.NewIndexFile()
+	charts := map[string]*repoServerChart{}
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Name, ".tgz") {
+			continue
+		}
+		cached, ok := s.charts[object.Name]
+		if !ok || cached.size != object.Size || !cached.modTime.Equal(object.ModTime) {
+			version, err := s.loadChartVersion(object)
+			if err != nil {
+				warning("skipping chart %s: %s", object.Name, err)
+				continue
+			}
+			cached = &repoServerChart{size: object.Size, modTime: object.ModTime, version: version}
+		}
+		charts[object.Name] = cached
+		name := cached.version.Name
+		index.Entries[name] = append(index.Entries[name], cached.version)
+	}
+	index.SortEntries()
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	s.charts = charts
+	s.indexKey = key
+	s.index = index
+	s.indexData = data
+	s.indexTime = index.Generated
+	return index, data, s.indexTime, nil
+}
+
+// loadChartVersion reads the index entry of a chart archive.
+func (s *RepoServer) loadChartVersion(object ChartObject) (*repo.ChartVersion, error) {
+	data, err := s.readFile(object.Name)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	md := ch.Metadata
+	if md.APIVersion == "" {
+		md.APIVersion = chart.APIVersionV1
+	}
+	if err := md.Validate(); err != nil {
+		return nil, err
+	}
+	digest, err := provenance.Digest(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.chartURL(object.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &repo.ChartVersion{
+		Metadata: md,
+		URLs:     []string{u},
+		Digest:   digest,
+		Created:  object.ModTime,
+	}, nil
+}
+
+// chartURL is the URL of a chart archive in the index.
+func (s *RepoServer) chartURL(name string) (string, error) {
+	base := "charts"
+	if s.opts.url != "" {
+		base = strings.TrimSuffix(s.opts.url, "/") + "/charts"
+	}
+	return urlutil.URLJoin(base, name)
+}
+
+func (s *RepoServer) readFile(name string) ([]byte, error) {
+	r, err := s.storage.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (s *RepoServer) exists(name string) (bool, error) {
+	r, err := s.storage.Get(name)
+	if os.IsNotExist(errors.Cause(err)) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	r.Close()
+	return true, nil
+}
+
+func (s *RepoServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := "/" + strings.Trim(path.Clean("/"+r.URL.Path), "/")
+	switch {
+	case p == "/health":
+		writeRepoServerJSON(w, http.StatusOK, map[string]bool{"healthy": true})
+	case p == "/index.yaml" && isReadMethod(r.Method):
+		if s.authorized(w, r, false) {
+			s.serveIndex(w, r)
+		}
+	case strings.HasPrefix(p, "/charts/") && isReadMethod(r.Method):
+		if s.authorized(w, r, false) {
+			s.serveFile(w, strings.TrimPrefix(p, "/charts/"))
+		}
+	case (p == "/api/charts" || strings.HasPrefix(p, "/api/charts/")) && isReadMethod(r.Method):
+		if s.authorized(w, r, false) {
+			s.serveCharts(w, strings.TrimPrefix(strings.TrimPrefix(p, "/api/charts"), "/"))
+		}
+	case p == "/api/charts" && r.Method == http.MethodPost:
+		if s.authorized(w, r, true) {
+			s.upload(w, r, false)
+		}
+	case p == "/api/prov" && r.Method == http.MethodPost:
+		if s.authorized(w, r, true) {
+			s.upload(w, r, true)
+		}
+	case strings.HasPrefix(p, "/api/charts/") && r.Method == http.MethodDelete:
+		if s.authorized(w, r, true) {
+			s.delete(w, strings.TrimPrefix(p, "/api/charts/"))
+		}
+	default:
+		writeRepoServerError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// authorized checks the credentials of r with the read or write auth hook,
+// and answers with 401 Unauthorized if they are not accepted.
+func (s *RepoServer) authorized(w http.ResponseWriter, r *http.Request, write bool) bool {
+	auth := s.opts.readAuth
+	if write {
+		auth = s.opts.writeAuth
+	}
+	if auth == nil {
+		return true
+	}
+	username, password, _ := r.BasicAuth()
+	if auth(username, password) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="chart repository"`)
+	writeRepoServerError(w, http.StatusUnauthorized, "unauthorized")
+	return false
+}
+
+func (s *RepoServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	_, data, modTime, err := s.cachedIndex()
+	if err != nil {
+		writeRepoServerError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(data)))
+	http.ServeContent(w, r, "index.yaml", modTime, bytes.NewReader(data))
+}
+
+func (s *RepoServer) serveFile(w http.ResponseWriter, name string) {
+	if checkChartStorageName(name) != nil || (strings.HasSuffix(name, ".prov") && !s.opts.provenance) {
+		writeRepoServerError(w, http.StatusNotFound, "not found")
+		return
+	}
+	f, err := s.storage.Get(name)
+	if os.IsNotExist(errors.Cause(err)) {
+		writeRepoServerError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeRepoServerError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer f.Close()
+	if strings.HasSuffix(name, ".tgz") {
+		w.Header().Set("Content-Type", "application/gzip")
+	} else {
+		w.Header().Set("Content-Type", "application/pgp-signature")
+	}
+	io.Copy(w, f)
+}
+
+// serveCharts serves the chart versions of the index selected by
+// name[/version], all of them if the selection is empty. The version latest
+// selects the latest version.
+func (s *RepoServer) serveCharts(w http.ResponseWriter, selection string) {
+	index, _, _, err := s.cachedIndex()
+	if err != nil {
+		writeRepoServerError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if selection == "" {
+		writeRepoServerJSON(w, http.StatusOK, index.Entries)
+		return
+	}
+	parts := strings.Split(selection, "/")
+	versions, ok := index.Entries[parts[0]]
+	switch {
+	case len(parts) > 2 || !ok:
+		writeRepoServerError(w, http.StatusNotFound, "chart not found")
+	case len(parts) == 1:
+		writeRepoServerJSON(w, http.StatusOK, versions)
+	default:
+		version := findChartVersion(versions, parts[1])
+		if version == nil {
+			writeRepoServerError(w, http.StatusNotFound, "chart version not found")
+			return
+		}
+		writeRepoServerJSON(w, http.StatusOK, version)
+	}
+}
+
+func findChartVersion(versions repo.ChartVersions, version string) *repo.ChartVersion {
+	if version == "latest" && len(versions) > 0 {
+		return versions[0]
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v
+		}
+	}
+	return nil
+}
+
+// upload stores an uploaded chart with its provenance file, or only a
+// provenance file if prov is set.
+func (s *RepoServer) upload(w http.ResponseWriter, r *http.Request, prov bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.opts.maxUploadSize)
+	chartData, provData, err := readUpload(r, prov)
+	if err != nil {
+		writeRepoServerError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if provData != nil && !s.opts.provenance {
+		writeRepoServerError(w, http.StatusBadRequest, "provenance files are not accepted")
+		return
+	}
+
+	files := map[string][]byte{}
+	var chartName string
+	if chartData != nil {
+		ch, err := loader.LoadArchive(bytes.NewReader(chartData))
+		if err != nil {
+			writeRepoServerError(w, http.StatusBadRequest, errors.Wrap(err, "invalid chart").Error())
+			return
+		}
+		chartName = fmt.Sprintf("%s-%s.tgz", ch.Metadata.Name, ch.Metadata.Version)
+		if err := checkChartStorageName(chartName); err != nil {
+			writeRepoServerError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		files[chartName] = chartData
+	}
+	if provData != nil {
+		provChart := chartName
+		if provChart == "" {
+			provChart, err = provenanceChartFile(provData)
+			if err != nil {
+				writeRepoServerError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		files[provChart+".prov"] = provData
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if !s.opts.allowOverwrite {
+		for name := range files {
+			exists, err := s.exists(name)
+			if err != nil {
+				writeRepoServerError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if exists {
+				writeRepoServerError(w, http.StatusConflict, "file already exists")
+				return
+			}
+		}
+	}
+	// the chart goes last, so that it is only in the index with its
+	// provenance file
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.HasSuffix(names[i], ".prov") && !strings.HasSuffix(names[j], ".prov")
+	})
+	for _, name := range names {
+		if err := s.storage.Put(name, files[name]); err != nil {
+			writeRepoServerError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	writeRepoServerJSON(w, http.StatusCreated, map[string]bool{"saved": true})
+}
+
+// readUpload reads the chart and the provenance file of an upload, sent as
+// the request body or as the chart and prov fields of a multipart form.
+func readUpload(r *http.Request, prov bool) ([]byte, []byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(data) == 0 {
+			return nil, nil, errors.New("empty upload")
+		}
+		if prov {
+			return nil, data, nil
+		}
+		return data, nil, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+	var chartData, provData []byte
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch part.FormName() {
+		case "chart":
+			if !prov {
+				chartData = data
+			}
+		case "prov":
+			provData = data
+		}
+	}
+	if chartData == nil && provData == nil {
+		return nil, nil, errors.New("no chart or provenance file in the form")
+	}
+	return chartData, provData, nil
+}
+
+// provenanceChartFile returns the name of the chart archive a provenance file
+// was made for.
+func provenanceChartFile(data []byte) (string, error) {
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return "", errors.New("invalid provenance file: no signed message block")
+	}
+	parts := bytes.Split(block.Plaintext, []byte("\n...\n"))
+	if len(parts) < 2 {
+		return "", errors.New("invalid provenance file: message block must have at least two parts")
+	}
+	sums := &provenance.SumCollection{}
+	if err := yaml.Unmarshal(parts[1], sums); err != nil {
+		return "", errors.Wrap(err, "invalid provenance file")
+	}
+	for name := range sums.Files {
+		if checkChartStorageName(name) == nil && strings.HasSuffix(name, ".tgz") {
+			return name, nil
+		}
+	}
+	return "", errors.New("invalid provenance file: no chart archive")
+}
+
+// delete removes the chart version selected by name/version, with its
+// provenance file.
+func (s *RepoServer) delete(w http.ResponseWriter, selection string) {
+	parts := strings.Split(selection, "/")
+	if len(parts) != 2 {
+		writeRepoServerError(w, http.StatusNotFound, "not found")
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	index, _, _, err := s.cachedIndex()
+	if err != nil {
+		writeRepoServerError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var version *repo.ChartVersion
+	if parts[1] != "latest" {
+		version = findChartVersion(index.Entries[parts[0]], parts[1])
+	}
+	if version == nil || len(version.URLs) == 0 {
+		writeRepoServerError(w, http.StatusNotFound, fmt.Sprintf("chart %s version %s not found", parts[0], parts[1]))
+		return
+	}
+	name := path.Base(version.URLs[0])
+	if err := s.storage.Delete(name); err != nil && !os.IsNotExist(errors.Cause(err)) {
+		writeRepoServerError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := s.storage.Delete(name + ".prov"); err != nil && !os.IsNotExist(errors.Cause(err)) {
+		writeRepoServerError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeRepoServerJSON(w, http.StatusOK, map[string]bool{"deleted": true})
+}
+
+func writeRepoServerJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeRepoServerError(w http.ResponseWriter, status int, message string) {
+	writeRepoServerJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,341 @@
+package helmclient
+
+import (
+	"context"
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"sync"
+	"time"
+)
+
+// repoStoreKey is the key of the repositories file in a Secret or ConfigMap.
+const repoStoreKey = "repositories.yaml"
+
+// errRepoStoreEmpty is returned by the stores which hold no configuration. It
+// is a not exist error, like the one of a missing repositories file.
+var errRepoStoreEmpty = errors.Wrap(os.ErrNotExist, "no repository configuration stored")
+
+// RepoStore keeps the repository configuration, which Helm keeps in the
+// repositories file. The repo commands and the chart lookups of a client go
+// through its store, so that clients sharing a store see the same
+// repositories.
+type RepoStore interface {
+	// Load returns the stored repositories. If nothing was stored yet, it
+	// returns an error for which os.IsNotExist(errors.Cause(err)) is true.
+	Load() (*repo.File, error)
+	// Update lets update change the stored repositories and stores the
+	// result, without losing concurrent updates. A store starts from an empty
+	// file if nothing was stored yet. update may be called again if the
+	// repositories changed in the meantime, and nothing is stored if it
+	// returns an error.
+	Update(update func(f *repo.File) error) error
+}
+
+// fileRepoStore keeps the repositories in a file guarded by a file lock, like
+// Helm does.
+type fileRepoStore struct {
+	path string
+}
+
+// NewFileRepoStore keeps the repositories in the repositories file at path.
+// This is the store of a client without ClientWithRepoStore, at the path of
+// the repository config global option.
+func NewFileRepoStore(path string) RepoStore {
+	return &fileRepoStore{path: path}
+}
+
+func (s *fileRepoStore) Load() (*repo.File, error) {
+	return repo.LoadFile(s.path)
+}
+
+func (s *fileRepoStore) Update(update func(f *repo.File) error) error {
+	// Ensure the file directory exists as it is required for file locking
+	err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	// Acquire a file lock for process synchronization
+	repoFileExt := filepath.Ext(s.path)
+	var lockPath string
+	if len(repoFileExt) > 0 && len(repoFileExt) < len(s.path) {
+		lockPath = strings.Replace(s.path, repoFileExt, ".lock", 1)
+	} else {
+		lockPath = s.path + ".lock"
+	}
+	fileLock := flock.New(lockPath)
+	lockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	locked, err := fileLock.TryLockContext(lockCtx, time.Second)
+	if err == nil && locked {
+		defer fileLock.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := parseRepoFile(b)
+	if err != nil {
+		return err
+	}
+	if err := update(f); err != nil {
+		return err
+	}
+	return f.WriteFile(s.path, 0644)
+}
+
+// memoryRepoStore keeps the repositories in memory.
+type memoryRepoStore struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// NewMemoryRepoStore keeps the repositories in memory, for a client which
+// should not touch the disk.
+func NewMemoryRepoStore() RepoStore {
+	return &memoryRepoStore{}
+}
+
+func (s *memoryRepoStore) Load() (*repo.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		return repo.NewFile(), errRepoStoreEmpty
+	}
+	return parseRepoFile(s.data)
+}
+
+func (s *memoryRepoStore) Update(update func(f *repo.File) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := parseRepoFile(s.data)
+	if err != nil {
+		return err
+	}
+	if err := update(f); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	s.data = data
+	return nil
+}
+
+// kubeRepoStore keeps the repositories under a key of a Kubernetes object.
+type kubeRepoStore struct {
+	// get reads the repositories file of the object, nil if there is none,
+	// and returns a function which writes a new version of it, failing with
+	// a conflict if the object changed in the meantime.
+	get func(ctx context.Context) ([]byte, func(data []byte) error, error)
+}
+
+// NewSecretRepoStore keeps the repositories in the Secret name in namespace,
+// under the key repositories.yaml. The Secret is created on the first update.
+// Replicas of a service sharing the Secret see the same repositories.
+func NewSecretRepoStore(client kubernetes.Interface, namespace string, name string) RepoStore {
+	secrets := client.CoreV1().Secrets(namespace)
+	return &kubeRepoStore{get: func(ctx context.Context) ([]byte, func(data []byte) error, error) {
+		secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, func(data []byte) error {
+				_, err := secrets.Create(ctx, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+					Data:       map[string][]byte{repoStoreKey: data},
+				}, metav1.CreateOptions{})
+				return err
+			}, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return secret.Data[repoStoreKey], func(data []byte) error {
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			secret.Data[repoStoreKey] = data
+			_, err := secrets.Update(ctx, secret, metav1.UpdateOptions{})
+			return err
+		}, nil
+	}}
+}
+
+// NewConfigMapRepoStore keeps the repositories in the ConfigMap name in
+// namespace, like NewSecretRepoStore. Use it only for repositories without
+// credentials, a ConfigMap is not meant for secrets.
+func NewConfigMapRepoStore(client kubernetes.Interface, namespace string, name string) RepoStore {
+	configMaps := client.CoreV1().ConfigMaps(namespace)
+	return &kubeRepoStore{get: func(ctx context.Context) ([]byte, func(data []byte) error, error) {
+		configMap, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, func(data []byte) error {
+				_, err := configMaps.Create(ctx, &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+					Data:       map[string]string{repoStoreKey: string(data)},
+				}, metav1.CreateOptions{})
+				return err
+			}, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var current []byte
+		if data, ok := configMap.Data[repoStoreKey]; ok {
+			current = []byte(data)
+		}
+		return current, func(data []byte) error {
+			if configMap.Data == nil {
+				configMap.Data = map[string]string{}
+			}
+			configMap.Data[repoStoreKey] = string(data)
+			_, err := configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+			return err
+		}, nil
+	}}
+}
+
+func (s *kubeRepoStore) Load() (*repo.File, error) {
+	data, _, err := s.get(context.Background())
+	if err != nil {
+		return repo.NewFile(), err
+	}
+	if data == nil {
+		return repo.NewFile(), errRepoStoreEmpty
+	}
+	return parseRepoFile(data)
+}
+
+func (s *kubeRepoStore) Update(update func(f *repo.File) error) error {
+	// another replica updating at the same time makes the write fail, start
+	// over from its version then
+	return retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		ctx := context.Background()
+		current, save, err := s.get(ctx)
+		if err != nil {
+			return err
+		}
+		f, err := parseRepoFile(current)
+		if err != nil {
+			return err
+		}
+		if err := update(f); err != nil {
+			return err
+		}
+		data, err := yaml.Marshal(f)
+		if err != nil {
+			return err
+		}
+		return save(data)
+	})
+}
+
+// parseRepoFile parses the content of a repositories file, an empty file
+// without content. Like repo.LoadFile, it returns a file even with an error.
+func parseRepoFile(data []byte) (*repo.File, error) {
+	f := repo.NewFile()
+	if len(data) == 0 {
+		return f, nil
+	}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return repo.NewFile(), errors.Wrap(err, "failed to parse the repository configuration")
+	}
+	return f, nil
+}
+
+// repoStore is the store of the repositories of the client.
+func (env *helmEnv) repoStore() RepoStore {
+	if env.clientOpts != nil && env.clientOpts.repoStore != nil {
+		return env.clientOpts.repoStore
+	}
+	return NewFileRepoStore(env.settings.RepositoryConfig)
+}
+
+// repoSettings returns settings with a repositories file holding the
+// repositories of the store which needed selects, for the code of Helm which
+// reads the file itself. The selected repositories are written, with the
+// credentials of the credential provider, to a temporary file which cleanup
+// removes. The file of a file store is used as it is when the provider has no
+// credentials for them.
+func (env *helmEnv) repoSettings(needed func(entry *repo.Entry) bool) (*cli.EnvSettings, func(), error) {
+	store := env.repoStore()
+	f, err := store.Load()
+	if err != nil && !isNotExist(err) {
+		return nil, nil, err
+	}
+	selected := repo.NewFile()
+	withCredentials := false
+	if err == nil {
+		for _, entry := range f.Repositories {
+			if !needed(entry) {
+				continue
+			}
+			// the stored entry may lack the password, see repo add
+			e, err := withRepoCredentials(entry, env)
+			if err != nil {
+				return nil, nil, err
+			}
+			withCredentials = withCredentials || e != entry
+			selected.Add(e)
+		}
+	}
+	if s, ok := store.(*fileRepoStore); ok && s.path == env.settings.RepositoryConfig && !withCredentials {
+		return env.settings, func() {}, nil
+	}
+	dir, err := ioutil.TempDir("", "helm-repositories")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	path := filepath.Join(dir, repoStoreKey)
+	if err := selected.WriteFile(path, 0600); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	settings := *env.settings
+	settings.RepositoryConfig = path
+	return &settings, cleanup, nil
+}
+
+// chartRepoSettings returns the settings LocateChart finds chart with, which
+// only need the repository chart is downloaded from.
+func (env *helmEnv) chartRepoSettings(opts action.ChartPathOptions, chart string) (*cli.EnvSettings, func(), error) {
+	name, _ := chartRepository(opts, chart, env.repoStore())
+	return env.repoSettings(func(entry *repo.Entry) bool {
+		return name != "" && entry.Name == name
+	})
+}
+
+// dependencyRepoSettings returns the settings dependencies are updated with,
+// which only need the repositories they refer to by name or URL.
+func (env *helmEnv) dependencyRepoSettings(dependencies []*chart.Dependency) (*cli.EnvSettings, func(), error) {
+	return env.repoSettings(func(entry *repo.Entry) bool {
+		for _, dep := range dependencies {
+			if dep.Repository == "@"+entry.Name || dep.Repository == "alias:"+entry.Name ||
+				strings.TrimSuffix(dep.Repository, "/") == strings.TrimSuffix(entry.URL, "/") {
+				return true
+			}
+		}
+		return false
+	})
+}
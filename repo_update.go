@@ -1,20 +1,65 @@
 package helmclient
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/outgnaY/helm-go-client/internal/tlsutil"
+	"github.com/outgnaY/helm-go-client/internal/urlutil"
+	"github.com/outgnaY/helm-go-client/internal/version"
 	"github.com/pkg/errors"
-	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/helmpath"
 	"helm.sh/helm/v3/pkg/repo"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	repoUpdateDefaultConcurrency = 4
+	repoUpdateDefaultTimeout     = 120 * time.Second
 )
 
 var errNoRepositories = errors.New("no repositories found. You must add one before updating")
 
+// RepoUpdateStatus is the outcome of updating the index of a repository.
+type RepoUpdateStatus string
+
+const (
+	// RepoUpdateUpdated is a repository whose index was downloaded.
+	RepoUpdateUpdated RepoUpdateStatus = "updated"
+	// RepoUpdateUnchanged is a repository whose index had not changed since
+	// the last update, so that it was not downloaded again.
+	RepoUpdateUnchanged RepoUpdateStatus = "unchanged"
+	// RepoUpdateFailed is a repository whose index could not be updated.
+	RepoUpdateFailed RepoUpdateStatus = "failed"
+)
+
+// RepoUpdateResult is the outcome of updating one repository.
+type RepoUpdateResult struct {
+	Name   string
+	URL    string
+	Status RepoUpdateStatus
+	// Err is set when Status is RepoUpdateFailed.
+	Err error
+	// IndexSize is the size of the cached index in bytes.
+	IndexSize int64
+	// Charts is the number of charts in the index, ChartVersions the number of
+	// versions of all of them.
+	Charts        int
+	ChartVersions int
+}
+
 type repoUpdateClient interface {
-	RepoUpdate() error
+	RepoUpdate(args []string) ([]*RepoUpdateResult, error)
 }
 
 type repoUpdateClientImpl struct {
@@ -22,35 +67,49 @@ type repoUpdateClientImpl struct {
 	env            *helmEnv
 }
 
+type RepoUpdateOption struct {
+	f func(o *repoUpdateOptions)
+}
+
 type repoUpdateOptions struct {
-	update    func([]*repo.ChartRepository, io.Writer)
-	repoFile  string
-	repoCache string
+	names       []string
+	concurrency int
+	timeout     time.Duration
+	repoCache   string
+}
+
+func (o *repoUpdateOptions) apply(opts []RepoUpdateOption) {
+	for _, op := range opts {
+		op.f(o)
+	}
 }
 
-func newRepoUpdateOptions() *repoUpdateOptions {
+func newRepoUpdateOptions(opts []RepoUpdateOption) *repoUpdateOptions {
 	options := &repoUpdateOptions{
-		update: updateCharts,
+		concurrency: repoUpdateDefaultConcurrency,
+		timeout:     repoUpdateDefaultTimeout,
 	}
+	options.apply(opts)
 	return options
 }
 
-func updateCharts(repos []*repo.ChartRepository, out io.Writer) {
-	fmt.Fprintln(out, "Hang tight while we grab the latest from your chart repositories...")
-	var wg sync.WaitGroup
-	for _, re := range repos {
-		wg.Add(1)
-		go func(re *repo.ChartRepository) {
-			defer wg.Done()
-			if _, err := re.DownloadIndexFile(); err != nil {
-				fmt.Fprintf(out, "...Unable to get an update from the %q chart repository (%s):\n\t%s\n", re.Config.Name, re.Config.URL, err)
-			} else {
-				fmt.Fprintf(out, "...Successfully got an update from the %q chart repository\n", re.Config.Name)
-			}
-		}(re)
-	}
-	wg.Wait()
-	fmt.Fprintln(out, "Update Complete. ⎈Happy Helming!⎈")
+// RepoUpdateWithConcurrency limits how many repositories are updated at the
+// same time.
+func RepoUpdateWithConcurrency(concurrency int) RepoUpdateOption {
+	return RepoUpdateOption{f: func(o *repoUpdateOptions) {
+		o.concurrency = concurrency
+	}}
+}
+
+// RepoUpdateWithTimeout limits the time the update of each repository may
+// take, zero for no limit. The index of a repository which isn't served over
+// HTTP(S) is downloaded by the getter of its scheme, which is given the
+// timeout, but getter plugins run as commands of their own which Helm
+// doesn't stop, so that the timeout doesn't bound them.
+func RepoUpdateWithTimeout(timeout time.Duration) RepoUpdateOption {
+	return RepoUpdateOption{f: func(o *repoUpdateOptions) {
+		o.timeout = timeout
+	}}
 }
 
 func (c *repoUpdateClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
@@ -59,48 +118,360 @@ func (c *repoUpdateClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) err
 }
 
 func (c *repoUpdateClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	c.env = env
 	return nil
 }
 
-func newRepoUpdateClient(env *helmEnv) (*repoUpdateClientImpl, error) {
-	o := newRepoUpdateOptions()
+func newRepoUpdateClient(opts []RepoUpdateOption, env *helmEnv) (*repoUpdateClientImpl, error) {
+	o := newRepoUpdateOptions(opts)
 	return &repoUpdateClientImpl{
 		repoUpdateOpts: o,
 		env:            env,
 	}, nil
 }
 
-func (o *repoUpdateOptions) run(out io.Writer, settings *cli.EnvSettings) error {
-	f, err := repo.LoadFile(o.repoFile)
+func (o *repoUpdateOptions) run(env *helmEnv) ([]*RepoUpdateResult, error) {
+	f, err := env.repoStore().Load()
 	switch {
 	case isNotExist(err):
-		return errNoRepositories
+		return nil, errNoRepositories
 	case err != nil:
-		return errors.Wrapf(err, "failed loading file: %s", o.repoFile)
+		return nil, errors.Wrap(err, "failed loading repositories")
 	case len(f.Repositories) == 0:
-		return errNoRepositories
+		return nil, errNoRepositories
+	}
+
+	entries := f.Repositories
+	if len(o.names) > 0 {
+		entries = nil
+		for _, name := range o.names {
+			entry := f.Get(name)
+			if entry == nil {
+				return nil, errors.Errorf("no repositories found matching '%s'. Nothing will be updated", name)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	concurrency := o.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	results := make([]*RepoUpdateResult, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		results[i] = &RepoUpdateResult{Name: entry.Name, URL: entry.URL}
+		wg.Add(1)
+		go func(entry *repo.Entry, result *RepoUpdateResult) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := o.updateRepo(entry, env, result); err != nil {
+				result.Status = RepoUpdateFailed
+				result.Err = err
+				debug("Unable to get an update from the %q chart repository (%s): %s", entry.Name, entry.URL, err)
+				return
+			}
+			debug("Got an update from the %q chart repository: %s", entry.Name, result.Status)
+		}(entry, results[i])
+	}
+	wg.Wait()
+	return results, repoUpdateSummary(results)
+}
+
+// updateRepo downloads the index of entry to the cache, unless the cached one
+// is up to date, and fills in result.
+func (o *repoUpdateOptions) updateRepo(entry *repo.Entry, env *helmEnv, result *RepoUpdateResult) error {
+	entry, err := withRepoCredentials(entry, env)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(entry.URL)
+	if err != nil {
+		return errors.Wrapf(err, "invalid chart URL format: %s", entry.URL)
+	}
+	var index *repoIndexValidators
+	var status RepoUpdateStatus
+	if u.Scheme == "http" || u.Scheme == "https" {
+		index, status, err = o.downloadIndex(entry)
+	} else {
+		index, status, err = o.downloadIndexWithGetter(entry, env)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "looks like %q is not a valid chart repository or cannot be reached", entry.URL)
+	}
+	result.Status = status
+	result.IndexSize = index.Size
+	result.Charts = index.Charts
+	result.ChartVersions = index.ChartVersions
+	return nil
+}
+
+// repoIndexValidators describe the cached index of a repository, with the
+// validators the server returned for it so that the next download can be
+// conditional.
+type repoIndexValidators struct {
+	URL           string `json:"url"`
+	ETag          string `json:"etag,omitempty"`
+	LastModified  string `json:"lastModified,omitempty"`
+	Size          int64  `json:"size"`
+	Charts        int    `json:"charts"`
+	ChartVersions int    `json:"chartVersions"`
+}
+
+// cacheValidatorsFile is the file the validators of the index of the
+// repository name are kept in, next to the index.
+func cacheValidatorsFile(name string) string {
+	return name + "-index.validators.json"
+}
+
+// cachedValidators returns the validators of the cached index of entry, or
+// nil if there is no cached index of its URL.
+func (o *repoUpdateOptions) cachedValidators(entry *repo.Entry) *repoIndexValidators {
+	data, err := ioutil.ReadFile(filepath.Join(o.repoCache, cacheValidatorsFile(entry.Name)))
+	if err != nil {
+		return nil
+	}
+	var validators repoIndexValidators
+	if err := json.Unmarshal(data, &validators); err != nil || validators.URL != entry.URL {
+		return nil
+	}
+	info, err := os.Stat(filepath.Join(o.repoCache, helmpath.CacheIndexFile(entry.Name)))
+	if err != nil || info.Size() != validators.Size {
+		return nil
+	}
+	return &validators
+}
+
+// downloadIndex downloads the index of a repository served over HTTP(S),
+// conditionally if the cached index has validators.
+func (o *repoUpdateOptions) downloadIndex(entry *repo.Entry) (*repoIndexValidators, RepoUpdateStatus, error) {
+	indexURL, err := repoIndexURL(entry.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := repoHTTPClient(entry)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx := context.Background()
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, indexURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", version.GetUserAgent())
+	if entry.Username != "" && entry.Password != "" {
+		req.SetBasicAuth(entry.Username, entry.Password)
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		// like the HTTP getter of Helm, the credentials are only sent to the
+		// host of the repository unless the repository passes them to all
+		// domains
+		if entry.Username != "" && entry.Password != "" &&
+			(entry.PassCredentialsAll || (req.URL.Scheme == via[0].URL.Scheme && req.URL.Host == via[0].URL.Host)) {
+			req.SetBasicAuth(entry.Username, entry.Password)
+		} else {
+			req.Header.Del("Authorization")
+		}
+		return nil
+	}
+	cached := o.cachedValidators(entry)
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached, RepoUpdateUnchanged, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", errors.Errorf("failed to fetch %s : %s", indexURL, resp.Status)
 	}
 
-	var repos []*repo.ChartRepository
-	for _, cfg := range f.Repositories {
-		r, err := repo.NewChartRepository(cfg, getter.All(settings))
+	validators, err := o.writeIndex(entry.Name, resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	validators.URL = entry.URL
+	validators.ETag = resp.Header.Get("ETag")
+	validators.LastModified = resp.Header.Get("Last-Modified")
+	data, err := json.Marshal(validators)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(o.repoCache, cacheValidatorsFile(entry.Name)), data, 0644); err != nil {
+		return nil, "", err
+	}
+	return validators, RepoUpdateUpdated, nil
+}
+
+// writeIndex checks the index read from r and replaces the cached index of
+// the repository name with it, together with the list of its charts.
+func (o *repoUpdateOptions) writeIndex(name string, r io.Reader) (*repoIndexValidators, error) {
+	if err := os.MkdirAll(o.repoCache, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(o.repoCache, helmpath.CacheIndexFile(name))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	size, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	index, err := repo.LoadIndexFile(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	if err := writeChartsFile(o.repoCache, name, index); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(o.repoCache, helmpath.CacheIndexFile(name))); err != nil {
+		return nil, err
+	}
+	validators := indexValidators(index)
+	validators.Size = size
+	return validators, nil
+}
+
+// downloadIndexWithGetter downloads the index of a repository with the getter
+// of its scheme, which always downloads it.
+func (o *repoUpdateOptions) downloadIndexWithGetter(entry *repo.Entry, env *helmEnv) (*repoIndexValidators, RepoUpdateStatus, error) {
+	u, err := url.Parse(entry.URL)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "invalid chart URL format: %s", entry.URL)
+	}
+	g, err := getter.All(env.settings).ByScheme(u.Scheme)
+	if err != nil {
+		return nil, "", errors.Errorf("could not find protocol handler for: %s", u.Scheme)
+	}
+	indexURL, err := repoIndexURL(entry.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	opts := []getter.Option{
+		getter.WithURL(entry.URL),
+		getter.WithInsecureSkipVerifyTLS(entry.InsecureSkipTLSverify),
+		getter.WithTLSClientConfig(entry.CertFile, entry.KeyFile, entry.CAFile),
+		getter.WithBasicAuth(entry.Username, entry.Password),
+		getter.WithPassCredentialsAll(entry.PassCredentialsAll),
+	}
+	if o.timeout > 0 {
+		opts = append(opts, getter.WithTimeout(o.timeout))
+	}
+	data, err := g.Get(indexURL, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	// the validators of an earlier download over HTTP(S) are stale
+	os.Remove(filepath.Join(o.repoCache, cacheValidatorsFile(entry.Name)))
+	validators, err := o.writeIndex(entry.Name, data)
+	if err != nil {
+		return nil, "", err
+	}
+	return validators, RepoUpdateUpdated, nil
+}
+
+func indexValidators(index *repo.IndexFile) *repoIndexValidators {
+	validators := &repoIndexValidators{Charts: len(index.Entries)}
+	for _, versions := range index.Entries {
+		validators.ChartVersions += len(versions)
+	}
+	return validators
+}
+
+// writeChartsFile writes the names of the charts of index to the cache, as
+// Helm does for completion.
+func writeChartsFile(cache string, name string, index *repo.IndexFile) error {
+	var charts strings.Builder
+	for chart := range index.Entries {
+		fmt.Fprintln(&charts, chart)
+	}
+	return ioutil.WriteFile(filepath.Join(cache, helmpath.CacheChartsFile(name)), []byte(charts.String()), 0644)
+}
+
+// repoIndexURL is the URL of the index.yaml of the repository at repoURL.
+func repoIndexURL(repoURL string) (string, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid chart URL format: %s", repoURL)
+	}
+	u.RawPath = path.Join(u.RawPath, "index.yaml")
+	u.Path = path.Join(u.Path, "index.yaml")
+	return u.String(), nil
+}
+
+// repoHTTPClient is an HTTP client with the TLS configuration of entry, like
+// the HTTP getter of Helm.
+func repoHTTPClient(entry *repo.Entry) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+	if entry.CertFile != "" || entry.KeyFile != "" || entry.CAFile != "" || entry.InsecureSkipTLSverify {
+		tlsConf, err := tlsutil.NewClientTLS(entry.CertFile, entry.KeyFile, entry.CAFile)
 		if err != nil {
-			return err
+			return nil, errors.Wrap(err, "can't create TLS config for client")
 		}
-		if o.repoCache != "" {
-			r.CachePath = o.repoCache
+		if host, err := urlutil.ExtractHostname(entry.URL); err == nil {
+			tlsConf.ServerName = host
 		}
-		repos = append(repos, r)
+		tlsConf.InsecureSkipVerify = entry.InsecureSkipTLSverify
+		transport.TLSClientConfig = tlsConf
 	}
+	return &http.Client{Transport: transport}, nil
+}
 
-	o.update(repos, out)
+func repoUpdateSummary(results []*RepoUpdateResult) error {
+	updated, unchanged, failed := 0, 0, 0
+	for _, result := range results {
+		switch result.Status {
+		case RepoUpdateUpdated:
+			updated++
+		case RepoUpdateUnchanged:
+			unchanged++
+		case RepoUpdateFailed:
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d repository(s) updated, %d unchanged, %d failed", updated, unchanged, failed)
+	}
 	return nil
 }
 
-func (c *repoUpdateClientImpl) RepoUpdate() error {
-	c.repoUpdateOpts.repoFile = c.env.settings.RepositoryConfig
+// RepoUpdate updates the cached indexes of the repositories named by args, of
+// all repositories without args. Indexes are downloaded concurrently, up to
+// the limit given by RepoUpdateWithConcurrency, and only if they changed since
+// the last update when the server supports ETag or Last-Modified. The results
+// are in the order of the repositories. If any repository fails, the results
+// are returned together with a summary error.
+func (c *repoUpdateClientImpl) RepoUpdate(args []string) ([]*RepoUpdateResult, error) {
+	c.repoUpdateOpts.names = args
 	c.repoUpdateOpts.repoCache = c.env.settings.RepositoryCache
-	return c.repoUpdateOpts.run(os.Stdout, c.env.settings)
+	return c.repoUpdateOpts.run(c.env)
 }
@@ -127,7 +127,7 @@ func (c *rollbackClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error
 }
 
 func (c *rollbackClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
@@ -1,18 +1,64 @@
 package helmclient
 
 import (
-	"github.com/outgnaY/helm-go-client/internal/monocular"
 	"fmt"
+	"github.com/outgnaY/helm-go-client/internal/artifacthub"
 	"github.com/pkg/errors"
+	"net/http"
 	"strings"
+	"time"
 )
 
 const (
-	searchHubDefaultSearchEndPoint = "https://hub.helm.sh"
+	searchHubDefaultSearchEndPoint    = "https://artifacthub.io"
+	searchHubDefaultOfficial          = false
+	searchHubDefaultVerifiedPublisher = false
+	searchHubDefaultDeprecated        = false
+	searchHubDefaultLimit             = 20
+	searchHubDefaultOffset            = 0
+	searchHubDefaultTimeout           = 30 * time.Second
 )
 
+// Kinds of the repositories of the Artifact Hub, for SearchHubWithKinds.
+const (
+	HubKindHelm   = artifacthub.KindHelm
+	HubKindFalco  = artifacthub.KindFalco
+	HubKindOPA    = artifacthub.KindOPA
+	HubKindOLM    = artifacthub.KindOLM
+	HubKindTekton = artifacthub.KindTekton
+)
+
+// The results of the Artifact Hub API.
+type (
+	HubSearchResult          = artifacthub.SearchResult
+	HubPackageSummary        = artifacthub.PackageSummary
+	HubRepository            = artifacthub.Repository
+	HubSecurityReportSummary = artifacthub.SecurityReportSummary
+	HubPackage               = artifacthub.Package
+	HubMaintainer            = artifacthub.Maintainer
+	HubLink                  = artifacthub.Link
+	HubAvailableVersion      = artifacthub.AvailableVersion
+	HubSecurityReport        = artifacthub.SecurityReport
+	HubImageReport           = artifacthub.ImageReport
+	HubImageReportResult     = artifacthub.ImageReportResult
+	HubVulnerability         = artifacthub.Vulnerability
+)
+
+// ErrHubNotFound is returned for a chart or a version the Artifact Hub
+// doesn't know.
+var ErrHubNotFound = artifacthub.ErrNotFound
+
+// searchHubPackageKind is the kind of the packages the hub has details of,
+// in the paths of the API.
+const searchHubPackageKind = "helm"
+
 type searchHubClient interface {
-	SearchHub(args []string) ([]monocular.SearchResult, error)
+	SearchHub(args []string) (*HubSearchResult, error)
+	HubPackage(chart string, version string) (*HubPackage, error)
+	HubVersions(chart string) ([]HubAvailableVersion, error)
+	HubValues(chart string, version string) ([]byte, error)
+	HubValuesSchema(chart string, version string) ([]byte, error)
+	HubSecurityReport(chart string, version string) (HubSecurityReport, error)
 }
 
 type searchHubClientImpl struct {
@@ -25,7 +71,15 @@ type SearchHubOption struct {
 }
 
 type searchHubOptions struct {
-	searchEndpoint string
+	searchEndpoint    string
+	kinds             []int
+	official          bool
+	verifiedPublisher bool
+	repositories      []string
+	deprecated        bool
+	limit             int
+	offset            int
+	timeout           time.Duration
 }
 
 func (o *searchHubOptions) apply(opts []SearchHubOption) {
@@ -36,25 +90,93 @@ func (o *searchHubOptions) apply(opts []SearchHubOption) {
 
 func newSearchHubOptions(opts []SearchHubOption) *searchHubOptions {
 	options := &searchHubOptions{
-		searchEndpoint: searchHubDefaultSearchEndPoint,
+		searchEndpoint:    searchHubDefaultSearchEndPoint,
+		kinds:             []int{HubKindHelm},
+		official:          searchHubDefaultOfficial,
+		verifiedPublisher: searchHubDefaultVerifiedPublisher,
+		deprecated:        searchHubDefaultDeprecated,
+		limit:             searchHubDefaultLimit,
+		offset:            searchHubDefaultOffset,
+		timeout:           searchHubDefaultTimeout,
 	}
 	options.apply(opts)
 	return options
 }
 
+// SearchHubWithSearchEndpoint sets the URL of the Artifact Hub, or of a
+// service with a compatible API.
 func SearchHubWithSearchEndpoint(searchEndpoint string) SearchHubOption {
 	return SearchHubOption{f: func(o *searchHubOptions) {
 		o.searchEndpoint = searchEndpoint
 	}}
 }
 
+// SearchHubWithKinds sets the kinds of the repositories searched, such as
+// HubKindHelm, which is searched by default. No kinds search all of them.
+func SearchHubWithKinds(kinds []int) SearchHubOption {
+	return SearchHubOption{f: func(o *searchHubOptions) {
+		o.kinds = kinds
+	}}
+}
+
+// SearchHubWithOfficial only finds official packages.
+func SearchHubWithOfficial(official bool) SearchHubOption {
+	return SearchHubOption{f: func(o *searchHubOptions) {
+		o.official = official
+	}}
+}
+
+// SearchHubWithVerifiedPublisher only finds packages of verified publishers.
+func SearchHubWithVerifiedPublisher(verifiedPublisher bool) SearchHubOption {
+	return SearchHubOption{f: func(o *searchHubOptions) {
+		o.verifiedPublisher = verifiedPublisher
+	}}
+}
+
+// SearchHubWithRepositories only finds packages in the repositories named
+// repositories.
+func SearchHubWithRepositories(repositories []string) SearchHubOption {
+	return SearchHubOption{f: func(o *searchHubOptions) {
+		o.repositories = append(o.repositories, repositories...)
+	}}
+}
+
+// SearchHubWithDeprecated also finds deprecated packages.
+func SearchHubWithDeprecated(deprecated bool) SearchHubOption {
+	return SearchHubOption{f: func(o *searchHubOptions) {
+		o.deprecated = deprecated
+	}}
+}
+
+// SearchHubWithLimit sets the number of packages of a page of results.
+func SearchHubWithLimit(limit int) SearchHubOption {
+	return SearchHubOption{f: func(o *searchHubOptions) {
+		o.limit = limit
+	}}
+}
+
+// SearchHubWithOffset skips the first offset packages found.
+func SearchHubWithOffset(offset int) SearchHubOption {
+	return SearchHubOption{f: func(o *searchHubOptions) {
+		o.offset = offset
+	}}
+}
+
+// SearchHubWithTimeout limits the time each request to the hub may take, zero
+// for no limit.
+func SearchHubWithTimeout(timeout time.Duration) SearchHubOption {
+	return SearchHubOption{f: func(o *searchHubOptions) {
+		o.timeout = timeout
+	}}
+}
+
 func (c *searchHubClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
 	// use old namespace
 	return c.OverrideGlobalOptsWithNamespace(globalOpts, c.env.Namespace())
 }
 
 func (c *searchHubClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	c.env = env
 	return nil
 }
@@ -67,15 +189,98 @@ func newSearchHubClient(opts []SearchHubOption, env *helmEnv) (*searchHubClientI
 	}, nil
 }
 
-func (o *searchHubOptions) run(args []string) ([]monocular.SearchResult, error) {
-	c, err := monocular.New(o.searchEndpoint)
+func (o *searchHubOptions) client() (*artifacthub.Client, error) {
+	c, err := artifacthub.New(o.searchEndpoint)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("unable to create connection to %q", o.searchEndpoint))
 	}
-	q := strings.Join(args, " ")
-	return c.Search(q)
+	c.HTTPClient = &http.Client{Timeout: o.timeout}
+	return c, nil
+}
+
+func (o *searchHubOptions) run(args []string) (*artifacthub.SearchResult, error) {
+	c, err := o.client()
+	if err != nil {
+		return nil, err
+	}
+	return c.Search(artifacthub.SearchOptions{
+		Query:             strings.Join(args, " "),
+		Kinds:             o.kinds,
+		Official:          o.official,
+		VerifiedPublisher: o.verifiedPublisher,
+		Repositories:      o.repositories,
+		Deprecated:        o.deprecated,
+		Limit:             o.limit,
+		Offset:            o.offset,
+	})
 }
 
-func (c *searchHubClientImpl) SearchHub(args []string) ([]monocular.SearchResult, error) {
+// hubPackage retrieves a version of chart, given as repo/name, the latest
+// version if version is empty.
+func (o *searchHubOptions) hubPackage(chart string, version string) (*artifacthub.Client, *artifacthub.Package, error) {
+	c, err := o.client()
+	if err != nil {
+		return nil, nil, err
+	}
+	i := strings.Index(chart, "/")
+	if i <= 0 || i == len(chart)-1 {
+		return nil, nil, errors.Errorf("invalid chart %q, expected repo/name", chart)
+	}
+	p, err := c.Package(searchHubPackageKind, chart[:i], chart[i+1:], version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, p, nil
+}
+
+// SearchHub searches the Artifact Hub for packages matching args, with the
+// filters and the page given by the options.
+func (c *searchHubClientImpl) SearchHub(args []string) (*HubSearchResult, error) {
 	return c.searchHubOpts.run(args)
 }
+
+// HubPackage retrieves the details of a version of a chart of the Artifact
+// Hub, given as repo/name. An empty version is the latest version.
+func (c *searchHubClientImpl) HubPackage(chart string, version string) (*HubPackage, error) {
+	_, p, err := c.searchHubOpts.hubPackage(chart, version)
+	return p, err
+}
+
+// HubVersions lists the versions of a chart of the Artifact Hub.
+func (c *searchHubClientImpl) HubVersions(chart string) ([]HubAvailableVersion, error) {
+	_, p, err := c.searchHubOpts.hubPackage(chart, "")
+	if err != nil {
+		return nil, err
+	}
+	return p.AvailableVersions, nil
+}
+
+// HubValues retrieves the default values of a version of a chart of the
+// Artifact Hub.
+func (c *searchHubClientImpl) HubValues(chart string, version string) ([]byte, error) {
+	hub, p, err := c.searchHubOpts.hubPackage(chart, version)
+	if err != nil {
+		return nil, err
+	}
+	return hub.Values(p.PackageID, p.Version)
+}
+
+// HubValuesSchema retrieves the values schema of a version of a chart of the
+// Artifact Hub.
+func (c *searchHubClientImpl) HubValuesSchema(chart string, version string) ([]byte, error) {
+	hub, p, err := c.searchHubOpts.hubPackage(chart, version)
+	if err != nil {
+		return nil, err
+	}
+	return hub.ValuesSchema(p.PackageID, p.Version)
+}
+
+// HubSecurityReport retrieves the security report of the images of a version
+// of a chart of the Artifact Hub.
+func (c *searchHubClientImpl) HubSecurityReport(chart string, version string) (HubSecurityReport, error) {
+	hub, p, err := c.searchHubOpts.hubPackage(chart, version)
+	if err != nil {
+		return nil, err
+	}
+	return hub.SecurityReport(p.PackageID, p.Version)
+}
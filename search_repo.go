@@ -7,19 +7,64 @@ import (
 	"helm.sh/helm/v3/pkg/helmpath"
 	"helm.sh/helm/v3/pkg/repo"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 const (
-	searchMaxScore            = 25
+	searchRepoDefaultMaxScore = 25
 	searchRepoDefaultVersions = false
 	searchRepoDefaultRegexp   = false
 	searchRepoDefaultDevel    = false
 	searchRepoDefaultVersion  = ""
+	searchRepoDefaultSort     = SearchRepoSortScore
+	searchRepoDefaultOffset   = 0
+	searchRepoDefaultLimit    = 0
 )
 
+// SearchRepoSort is the order of search results.
+type SearchRepoSort string
+
+const (
+	// SearchRepoSortScore sorts the results by relevance, the best match
+	// first. Results with the same score are sorted by name.
+	SearchRepoSortScore SearchRepoSort = "score"
+	// SearchRepoSortName sorts the results by name.
+	SearchRepoSortName SearchRepoSort = "name"
+	// SearchRepoSortNewest sorts the results by the creation time of their
+	// chart version, the newest first.
+	SearchRepoSortNewest SearchRepoSort = "newest"
+)
+
+// SearchRepoResult is a chart version found by SearchRepo.
+type SearchRepoResult struct {
+	// Name is the repo/chart name of the chart.
+	Name string
+	Repo string
+	// Score is how far the chart is from matching the search terms, zero for
+	// the best match.
+	Score       int
+	Version     string
+	AppVersion  string
+	Description string
+	Deprecated  bool
+	Created     time.Time
+	Digest      string
+	URLs        []string
+	// Chart is the index entry of the chart version.
+	Chart *repo.ChartVersion
+}
+
+// SearchRepoResults is a page of search results.
+type SearchRepoResults struct {
+	Results []*SearchRepoResult
+	// Total is the number of results on all pages.
+	Total int
+}
+
 type searchRepoClient interface {
-	SearchRepo(args []string) ([]*search.Result, error)
+	SearchRepo(args []string) (*SearchRepoResults, error)
 }
 
 type searchRepoClientImpl struct {
@@ -31,12 +76,29 @@ type SearchRepoOption struct {
 	f func(o *searchRepoOptions)
 }
 
+// searchRepoAnnotation is an annotation a chart has, with any value if value
+// is empty.
+type searchRepoAnnotation struct {
+	key   string
+	value string
+}
+
 type searchRepoOptions struct {
+	maxScore     int
 	versions     bool
 	regexp       bool
 	devel        bool
 	version      string
-	repoFile     string
+	keywords     []string
+	annotations  []searchRepoAnnotation
+	maintainer   string
+	appVersion   string
+	deprecated   *bool
+	repos        []string
+	sort         SearchRepoSort
+	offset       int
+	limit        int
+	repoStore    RepoStore
 	repoCacheDir string
 }
 
@@ -48,10 +110,14 @@ func (o *searchRepoOptions) apply(opts []SearchRepoOption) {
 
 func newSearchRepoOptions(opts []SearchRepoOption) *searchRepoOptions {
 	options := &searchRepoOptions{
+		maxScore: searchRepoDefaultMaxScore,
 		versions: searchRepoDefaultVersions,
 		regexp:   searchRepoDefaultRegexp,
 		devel:    searchRepoDefaultDevel,
 		version:  searchRepoDefaultVersion,
+		sort:     searchRepoDefaultSort,
+		offset:   searchRepoDefaultOffset,
+		limit:    searchRepoDefaultLimit,
 	}
 	options.apply(opts)
 	return options
@@ -81,13 +147,91 @@ func SearchRepoWithVersion(version string) SearchRepoOption {
 	}}
 }
 
+// SearchRepoWithMaxScore sets the score from which a chart no longer matches
+// the search terms.
+func SearchRepoWithMaxScore(maxScore int) SearchRepoOption {
+	return SearchRepoOption{f: func(o *searchRepoOptions) {
+		o.maxScore = maxScore
+	}}
+}
+
+// SearchRepoWithKeywords only finds charts which have all keywords, ignoring
+// case.
+func SearchRepoWithKeywords(keywords []string) SearchRepoOption {
+	return SearchRepoOption{f: func(o *searchRepoOptions) {
+		o.keywords = append(o.keywords, keywords...)
+	}}
+}
+
+// SearchRepoWithAnnotation only finds charts with the annotation key, whose
+// value is value unless it is empty. The option may be given several times.
+func SearchRepoWithAnnotation(key string, value string) SearchRepoOption {
+	return SearchRepoOption{f: func(o *searchRepoOptions) {
+		o.annotations = append(o.annotations, searchRepoAnnotation{key: key, value: value})
+	}}
+}
+
+// SearchRepoWithMaintainer only finds charts with a maintainer whose name or
+// email is maintainer, ignoring case.
+func SearchRepoWithMaintainer(maintainer string) SearchRepoOption {
+	return SearchRepoOption{f: func(o *searchRepoOptions) {
+		o.maintainer = maintainer
+	}}
+}
+
+// SearchRepoWithAppVersion only finds chart versions whose app version
+// satisfies the semantic version constraint appVersion.
+func SearchRepoWithAppVersion(appVersion string) SearchRepoOption {
+	return SearchRepoOption{f: func(o *searchRepoOptions) {
+		o.appVersion = appVersion
+	}}
+}
+
+// SearchRepoWithDeprecated only finds deprecated charts if deprecated is
+// true, and only charts which are not deprecated otherwise. Without the
+// option both are found.
+func SearchRepoWithDeprecated(deprecated bool) SearchRepoOption {
+	return SearchRepoOption{f: func(o *searchRepoOptions) {
+		o.deprecated = &deprecated
+	}}
+}
+
+// SearchRepoWithRepos only searches the repositories named repos.
+func SearchRepoWithRepos(repos []string) SearchRepoOption {
+	return SearchRepoOption{f: func(o *searchRepoOptions) {
+		o.repos = append(o.repos, repos...)
+	}}
+}
+
+// SearchRepoWithSort sets the order of the results.
+func SearchRepoWithSort(sort SearchRepoSort) SearchRepoOption {
+	return SearchRepoOption{f: func(o *searchRepoOptions) {
+		o.sort = sort
+	}}
+}
+
+// SearchRepoWithOffset skips the first offset results.
+func SearchRepoWithOffset(offset int) SearchRepoOption {
+	return SearchRepoOption{f: func(o *searchRepoOptions) {
+		o.offset = offset
+	}}
+}
+
+// SearchRepoWithLimit returns at most limit results, all of them if it is
+// zero.
+func SearchRepoWithLimit(limit int) SearchRepoOption {
+	return SearchRepoOption{f: func(o *searchRepoOptions) {
+		o.limit = limit
+	}}
+}
+
 func (c *searchRepoClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error {
 	// use old namespace
 	return c.OverrideGlobalOptsWithNamespace(globalOpts, c.env.Namespace())
 }
 
 func (c *searchRepoClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	c.env = env
 	return nil
 }
@@ -100,29 +244,56 @@ func newSearchRepoClient(opts []SearchRepoOption, env *helmEnv) (*searchRepoClie
 	}, nil
 }
 
-func (o *searchRepoOptions) run(args []string) ([]*search.Result, error) {
+func (o *searchRepoOptions) run(args []string) (*SearchRepoResults, error) {
 	o.setupSearchedVersion()
 	index, err := o.buildIndex()
 	if err != nil {
 		return nil, err
 	}
+	return o.search(index, args)
+}
+
+// search searches index, which has all chart versions, for charts matching
+// args.
+func (o *searchRepoOptions) search(index *search.Index, args []string) (*SearchRepoResults, error) {
+	filter, err := o.newSearchRepoFilter()
+	if err != nil {
+		return nil, err
+	}
 	var res []*search.Result
 	if len(args) == 0 {
 		res = index.All()
 	} else {
 		q := strings.Join(args, " ")
-		res, err = index.Search(q, searchMaxScore, o.regexp)
+		res, err = index.Search(q, o.maxScore, o.regexp)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	res = filter.apply(res)
 	search.SortScore(res)
-	return o.applyConstraint(res)
+	res, err = o.applyConstraint(res)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*SearchRepoResult, len(res))
+	for i, r := range res {
+		results[i] = newSearchRepoResult(r)
+	}
+	if err := sortSearchRepoResults(results, o.sort); err != nil {
+		return nil, err
+	}
+	return &SearchRepoResults{Results: pageSearchRepoResults(results, o.offset, o.limit), Total: len(results)}, nil
 }
 
-func (c *searchRepoClientImpl) SearchRepo(args []string) ([]*search.Result, error) {
-	c.searchRepoOpts.repoFile = c.env.settings.RepositoryConfig
+// SearchRepo searches the cached indexes of the configured repositories for
+// charts matching args, listing all charts without args. Only the highest
+// matching version of each chart is found, unless SearchRepoWithVersions is
+// set. The results are filtered and sorted as the options say and the page
+// given by SearchRepoWithOffset and SearchRepoWithLimit is returned.
+func (c *searchRepoClientImpl) SearchRepo(args []string) (*SearchRepoResults, error) {
+	c.searchRepoOpts.repoStore = c.env.repoStore()
 	c.searchRepoOpts.repoCacheDir = c.env.settings.RepositoryCache
 	return c.searchRepoOpts.run(args)
 }
@@ -176,14 +347,23 @@ func (o *searchRepoOptions) applyConstraint(res []*search.Result) ([]*search.Res
 
 func (o *searchRepoOptions) buildIndex() (*search.Index, error) {
 	// Load the repositories.yaml
-	rf, err := repo.LoadFile(o.repoFile)
-	if isNotExist(err) || len(rf.Repositories) == 0 {
+	rf, err := o.repoStore.Load()
+	if isNotExist(err) {
+		return nil, errors.New("no repositories configured")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rf.Repositories) == 0 {
 		return nil, errors.New("no repositories configured")
 	}
 
 	i := search.NewIndex()
 	for _, re := range rf.Repositories {
 		n := re.Name
+		if len(o.repos) > 0 && !containsString(o.repos, n) {
+			continue
+		}
 		f := filepath.Join(o.repoCacheDir, helmpath.CacheIndexFile(n))
 		ind, err := repo.LoadIndexFile(f)
 		if err != nil {
@@ -196,3 +376,136 @@ func (o *searchRepoOptions) buildIndex() (*search.Index, error) {
 	}
 	return i, nil
 }
+
+// searchRepoFilter filters search results by the metadata of their chart
+// version.
+type searchRepoFilter struct {
+	opts       *searchRepoOptions
+	appVersion *semver.Constraints
+}
+
+func (o *searchRepoOptions) newSearchRepoFilter() (*searchRepoFilter, error) {
+	filter := &searchRepoFilter{opts: o}
+	if o.appVersion != "" {
+		constraint, err := semver.NewConstraint(o.appVersion)
+		if err != nil {
+			return nil, errors.Wrap(err, "an invalid app version constraint format")
+		}
+		filter.appVersion = constraint
+	}
+	return filter, nil
+}
+
+func (f *searchRepoFilter) apply(res []*search.Result) []*search.Result {
+	data := res[:0]
+	for _, r := range res {
+		if len(f.opts.repos) > 0 && !containsString(f.opts.repos, searchRepoName(r.Name)) {
+			continue
+		}
+		if f.matches(r.Chart) {
+			data = append(data, r)
+		}
+	}
+	return data
+}
+
+func (f *searchRepoFilter) matches(cv *repo.ChartVersion) bool {
+	o := f.opts
+	if cv.Metadata == nil {
+		return false
+	}
+	for _, keyword := range o.keywords {
+		found := false
+		for _, k := range cv.Keywords {
+			if strings.EqualFold(k, keyword) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, annotation := range o.annotations {
+		value, ok := cv.Annotations[annotation.key]
+		if !ok || (annotation.value != "" && value != annotation.value) {
+			return false
+		}
+	}
+	if o.maintainer != "" {
+		found := false
+		for _, m := range cv.Maintainers {
+			if m != nil && (strings.EqualFold(m.Name, o.maintainer) || strings.EqualFold(m.Email, o.maintainer)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.appVersion != nil {
+		v, err := semver.NewVersion(cv.AppVersion)
+		if err != nil || !f.appVersion.Check(v) {
+			return false
+		}
+	}
+	if o.deprecated != nil && cv.Deprecated != *o.deprecated {
+		return false
+	}
+	return true
+}
+
+func newSearchRepoResult(r *search.Result) *SearchRepoResult {
+	cv := r.Chart
+	result := &SearchRepoResult{
+		Name:        r.Name,
+		Score:       r.Score,
+		Version:     cv.Version,
+		AppVersion:  cv.AppVersion,
+		Description: cv.Description,
+		Deprecated:  cv.Deprecated,
+		Created:     cv.Created,
+		Digest:      cv.Digest,
+		URLs:        cv.URLs,
+		Chart:       cv,
+	}
+	result.Repo = searchRepoName(r.Name)
+	return result
+}
+
+// searchRepoName is the repository of the repo/chart name of a result.
+func searchRepoName(name string) string {
+	if i := strings.Index(name, "/"); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// sortSearchRepoResults sorts results, which are sorted by score already.
+func sortSearchRepoResults(results []*SearchRepoResult, order SearchRepoSort) error {
+	switch order {
+	case SearchRepoSortScore:
+	case SearchRepoSortName:
+		// keep the versions of a chart sorted
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	case SearchRepoSortNewest:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Created.After(results[j].Created) })
+	default:
+		return errors.Errorf("unknown sort order %q", order)
+	}
+	return nil
+}
+
+func pageSearchRepoResults(results []*SearchRepoResult, offset int, limit int) []*SearchRepoResult {
+	if offset > len(results) {
+		offset = len(results)
+	}
+	if offset > 0 {
+		results = results[offset:]
+	}
+	if limit > 0 && limit < len(results) {
+		results = results[:limit]
+	}
+	return results
+}
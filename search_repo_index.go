@@ -0,0 +1,249 @@
+package helmclient
+
+import (
+	"helm.sh/helm/v3/cmd/helm/search"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	searchRepoIndexDefaultRefreshInterval = 5 * time.Second
+)
+
+type SearchRepoIndexOption struct {
+	f func(o *searchRepoIndexOptions)
+}
+
+type searchRepoIndexOptions struct {
+	refreshInterval time.Duration
+}
+
+func (o *searchRepoIndexOptions) apply(opts []SearchRepoIndexOption) {
+	for _, op := range opts {
+		op.f(o)
+	}
+}
+
+func newSearchRepoIndexOptions(opts []SearchRepoIndexOption) *searchRepoIndexOptions {
+	options := &searchRepoIndexOptions{
+		refreshInterval: searchRepoIndexDefaultRefreshInterval,
+	}
+	options.apply(opts)
+	return options
+}
+
+// SearchRepoIndexWithRefreshInterval sets how often the index checks the
+// repository configuration and the cached indexes for changes, zero to only
+// refresh it by calling Refresh.
+func SearchRepoIndexWithRefreshInterval(refreshInterval time.Duration) SearchRepoIndexOption {
+	return SearchRepoIndexOption{f: func(o *searchRepoIndexOptions) {
+		o.refreshInterval = refreshInterval
+	}}
+}
+
+// SearchRepoIndex is a search index of the configured repositories which is
+// kept in memory, for searches and completions which don't read the cached
+// indexes every time. It is refreshed in the background, parsing again only
+// the cached indexes which changed since the last refresh, so that a search
+// never waits for the indexes to be read.
+//
+// A SearchRepoIndex is safe for concurrent use. Close stops the refreshes.
+type SearchRepoIndex struct {
+	store    RepoStore
+	cacheDir string
+
+	// refreshMu serializes the refreshes, which own repos
+	refreshMu sync.Mutex
+	repos     map[string]*searchRepoIndexFile
+
+	mu       sync.RWMutex
+	index    *search.Index
+	charts   []string
+	versions map[string][]string
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// searchRepoIndexFile is the parsed cached index of a repository, kept as
+// long as the file doesn't change. It is nil for a repository whose index is
+// missing or corrupt.
+type searchRepoIndexFile struct {
+	size    int64
+	modTime time.Time
+	index   *repo.IndexFile
+}
+
+func newSearchRepoIndex(opts []SearchRepoIndexOption, env *helmEnv) (*SearchRepoIndex, error) {
+	o := newSearchRepoIndexOptions(opts)
+	i := &SearchRepoIndex{
+		store:    env.repoStore(),
+		cacheDir: env.settings.RepositoryCache,
+		repos:    map[string]*searchRepoIndexFile{},
+	}
+	if err := i.Refresh(); err != nil {
+		return nil, err
+	}
+	if o.refreshInterval > 0 {
+		i.stop = make(chan struct{})
+		i.done = make(chan struct{})
+		go i.refreshEvery(o.refreshInterval)
+	}
+	return i, nil
+}
+
+func (i *SearchRepoIndex) refreshEvery(interval time.Duration) {
+	defer close(i.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-i.stop:
+			return
+		case <-ticker.C:
+			if err := i.Refresh(); err != nil {
+				warning("failed to refresh the search index: %s", err)
+			}
+		}
+	}
+}
+
+// Close stops refreshing the index in the background. The index can still be
+// searched and refreshed by calling Refresh.
+func (i *SearchRepoIndex) Close() {
+	i.closeOnce.Do(func() {
+		if i.stop != nil {
+			close(i.stop)
+			<-i.done
+		}
+	})
+}
+
+// Refresh reads the repository configuration and parses the cached indexes
+// which changed since the last refresh. Repositories whose cached index is
+// missing or corrupt are left out of the index.
+func (i *SearchRepoIndex) Refresh() error {
+	i.refreshMu.Lock()
+	defer i.refreshMu.Unlock()
+
+	f, err := i.store.Load()
+	if isNotExist(err) {
+		f, err = repo.NewFile(), nil
+	}
+	if err != nil {
+		return err
+	}
+	changed := i.index == nil || len(f.Repositories) != len(i.repos)
+	repos := map[string]*searchRepoIndexFile{}
+	for _, entry := range f.Repositories {
+		name := entry.Name
+		old, known := i.repos[name]
+		path := filepath.Join(i.cacheDir, helmpath.CacheIndexFile(name))
+		info, err := os.Stat(path)
+		if err == nil && old != nil && old.size == info.Size() && old.modTime.Equal(info.ModTime()) {
+			repos[name] = old
+			continue
+		}
+		var index *repo.IndexFile
+		if err == nil {
+			index, err = repo.LoadIndexFile(path)
+		}
+		if err != nil {
+			// warn once, not at every refresh
+			if !known || old != nil {
+				warning("Repo %q is corrupt or missing. Try 'helm repo update'.", name)
+				warning("%s", err)
+				changed = true
+			}
+			repos[name] = nil
+			continue
+		}
+		repos[name] = &searchRepoIndexFile{size: info.Size(), modTime: info.ModTime(), index: index}
+		changed = true
+	}
+	for name := range i.repos {
+		if _, ok := repos[name]; !ok {
+			changed = true
+		}
+	}
+	i.repos = repos
+	if !changed {
+		return nil
+	}
+
+	names := make([]string, 0, len(repos))
+	for name := range repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	index := search.NewIndex()
+	var charts []string
+	versions := map[string][]string{}
+	for _, name := range names {
+		r := repos[name]
+		if r == nil {
+			continue
+		}
+		index.AddRepo(name, r.index, true)
+		for chart, cvs := range r.index.Entries {
+			if len(cvs) == 0 {
+				continue
+			}
+			fullName := name + "/" + chart
+			charts = append(charts, fullName)
+			for _, cv := range cvs {
+				versions[fullName] = append(versions[fullName], cv.Version)
+			}
+		}
+	}
+	sort.Strings(charts)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.index = index
+	i.charts = charts
+	i.versions = versions
+	return nil
+}
+
+// Search searches the index like SearchRepo, with the same options.
+func (i *SearchRepoIndex) Search(args []string, opts []SearchRepoOption) (*SearchRepoResults, error) {
+	o := newSearchRepoOptions(opts)
+	o.setupSearchedVersion()
+	i.mu.RLock()
+	index := i.index
+	i.mu.RUnlock()
+	return o.search(index, args)
+}
+
+// CompleteChart returns the repo/chart names starting with prefix, sorted.
+func (i *SearchRepoIndex) CompleteChart(prefix string) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var names []string
+	for n := sort.SearchStrings(i.charts, prefix); n < len(i.charts) && strings.HasPrefix(i.charts[n], prefix); n++ {
+		names = append(names, i.charts[n])
+	}
+	return names
+}
+
+// CompleteVersion returns the versions of the chart named repo/chart which
+// start with prefix, the highest first.
+func (i *SearchRepoIndex) CompleteVersion(chart string, prefix string) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var versions []string
+	for _, version := range i.versions[chart] {
+		if strings.HasPrefix(version, prefix) {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
@@ -3,6 +3,7 @@ package helmclient
 import (
 	"bytes"
 	"fmt"
+	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/cli/values"
@@ -24,25 +25,48 @@ const (
 	templateDefaultIsUpgrade      = false
 	templateDefaultKubeVersion    = ""
 	templateDefaultUseReleaseName = false
+	templateDefaultCapabilities   = ""
+	templateDefaultGoldenUpdate   = false
+	templateDefaultConcurrency    = 4
 )
 
 var (
 	templateDefaultShowFiles = []string{}
 	templateDefaultExtraAPIs = []string{}
+	// templateDefaultGoldenMasks mask what changes with every release of a
+	// chart without changing what it deploys
+	templateDefaultGoldenMasks = []string{
+		`metadata.labels["helm.sh/chart"]`,
+		`spec.template.metadata.labels["helm.sh/chart"]`,
+		`spec.template.metadata.annotations["checksum/*"]`,
+	}
 )
 
 type templateClient interface {
 	Template(args []string) error
+	TemplateChart(name string, source ChartSource) error
+	Golden(args []string, valuesDir string, goldenDir string) ([]*GoldenResult, error)
+	BulkRender(chart string, requests []BulkRenderRequest) ([]*BulkRenderResult, error)
+	BulkRenderChart(source ChartSource, requests []BulkRenderRequest) ([]*BulkRenderResult, error)
+	Images(args []string) ([]*ImageReference, error)
+	ImagesChart(name string, source ChartSource) ([]*ImageReference, error)
 }
 
 type templateClientImpl struct {
 	cli         *action.Install
+	cfg         *action.Configuration
 	env         *helmEnv
 	valueOpts   *valueOptions
 	showFiles   []string
 	skipTests   bool
 	kubeVersion string
 	extraAPIs   []string
+	// capabilities is the path of a capabilities snapshot
+	capabilities string
+	fakeCluster  *FakeCluster
+	goldenMasks  []string
+	goldenUpdate bool
+	concurrency  int
 }
 
 type TemplateOption struct {
@@ -59,6 +83,11 @@ type templateOptions struct {
 	kubeVersion    string
 	extraAPIs      []string
 	useReleaseName bool
+	capabilities   string
+	fakeCluster    *FakeCluster
+	goldenMasks    []string
+	goldenUpdate   bool
+	concurrency    int
 }
 
 func (o *templateOptions) apply(opts []TemplateOption) {
@@ -78,6 +107,11 @@ func newTemplateOptions(opts []TemplateOption) *templateOptions {
 		kubeVersion:    templateDefaultKubeVersion,
 		extraAPIs:      templateDefaultExtraAPIs,
 		useReleaseName: templateDefaultUseReleaseName,
+		capabilities:   templateDefaultCapabilities,
+		fakeCluster:    nil,
+		goldenMasks:    templateDefaultGoldenMasks,
+		goldenUpdate:   templateDefaultGoldenUpdate,
+		concurrency:    templateDefaultConcurrency,
 	}
 	options.apply(opts)
 	return options
@@ -131,6 +165,51 @@ func TemplateWithExtraAPIs(extraAPIs []string) TemplateOption {
 	}}
 }
 
+// TemplateWithCapabilitiesFile renders as if connected to the cluster captured
+// in a capabilities snapshot. TemplateWithKubeVersion and TemplateWithExtraAPIs
+// still apply on top of it. No cluster is reached, so it can't be combined with
+// TemplateWithValidate(true).
+func TemplateWithCapabilitiesFile(path string) TemplateOption {
+	return TemplateOption{f: func(o *templateOptions) {
+		o.capabilities = path
+	}}
+}
+
+// TemplateWithFakeCluster answers the lookup function from a fake cluster
+// state instead of returning empty results. The fake cluster can't validate
+// the manifests, it can't be combined with TemplateWithValidate(true).
+func TemplateWithFakeCluster(fakeCluster *FakeCluster) TemplateOption {
+	return TemplateOption{f: func(o *templateOptions) {
+		o.fakeCluster = fakeCluster
+	}}
+}
+
+// TemplateWithGoldenMasks sets the paths of the fields Golden masks because
+// their values change from render to render, such as random passwords. Paths
+// use the syntax of the unit test assertions, keys may be globs and [*]
+// matches every item of a list.
+func TemplateWithGoldenMasks(masks []string) TemplateOption {
+	return TemplateOption{f: func(o *templateOptions) {
+		o.goldenMasks = masks
+	}}
+}
+
+// TemplateWithGoldenUpdate makes Golden rewrite the golden files with the
+// rendered output instead of comparing against them.
+func TemplateWithGoldenUpdate(update bool) TemplateOption {
+	return TemplateOption{f: func(o *templateOptions) {
+		o.goldenUpdate = update
+	}}
+}
+
+// TemplateWithConcurrency limits how many releases BulkRender renders at the
+// same time.
+func TemplateWithConcurrency(concurrency int) TemplateOption {
+	return TemplateOption{f: func(o *templateOptions) {
+		o.concurrency = concurrency
+	}}
+}
+
 func TemplateWithUseReleaseName(useReleaseName bool) TemplateOption {
 	return TemplateOption{f: func(o *templateOptions) {
 		o.useReleaseName = useReleaseName
@@ -143,7 +222,7 @@ func (c *templateClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error
 }
 
 func (c *templateClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
@@ -151,12 +230,22 @@ func (c *templateClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []Global
 	// copy args
 	copyInstallClientOptions(c.cli, client)
 	c.cli = client
+	c.cfg = cfg
 	c.env = env
 	return nil
 }
 
 func newTemplateClient(opts []TemplateOption, valueOpts []ValueOption, env *helmEnv) (*templateClientImpl, error) {
+	if err := validateTemplateFuncs(env.templateFuncs()); err != nil {
+		return nil, err
+	}
 	o := newTemplateOptions(opts)
+	if o.fakeCluster != nil && o.validate {
+		return nil, errors.New("a fake cluster can't be used to validate the manifests")
+	}
+	if o.capabilities != "" && o.validate {
+		return nil, errors.New("a capabilities snapshot can't be used to validate the manifests")
+	}
 	cfg := new(action.Configuration)
 	err := cfg.Init(env.clientGetter, env.Namespace(), "", debug)
 	if err != nil {
@@ -173,20 +262,55 @@ func newTemplateClient(opts []TemplateOption, valueOpts []ValueOption, env *helm
 	addValueOptions(valueOpts, v)
 
 	return &templateClientImpl{
-		cli:         client,
-		env:         env,
-		valueOpts:   v,
-		showFiles:   o.showFiles,
-		skipTests:   o.skipTests,
-		kubeVersion: o.kubeVersion,
-		extraAPIs:   o.extraAPIs,
+		cli:          client,
+		cfg:          cfg,
+		env:          env,
+		valueOpts:    v,
+		showFiles:    o.showFiles,
+		skipTests:    o.skipTests,
+		kubeVersion:  o.kubeVersion,
+		extraAPIs:    o.extraAPIs,
+		capabilities: o.capabilities,
+		fakeCluster:  o.fakeCluster,
+		goldenMasks:  o.goldenMasks,
+		goldenUpdate: o.goldenUpdate,
+		concurrency:  o.concurrency,
 	}, nil
 }
 
 func (c *templateClientImpl) Template(args []string) error {
+	rel, err := c.renderArgs(args, c.valueOpts)
+	return c.output(rel, err)
+}
+
+// TemplateChart renders a chart which is not located through the chart path
+// options. An empty name renders with the default release name.
+func (c *templateClientImpl) TemplateChart(name string, source ChartSource) error {
+	rel, err := c.render(name, source, c.valueOpts)
+	return c.output(rel, err)
+}
+
+// render renders a chart source like TemplateChart without printing it.
+func (c *templateClientImpl) render(name string, source ChartSource, valueOpts *valueOptions) (*release.Release, error) {
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+	return runInstallChart(name, source, c.cli, c.cfg, (*values.Options)(valueOpts), c.env)
+}
+
+// renderArgs renders the chart located by args like Template without
+// printing it.
+func (c *templateClientImpl) renderArgs(args []string, valueOpts *valueOptions) (*release.Release, error) {
 	if len(args) < 1 {
-		return fmt.Errorf("template requires at least 1 argument")
+		return nil, fmt.Errorf("template requires at least 1 argument")
+	}
+	if err := c.prepare(); err != nil {
+		return nil, err
 	}
+	return runInstall(args, c.cli, c.cfg, (*values.Options)(valueOpts), os.Stdout, c.env)
+}
+
+func (c *templateClientImpl) prepare() error {
 	if c.kubeVersion != "" {
 		parsedKubeVersion, err := chartutil.ParseKubeVersion(c.kubeVersion)
 		if err != nil {
@@ -198,8 +322,29 @@ func (c *templateClientImpl) Template(args []string) error {
 	c.cli.ReleaseName = "RELEASE-NAME"
 	c.cli.Replace = true // Skip the name check
 	c.cli.APIVersions = chartutil.VersionSet(c.extraAPIs)
-	rel, err := runInstall(args, c.cli, (*values.Options)(c.valueOpts), os.Stdout, c.env)
 
+	caps, err := loadCapabilities(c.capabilities, c.cli.KubeVersion, c.extraAPIs)
+	if err != nil {
+		return err
+	}
+	if caps != nil {
+		// client only mode always renders with the default capabilities, leave
+		// it and stand in for the cluster instead
+		c.cli.ClientOnly = false
+		c.cli.APIVersions = nil
+		useOfflineCapabilities(c.cfg, caps, c.env.Namespace())
+	} else if c.fakeCluster != nil {
+		// client only mode provides the fake clients the fake cluster needs,
+		// validation is ruled out by newTemplateClient
+		c.cli.ClientOnly = true
+	}
+	if c.fakeCluster != nil {
+		useFakeCluster(c.cli, c.cfg, c.fakeCluster)
+	}
+	return nil
+}
+
+func (c *templateClientImpl) output(rel *release.Release, err error) error {
 	if err != nil && !c.env.settings.Debug {
 		if rel != nil {
 			return fmt.Errorf("%w\n\nUse --debug flag to render out invalid YAML", err)
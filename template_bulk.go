@@ -0,0 +1,198 @@
+package helmclient
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"os"
+	"strings"
+	"sync"
+)
+
+// BulkRenderRequest is one release rendered by BulkRender.
+type BulkRenderRequest struct {
+	// ReleaseName defaults to the release name Template renders with.
+	ReleaseName string
+	// Namespace defaults to the namespace of the client.
+	Namespace string
+	// ValueOpts are applied on top of the value options of the client.
+	ValueOpts []ValueOption
+}
+
+// BulkRenderResult is the outcome of the BulkRenderRequest at the same index.
+type BulkRenderResult struct {
+	Request BulkRenderRequest
+	Release *release.Release
+	// Manifest is the output Template prints for the release.
+	Manifest string
+	// Err is set when the release could not be rendered.
+	Err error
+}
+
+// BulkRender renders the chart located by chart, like the CHART argument of
+// Template, once for every request. The chart is loaded once, then the
+// requests are rendered concurrently, up to the limit given by
+// TemplateWithConcurrency, each by its own install action. The results are in
+// the order of the requests. If any request fails, the results are returned
+// together with a summary error.
+func (c *templateClientImpl) BulkRender(chart string, requests []BulkRenderRequest) ([]*BulkRenderResult, error) {
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+	debug("Original chart version: %q", c.cli.Version)
+	if c.cli.Version == "" && c.cli.Devel {
+		debug("setting version to >0.0.0-0")
+		c.cli.Version = ">0.0.0-0"
+	}
+	chartPathOpts, err := chartPathCredentials(c.cli.ChartPathOptions, chart, c.env)
+	if err != nil {
+		return nil, err
+	}
+	settings, cleanup, err := c.env.chartRepoSettings(chartPathOpts, chart)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	cp, err := chartPathOpts.LocateChart(chart, settings)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := loadInstallChart(cp, c.cli, getter.All(c.env.settings), os.Stdout, c.env)
+	if err != nil {
+		return nil, err
+	}
+	return c.bulkRender(ch, requests)
+}
+
+// BulkRenderChart renders a chart which is not located through the chart path
+// options like BulkRender.
+func (c *templateClientImpl) BulkRenderChart(source ChartSource, requests []BulkRenderRequest) ([]*BulkRenderResult, error) {
+	if err := c.prepare(); err != nil {
+		return nil, err
+	}
+	ch, err := source.Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChartRequested(ch); err != nil {
+		return nil, err
+	}
+	return c.bulkRender(ch, requests)
+}
+
+func (c *templateClientImpl) bulkRender(ch *chart.Chart, requests []BulkRenderRequest) ([]*BulkRenderResult, error) {
+	if len(requests) == 0 {
+		return nil, errors.New("no releases to render")
+	}
+	if !c.cli.ClientOnly {
+		// look the capabilities up once instead of for every release
+		if _, err := installCapabilities(c.cli, c.cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	concurrency := c.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	results := make([]*BulkRenderResult, len(requests))
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		results[i] = &BulkRenderResult{Request: request}
+		wg.Add(1)
+		go func(result *BulkRenderResult) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			rel, err := c.bulkRenderOne(ch, result.Request)
+			result.Release = rel
+			result.Err = err
+			if rel != nil && err == nil {
+				result.Manifest = c.manifest(rel)
+			}
+		}(results[i])
+	}
+	wg.Wait()
+	return results, bulkRenderSummary(results)
+}
+
+// bulkRenderOne renders one request with its own copy of the action and of
+// the chart, which the action changes while processing the dependencies.
+func (c *templateClientImpl) bulkRenderOne(ch *chart.Chart, request BulkRenderRequest) (*release.Release, error) {
+	cfg := forkConfiguration(c.cfg, c.env.Namespace())
+	client := action.NewInstall(cfg)
+	copyTemplateClientOptions(c.cli, client)
+	if request.ReleaseName != "" {
+		client.ReleaseName = request.ReleaseName
+	}
+	client.Namespace = request.Namespace
+	if client.Namespace == "" {
+		client.Namespace = c.env.settings.Namespace()
+	}
+	vals, err := c.valueOpts.with(request.ValueOpts).mergeValues(getter.All(c.env.settings), c.env.valuesDecryptors())
+	if err != nil {
+		return nil, err
+	}
+	return runInstallAction(client, cfg, copyChart(ch), vals, c.env)
+}
+
+// manifest is the output of Template for rel, without writing it anywhere.
+func (c *templateClientImpl) manifest(rel *release.Release) string {
+	var manifests bytes.Buffer
+	fmt.Fprintln(&manifests, strings.TrimSpace(rel.Manifest))
+	if !c.cli.DisableHooks {
+		for _, m := range rel.Hooks {
+			if c.skipTests && isTestHook(m) {
+				continue
+			}
+			fmt.Fprintf(&manifests, "---\n# Source: %s\n%s\n", m.Path, m.Manifest)
+		}
+	}
+	return manifests.String()
+}
+
+// forkConfiguration copies cfg for a concurrent render. A release storage in
+// memory is replaced by an empty one in namespace, so that the renders don't
+// see each other's releases.
+func forkConfiguration(cfg *action.Configuration, namespace string) *action.Configuration {
+	fork := *cfg
+	if cfg.Releases != nil && cfg.Releases.Name() == driver.MemoryDriverName {
+		mem := driver.NewMemory()
+		mem.SetNamespace(namespace)
+		fork.Releases = storage.Init(mem)
+	}
+	return &fork
+}
+
+// copyTemplateClientOptions copies everything Template renders with.
+func copyTemplateClientOptions(oldCli *action.Install, newCli *action.Install) {
+	copyInstallClientOptions(oldCli, newCli)
+	newCli.ClientOnly = oldCli.ClientOnly
+	newCli.KubeVersion = oldCli.KubeVersion
+	newCli.APIVersions = oldCli.APIVersions
+	newCli.IsUpgrade = oldCli.IsUpgrade
+	newCli.IncludeCRDs = oldCli.IncludeCRDs
+	newCli.UseReleaseName = oldCli.UseReleaseName
+	newCli.OutputDir = oldCli.OutputDir
+	newCli.ReleaseName = oldCli.ReleaseName
+}
+
+func bulkRenderSummary(results []*BulkRenderResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d release(s) rendered, %d failed", len(results), failed)
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package helmclient
+
+import (
+	"github.com/outgnaY/helm-go-client/internal/engine"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+var (
+	templateFuncNameRegex = regexp.MustCompile(`^[\p{L}_][\p{L}\p{Nd}_]*$`)
+	errorType             = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// validateTemplateFuncs rejects custom template functions which would shadow
+// a function of text/template, sprig or Helm, or which text/template can't
+// call.
+func validateTemplateFuncs(funcs template.FuncMap) error {
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if engine.IsBuiltinFunc(name) {
+			return errors.Errorf("template function %q conflicts with the built-in function of the same name", name)
+		}
+		if !templateFuncNameRegex.MatchString(name) {
+			return errors.Errorf("template function name %q is not a valid identifier", name)
+		}
+		t := reflect.TypeOf(funcs[name])
+		if t == nil || t.Kind() != reflect.Func {
+			return errors.Errorf("template function %q is not a function", name)
+		}
+		if !(t.NumOut() == 1 || (t.NumOut() == 2 && t.Out(1) == errorType)) {
+			return errors.Errorf("template function %q must return a value, optionally followed by an error", name)
+		}
+	}
+	return nil
+}
+
+// Helm renders with a fixed set of functions. When custom template functions
+// are registered, the chart is rendered ahead of the action with a copy of the
+// engine of Helm that knows them, with the same values, release options and
+// capabilities the action would use. The action then renders a copy of the
+// chart whose templates are the output of that render, which gives the same
+// result and leaves the rest of the action as it is.
+
+// prerendered is a chart rendered ahead of an action.
+type prerendered struct {
+	// chart is the chart with its own templates, after the dependencies were
+	// processed, which is what the action would store in the release
+	chart *chart.Chart
+	// static is the chart the action renders
+	static *chart.Chart
+}
+
+// runInstallAction runs client, pre-rendering the chart when custom template
+// functions are registered.
+func runInstallAction(client *action.Install, cfg *action.Configuration, ch *chart.Chart, vals map[string]interface{}, env *helmEnv) (*release.Release, error) {
+	funcs := env.templateFuncs()
+	if len(funcs) == 0 {
+		return client.Run(ch, vals)
+	}
+	caps, err := installCapabilities(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	isUpgrade := client.IsUpgrade && client.DryRun
+	options := chartutil.ReleaseOptions{
+		Name:      client.ReleaseName,
+		Namespace: client.Namespace,
+		Revision:  1,
+		IsInstall: !isUpgrade,
+		IsUpgrade: isUpgrade,
+	}
+	e, err := prerenderEngine(cfg, funcs, client.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	p, err := prerenderChart(ch, vals, options, caps, e)
+	if err != nil {
+		return nil, err
+	}
+	return p.run(cfg, func(static *chart.Chart) (*release.Release, error) {
+		return client.Run(static, vals)
+	})
+}
+
+// runUpgradeAction runs client like runInstallAction does.
+func runUpgradeAction(client *action.Upgrade, cfg *action.Configuration, name string, ch *chart.Chart, vals map[string]interface{}, env *helmEnv) (*release.Release, error) {
+	funcs := env.templateFuncs()
+	if len(funcs) == 0 {
+		return client.Run(name, ch, vals)
+	}
+	if err := cfg.KubeClient.IsReachable(); err != nil {
+		return nil, err
+	}
+	last, current := upgradeReleases(cfg, name)
+	if current == nil {
+		// the action fails before rendering, let it report why
+		return client.Run(name, ch, vals)
+	}
+	caps, err := configCapabilities(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &prerendered{chart: copyChart(ch)}
+	renderVals, err := reuseValues(client, p.chart, current, vals)
+	if err != nil {
+		return nil, err
+	}
+	options := chartutil.ReleaseOptions{
+		Name:      name,
+		Namespace: current.Namespace,
+		Revision:  last.Version + 1,
+		IsUpgrade: true,
+	}
+	e, err := prerenderEngine(cfg, funcs, client.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.render(renderVals, options, caps, e); err != nil {
+		return nil, err
+	}
+	return p.run(cfg, func(static *chart.Chart) (*release.Release, error) {
+		return client.Run(name, static, vals)
+	})
+}
+
+// prerenderLintChart renders the chart for lint with the custom template
+// functions, the way the template rules of Helm do.
+func prerenderLintChart(ch *chart.Chart, vals map[string]interface{}, namespace string, caps *chartutil.Capabilities, funcs template.FuncMap) (*chart.Chart, error) {
+	cvals, err := chartutil.CoalesceValues(ch, vals)
+	if err != nil {
+		return nil, err
+	}
+	options := chartutil.ReleaseOptions{Name: "test-release", Namespace: namespace}
+	p := &prerendered{chart: copyChart(ch)}
+	// lint renders the subcharts whether they are enabled or not
+	if err := p.renderProcessed(cvals, options, caps, engine.Engine{LintMode: true, CustomTemplateFuncs: funcs}); err != nil {
+		return nil, err
+	}
+	return p.static, nil
+}
+
+func prerenderChart(ch *chart.Chart, vals map[string]interface{}, options chartutil.ReleaseOptions, caps *chartutil.Capabilities, e engine.Engine) (*prerendered, error) {
+	p := &prerendered{chart: copyChart(ch)}
+	if err := p.render(vals, options, caps, e); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *prerendered) render(vals map[string]interface{}, options chartutil.ReleaseOptions, caps *chartutil.Capabilities, e engine.Engine) error {
+	if err := chartutil.ProcessDependencies(p.chart, vals); err != nil {
+		return err
+	}
+	return p.renderProcessed(vals, options, caps, e)
+}
+
+func (p *prerendered) renderProcessed(vals map[string]interface{}, options chartutil.ReleaseOptions, caps *chartutil.Capabilities, e engine.Engine) error {
+	valuesToRender, err := chartutil.ToRenderValues(p.chart, vals, options, caps)
+	if err != nil {
+		return err
+	}
+	files, err := e.Render(p.chart, valuesToRender)
+	if err != nil {
+		return err
+	}
+	p.static = staticChart(p.chart, files)
+	return nil
+}
+
+// run runs an action on the static chart. The release it returns, and every
+// revision it stores, failed ones included, hold the chart with its own
+// templates instead.
+func (p *prerendered) run(cfg *action.Configuration, runAction func(static *chart.Chart) (*release.Release, error)) (*release.Release, error) {
+	if releases := cfg.Releases; releases != nil {
+		stored := *releases
+		stored.Driver = &prerenderedDriver{Driver: releases.Driver, p: p}
+		cfg.Releases = &stored
+		defer func() { cfg.Releases = releases }()
+	}
+	rel, err := runAction(p.static)
+	if rel != nil {
+		rel.Chart = p.chart
+	}
+	return rel, err
+}
+
+// prerenderedDriver swaps the chart with its own templates in for the static
+// chart before a release is written, so that each revision is written once.
+type prerenderedDriver struct {
+	driver.Driver
+	p *prerendered
+}
+
+func (d *prerenderedDriver) Create(key string, rls *release.Release) error {
+	d.restore(rls)
+	return d.Driver.Create(key, rls)
+}
+
+func (d *prerenderedDriver) Update(key string, rls *release.Release) error {
+	d.restore(rls)
+	return d.Driver.Update(key, rls)
+}
+
+func (d *prerenderedDriver) restore(rls *release.Release) {
+	if rls != nil && rls.Chart == d.p.static {
+		rls.Chart = d.p.chart
+	}
+}
+
+func prerenderEngine(cfg *action.Configuration, funcs template.FuncMap, dryRun bool) (engine.Engine, error) {
+	// lookup only reaches the cluster outside of dry runs, as with Helm
+	var config *rest.Config
+	if !dryRun {
+		var err error
+		if config, err = cfg.RESTClientGetter.ToRESTConfig(); err != nil {
+			return engine.Engine{}, err
+		}
+	}
+	e := engine.New(config)
+	e.CustomTemplateFuncs = funcs
+	return e, nil
+}
+
+// copyChart copies the tree of charts, so that processing the dependencies
+// does not change ch. It also sets the enabled flags and import values of the
+// dependencies in the metadata.
+func copyChart(ch *chart.Chart) *chart.Chart {
+	metadata := ch.Metadata
+	if metadata != nil && len(metadata.Dependencies) > 0 {
+		md := *metadata
+		md.Dependencies = make([]*chart.Dependency, len(metadata.Dependencies))
+		for i, dep := range metadata.Dependencies {
+			d := *dep
+			md.Dependencies[i] = &d
+		}
+		metadata = &md
+	}
+	out := &chart.Chart{
+		Raw:       ch.Raw,
+		Metadata:  metadata,
+		Lock:      ch.Lock,
+		Templates: ch.Templates,
+		Values:    ch.Values,
+		Schema:    ch.Schema,
+		Files:     ch.Files,
+	}
+	for _, dep := range ch.Dependencies() {
+		out.AddDependency(copyChart(dep))
+	}
+	return out
+}
+
+// staticChart copies ch with the templates replaced by their rendered output.
+// Partials and the templates of library charts render to nothing.
+func staticChart(ch *chart.Chart, files map[string]string) *chart.Chart {
+	out := copyChart(ch)
+	out.SetDependencies()
+	out.Templates = make([]*chart.File, 0, len(ch.Templates))
+	for _, t := range ch.Templates {
+		content := files[path.Join(ch.ChartFullPath(), t.Name)]
+		out.Templates = append(out.Templates, &chart.File{Name: t.Name, Data: []byte(escapeTemplate(content))})
+	}
+	for _, dep := range ch.Dependencies() {
+		out.AddDependency(staticChart(dep, files))
+	}
+	return out
+}
+
+// escapeTemplate turns text into a template which renders to the text.
+func escapeTemplate(text string) string {
+	return strings.ReplaceAll(text, "{{", `{{"{{"}}`)
+}
+
+// installCapabilities are the capabilities install renders with.
+func installCapabilities(client *action.Install, cfg *action.Configuration) (*chartutil.Capabilities, error) {
+	if client.ClientOnly {
+		caps := chartutil.DefaultCapabilities.Copy()
+		if client.KubeVersion != nil {
+			caps.KubeVersion = *client.KubeVersion
+		}
+		caps.APIVersions = append(caps.APIVersions, client.APIVersions...)
+		return caps, nil
+	}
+	if err := cfg.KubeClient.IsReachable(); err != nil {
+		return nil, err
+	}
+	return configCapabilities(cfg)
+}
+
+// configCapabilities follows the capabilities of action.Configuration and
+// keeps them in cfg, so that the action finds the same ones.
+func configCapabilities(cfg *action.Configuration) (*chartutil.Capabilities, error) {
+	if cfg.Capabilities != nil {
+		return cfg.Capabilities, nil
+	}
+	dc, err := cfg.RESTClientGetter.ToDiscoveryClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get Kubernetes discovery client")
+	}
+	dc.Invalidate()
+	kubeVersion, err := dc.ServerVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get server version from Kubernetes")
+	}
+	apiVersions, err := action.GetVersionSet(dc)
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, errors.Wrap(err, "could not get apiVersions from Kubernetes")
+		}
+		warning("The Kubernetes server has an orphaned API service. Server reports: %s", err)
+	}
+	cfg.Capabilities = &chartutil.Capabilities{
+		APIVersions: apiVersions,
+		KubeVersion: chartutil.KubeVersion{
+			Version: kubeVersion.GitVersion,
+			Major:   kubeVersion.Major,
+			Minor:   kubeVersion.Minor,
+		},
+	}
+	return cfg.Capabilities, nil
+}
+
+// upgradeReleases finds the last release and the release upgrade starts from,
+// the same way upgrade does. current is nil if upgrade would fail.
+func upgradeReleases(cfg *action.Configuration, name string) (last *release.Release, current *release.Release) {
+	last, err := cfg.Releases.Last(name)
+	if err != nil || last.Info.Status.IsPending() {
+		return nil, nil
+	}
+	if last.Info.Status == release.StatusDeployed {
+		return last, last
+	}
+	current, err = cfg.Releases.Deployed(name)
+	if err != nil {
+		if errors.Is(err, driver.ErrNoDeployedReleases) &&
+			(last.Info.Status == release.StatusFailed || last.Info.Status == release.StatusSuperseded) {
+			return last, last
+		}
+		return nil, nil
+	}
+	return last, current
+}
+
+// reuseValues follows the values upgrade renders with. Like upgrade, it
+// replaces the values of ch when reusing values.
+func reuseValues(client *action.Upgrade, ch *chart.Chart, current *release.Release, vals map[string]interface{}) (map[string]interface{}, error) {
+	if client.ResetValues {
+		return vals, nil
+	}
+	if client.ReuseValues {
+		oldVals, err := chartutil.CoalesceValues(current.Chart, current.Config)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to rebuild old values")
+		}
+		vals = chartutil.CoalesceTables(vals, current.Config)
+		ch.Values = oldVals
+		return vals, nil
+	}
+	if len(vals) == 0 && len(current.Config) > 0 {
+		return current.Config, nil
+	}
+	return vals, nil
+}
@@ -0,0 +1,193 @@
+package helmclient
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+const (
+	goldenFileSuffix = ".golden.yaml"
+	goldenMaskValue  = "MASKED"
+)
+
+// GoldenResult is the comparison of the output for one values file with its
+// golden file.
+type GoldenResult struct {
+	ValuesFile string
+	GoldenFile string
+	// Err is set when the chart could not be rendered with the values file.
+	Err error
+	// Diff is a unified diff from the golden file to the rendered output.
+	Diff string
+	// Updated is set when the golden file was written in update mode.
+	Updated bool
+	Failed  bool
+}
+
+// Golden renders the chart located by args once for every .yaml or .yml file
+// in valuesDir, applied on top of the value options of the client, and
+// compares the normalized output with the file of the same name in goldenDir
+// with the extension replaced by .golden.yaml. Documents are ordered the way
+// Helm installs them, their keys are sorted and the fields given by
+// TemplateWithGoldenMasks are masked, so that only changes of what the chart
+// deploys show up. A missing golden file fails unless TemplateWithGoldenUpdate
+// is set. If any output differs, the results are returned together with a
+// summary error.
+func (c *templateClientImpl) Golden(args []string, valuesDir string, goldenDir string) ([]*GoldenResult, error) {
+	entries, err := ioutil.ReadDir(valuesDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, entry.Name())
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("no values files found in %s", valuesDir)
+	}
+	sort.Strings(files)
+	masks := make([][]interface{}, 0, len(c.goldenMasks))
+	for _, mask := range c.goldenMasks {
+		segments, err := parseUnitTestPath(mask)
+		if err != nil {
+			return nil, err
+		}
+		masks = append(masks, segments)
+	}
+
+	results := make([]*GoldenResult, 0, len(files))
+	for _, file := range files {
+		result := &GoldenResult{
+			ValuesFile: filepath.Join(valuesDir, file),
+			GoldenFile: filepath.Join(goldenDir, strings.TrimSuffix(file, filepath.Ext(file))+goldenFileSuffix),
+		}
+		results = append(results, result)
+		if err := c.golden(args, masks, result); err != nil {
+			result.Err = err
+			result.Failed = true
+		}
+	}
+	return results, goldenSummary(results)
+}
+
+func (c *templateClientImpl) golden(args []string, masks [][]interface{}, result *GoldenResult) error {
+	v := c.valueOpts.with(nil)
+	v.ValueFiles = append(v.ValueFiles, result.ValuesFile)
+	rel, err := c.renderArgs(args, v)
+	if err != nil {
+		return err
+	}
+	docs, err := renderedDocuments(rel)
+	if err != nil {
+		return err
+	}
+	output, err := normalizeDocuments(docs, masks)
+	if err != nil {
+		return err
+	}
+
+	golden, err := ioutil.ReadFile(result.GoldenFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if string(golden) == output {
+		return nil
+	}
+	if c.goldenUpdate {
+		if err := os.MkdirAll(filepath.Dir(result.GoldenFile), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(result.GoldenFile, []byte(output), 0644); err != nil {
+			return err
+		}
+		result.Updated = true
+		return nil
+	}
+	result.Diff = unifiedDiff(string(golden), output)
+	result.Failed = true
+	return nil
+}
+
+// normalizeDocuments prints documents with sorted keys and masked fields.
+func normalizeDocuments(docs []*renderedDocument, masks [][]interface{}) (string, error) {
+	var b strings.Builder
+	for i, doc := range docs {
+		for _, mask := range masks {
+			maskPath(doc.Object, mask)
+		}
+		// sigs.k8s.io/yaml goes through JSON, which sorts the keys
+		data, err := yaml.Marshal(doc.Object)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to print document rendered by %s", doc.Template)
+		}
+		if i > 0 {
+			b.WriteString("---\n")
+		}
+		fmt.Fprintf(&b, "# Source: %s\n%s", doc.Template, data)
+	}
+	return b.String(), nil
+}
+
+// maskPath replaces the values at a mask path. Keys are matched as globs and
+// anyIndex matches every item of a list.
+func maskPath(current interface{}, segments []interface{}) {
+	if len(segments) == 0 {
+		return
+	}
+	last := len(segments) == 1
+	switch s := segments[0].(type) {
+	case string:
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, value := range m {
+			if matched, _ := path.Match(s, key); !matched {
+				continue
+			}
+			if last {
+				m[key] = goldenMaskValue
+			} else {
+				maskPath(value, segments[1:])
+			}
+		}
+	case int:
+		l, ok := current.([]interface{})
+		if !ok {
+			return
+		}
+		for i := range l {
+			if s != anyIndex && s != i {
+				continue
+			}
+			if last {
+				l[i] = goldenMaskValue
+			} else {
+				maskPath(l[i], segments[1:])
+			}
+		}
+	}
+}
+
+func goldenSummary(results []*GoldenResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.Failed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d values file(s) rendered, %d differ from their golden files", len(results), failed)
+	}
+	return nil
+}
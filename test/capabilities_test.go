@@ -0,0 +1,129 @@
+package test
+
+import (
+	"fmt"
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const capabilitiesForTest = `kubeVersion:
+  version: v1.25.3
+  major: "1"
+  minor: "25"
+apiVersions:
+- v1
+- apps/v1
+- networking.k8s.io/v1
+- networking.k8s.io/v1/Ingress
+- monitoring.coreos.com/v1
+- monitoring.coreos.com/v1/ServiceMonitor
+`
+
+const capabilitiesTemplateForTest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+data:
+  kubeVersion: {{ .Capabilities.KubeVersion.Version }}
+  serviceMonitor: {{ .Capabilities.APIVersions.Has "monitoring.coreos.com/v1/ServiceMonitor" | quote }}
+`
+
+func writeCapabilitiesForTest(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "capabilities")
+	assert.NilError(t, err)
+	path := filepath.Join(dir, "capabilities.yaml")
+	assert.NilError(t, ioutil.WriteFile(path, []byte(capabilitiesForTest), 0644))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestCapabilities(t *testing.T) {
+	t.Run("capture", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		capabilitiesCli, err := cli.Capabilities()
+		assert.Equal(t, err, nil)
+		snapshot, err := capabilitiesCli.Capture()
+		fmt.Println(snapshot, err)
+	})
+	t.Run("load", func(t *testing.T) {
+		path, cleanup := writeCapabilitiesForTest(t)
+		defer cleanup()
+		snapshot, err := helmclient.LoadCapabilitiesSnapshot(path)
+		assert.NilError(t, err)
+		assert.Equal(t, snapshot.KubeVersion.Version, "v1.25.3")
+		assert.Equal(t, len(snapshot.APIVersions), 6)
+	})
+	t.Run("dry run install", func(t *testing.T) {
+		path, cleanup := writeCapabilitiesForTest(t)
+		defer cleanup()
+		ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+			helmclient.BuildChartWithTemplate("configmap.yaml", []byte(capabilitiesTemplateForTest)),
+		})
+		assert.NilError(t, err)
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		installCli, err := cli.Install([]helmclient.InstallOption{
+			helmclient.InstallWithDryRun(true),
+			helmclient.InstallWithCapabilitiesFile(path),
+		}, []helmclient.ValueOption{}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		rel, err := installCli.InstallChart("hello", helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(rel.Manifest, "kubeVersion: v1.25.3"))
+		assert.Assert(t, strings.Contains(rel.Manifest, `serviceMonitor: "true"`))
+	})
+	t.Run("install requires dry run", func(t *testing.T) {
+		path, cleanup := writeCapabilitiesForTest(t)
+		defer cleanup()
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		installCli, err := cli.Install([]helmclient.InstallOption{
+			helmclient.InstallWithCapabilitiesFile(path),
+		}, []helmclient.ValueOption{}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		_, err = installCli.Install([]string{"hello", "CHART"})
+		assert.ErrorContains(t, err, "dry run")
+	})
+	t.Run("template", func(t *testing.T) {
+		path, cleanup := writeCapabilitiesForTest(t)
+		defer cleanup()
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		templateCli, err := cli.Template([]helmclient.TemplateOption{
+			helmclient.TemplateWithCapabilitiesFile(path),
+		}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		err = templateCli.TemplateChart("hello", helmclient.ChartFromFS(chartFSForTest))
+		assert.NilError(t, err)
+	})
+	t.Run("template can't validate", func(t *testing.T) {
+		path, cleanup := writeCapabilitiesForTest(t)
+		defer cleanup()
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		_, err := cli.Template([]helmclient.TemplateOption{
+			helmclient.TemplateWithCapabilitiesFile(path),
+			helmclient.TemplateWithValidate(true),
+		}, []helmclient.ValueOption{})
+		assert.ErrorContains(t, err, "can't be used to validate")
+	})
+	t.Run("lint", func(t *testing.T) {
+		path, cleanup := writeCapabilitiesForTest(t)
+		defer cleanup()
+		ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+			helmclient.BuildChartWithIcon("https://example.com/icon.png"),
+			helmclient.BuildChartWithTemplate("ingress.yaml", []byte(ingressForLintTest)),
+		})
+		assert.NilError(t, err)
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint([]helmclient.LintOption{
+			helmclient.LintWithCapabilitiesFile(path),
+		}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		result, err := lintCli.LintChart(helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+		for _, msg := range result.Messages {
+			assert.Assert(t, msg.Rule != helmclient.LintRuleDeprecatedAPIs, msg.String())
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package test
+
+import (
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"testing"
+)
+
+func TestBuildChart(t *testing.T) {
+	t.Run("build chart", func(t *testing.T) {
+		ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+			helmclient.BuildChartWithAppVersion("1.0.0"),
+			helmclient.BuildChartWithMaintainers([]*chart.Maintainer{{Name: "platform", Email: "platform@example.com"}}),
+			helmclient.BuildChartWithValues(map[string]interface{}{"replicas": 1}),
+			helmclient.BuildChartWithHelpers([]byte(`{{- define "hello.name" -}}{{ .Chart.Name }}{{- end -}}`)),
+			helmclient.BuildChartWithTemplate("configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ include \"hello.name\" . }}\n")),
+			helmclient.BuildChartWithCRD("crd.yaml", []byte("kind: CustomResourceDefinition\n")),
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, ch.Metadata.AppVersion, "1.0.0")
+		assert.Equal(t, len(ch.Templates), 2)
+		assert.Equal(t, len(ch.CRDObjects()), 1)
+		assert.Equal(t, ch.Values["replicas"], float64(1))
+	})
+	t.Run("invalid chart", func(t *testing.T) {
+		_, err := helmclient.BuildChart("hello", "", []helmclient.BuildChartOption{})
+		assert.ErrorContains(t, err, "version is required")
+	})
+}
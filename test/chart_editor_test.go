@@ -0,0 +1,115 @@
+package test
+
+import (
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const chartFileForTest = `apiVersion: v2
+# the chart name
+name: hello
+version: 1.2.3 # bumped by CI
+appVersion: "1.0"
+dependencies:
+  - name: redis
+    version: 14.0.0
+    repository: https://charts.bitnami.com/bitnami
+  - name: common
+    version: 1.0.0
+    repository: https://charts.bitnami.com/bitnami
+`
+
+func TestEditChart(t *testing.T) {
+	t.Run("edit chart", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte(chartFileForTest), 0644))
+		err := helmclient.EditChart(dir, []helmclient.EditChartOption{
+			helmclient.EditChartWithBumpVersion("minor"),
+			helmclient.EditChartWithAppVersion("1.1"),
+			helmclient.EditChartWithAnnotation("category", "Database"),
+			helmclient.EditChartWithMaintainers([]*chart.Maintainer{{Name: "platform"}}),
+			helmclient.EditChartWithDependency(&chart.Dependency{Name: "redis", Version: "15.0.0", Repository: "https://charts.bitnami.com/bitnami"}),
+			helmclient.EditChartWithoutDependency("common"),
+		})
+		assert.NilError(t, err)
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "Chart.yaml"))
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(string(data), "# the chart name"))
+		assert.Assert(t, strings.Contains(string(data), "# bumped by CI"))
+		assert.Assert(t, strings.Contains(string(data), `appVersion: "1.1"`))
+
+		ch, err := loader.LoadDir(dir)
+		assert.NilError(t, err)
+		assert.Equal(t, ch.Metadata.Version, "1.3.0")
+		assert.Equal(t, ch.Metadata.Annotations["category"], "Database")
+		assert.Equal(t, ch.Metadata.Maintainers[0].Name, "platform")
+		assert.Equal(t, len(ch.Metadata.Dependencies), 1)
+		assert.Equal(t, ch.Metadata.Dependencies[0].Version, "15.0.0")
+	})
+	t.Run("untouched lines kept", func(t *testing.T) {
+		const chartFile = `apiVersion: v2
+name: hello
+version: 1.2.3
+
+# the app packaged by the chart
+appVersion: "1.0"
+
+dependencies:
+    # cache
+    - name: redis
+      version: 14.0.0
+
+      repository: https://charts.bitnami.com/bitnami
+      tags:
+          - cache
+
+    - name: common
+      version: 1.0.0
+      repository: https://charts.bitnami.com/bitnami
+`
+		edit := func(t *testing.T, opts ...helmclient.EditChartOption) string {
+			dir := t.TempDir()
+			assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte(chartFile), 0644))
+			assert.NilError(t, helmclient.EditChart(dir, opts))
+			data, err := ioutil.ReadFile(filepath.Join(dir, "Chart.yaml"))
+			assert.NilError(t, err)
+			return string(data)
+		}
+
+		got := edit(t, helmclient.EditChartWithVersion("1.2.4"))
+		assert.Equal(t, got, strings.Replace(chartFile, "version: 1.2.3", "version: 1.2.4", 1))
+
+		got = edit(t, helmclient.EditChartWithDependency(&chart.Dependency{
+			Name:       "common",
+			Version:    "1.1.0",
+			Repository: "https://charts.bitnami.com/bitnami",
+		}))
+		assert.Equal(t, got, strings.Replace(chartFile, "version: 1.0.0", "version: 1.1.0", 1))
+
+		got = edit(t, helmclient.EditChartWithDependency(&chart.Dependency{
+			Name:       "postgresql",
+			Version:    "10.0.0",
+			Repository: "https://charts.bitnami.com/bitnami",
+		}))
+		assert.Equal(t, got, chartFile+`    - name: postgresql
+      version: 10.0.0
+      repository: https://charts.bitnami.com/bitnami
+`)
+	})
+	t.Run("invalid edit", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte(chartFileForTest), 0644))
+		err := helmclient.EditChart(dir, []helmclient.EditChartOption{helmclient.EditChartWithVersion("latest")})
+		assert.ErrorContains(t, err, "invalid chart version")
+		data, err := ioutil.ReadFile(filepath.Join(dir, "Chart.yaml"))
+		assert.NilError(t, err)
+		assert.Equal(t, string(data), chartFileForTest)
+	})
+}
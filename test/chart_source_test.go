@@ -0,0 +1,54 @@
+package test
+
+import (
+	"fmt"
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"io/ioutil"
+	"testing"
+	"testing/fstest"
+)
+
+var chartFSForTest = fstest.MapFS{
+	"Chart.yaml":               {Data: []byte("apiVersion: v2\nname: hello\nversion: 0.1.0\n")},
+	"values.yaml":              {Data: []byte("name: world\n")},
+	"templates/configmap.yaml": {Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\ndata:\n  hello: {{ .Values.name }}\n")},
+	"templates/ignored.yaml":   {Data: []byte("{{ fail \"ignored\" }}")},
+	".helmignore":              {Data: []byte("templates/ignored.yaml\n")},
+}
+
+func TestChartSource(t *testing.T) {
+	t.Run("template chart from fs", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		templateCli, err := cli.Template([]helmclient.TemplateOption{}, []helmclient.ValueOption{})
+		assert.Equal(t, err, nil)
+		err = templateCli.TemplateChart("hello", helmclient.ChartFromFS(chartFSForTest))
+		assert.Equal(t, err, nil)
+	})
+	t.Run("lint chart object", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint([]helmclient.LintOption{}, []helmclient.ValueOption{})
+		assert.Equal(t, err, nil)
+		ch := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "hello", Version: "0.1.0"}}
+		result, err := lintCli.LintChart(helmclient.ChartFromObject(ch))
+		fmt.Println(result, err)
+	})
+	t.Run("install chart archive", func(t *testing.T) {
+		ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+			helmclient.BuildChartWithTemplate("configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n")),
+		})
+		assert.Equal(t, err, nil)
+		archive, err := chartutil.Save(ch, t.TempDir())
+		assert.Equal(t, err, nil)
+		data, err := ioutil.ReadFile(archive)
+		assert.Equal(t, err, nil)
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		installCli, err := cli.Install([]helmclient.InstallOption{}, []helmclient.ValueOption{}, []helmclient.ChartPathOption{})
+		assert.Equal(t, err, nil)
+		release, err := installCli.InstallChart("hello", helmclient.ChartFromArchive(data))
+		fmt.Println(release)
+		assert.Equal(t, err, nil)
+	})
+}
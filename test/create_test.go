@@ -3,7 +3,12 @@ package test
 import (
 	helmclient "github.com/outgnaY/helm-go-client"
 	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 )
 
 func TestCreate(t *testing.T) {
@@ -11,7 +16,75 @@ func TestCreate(t *testing.T) {
 		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
 		createCli, err := cli.Create([]helmclient.CreateOption{})
 		assert.Equal(t, err, nil)
-		err = createCli.Create("name")
+		dir := t.TempDir()
+		files, err := createCli.Create(filepath.Join(dir, "name"))
 		assert.Equal(t, err, nil)
+		assert.Assert(t, len(files) > 0)
+		for _, file := range files {
+			_, err := os.Stat(file)
+			assert.NilError(t, err)
+		}
+		assert.Assert(t, containsPath(files, filepath.Join(dir, "name", "Chart.yaml")))
+		assert.Assert(t, containsPath(files, filepath.Join(dir, "name", "templates", "deployment.yaml")))
+
+		ch, err := loader.LoadDir(filepath.Join(dir, "name"))
+		assert.NilError(t, err)
+		assert.Equal(t, ch.Metadata.Name, "name")
 	})
+	t.Run("create chart from starter filesystem", func(t *testing.T) {
+		starter := fstest.MapFS{
+			"Chart.yaml":               {Data: []byte("apiVersion: v2\nname: starter\nversion: 0.0.1\n")},
+			"values.yaml":              {Data: []byte("team: <TEAM>\n")},
+			"templates/configmap.yaml": {Data: []byte("metadata:\n  name: <CHARTNAME>\n  labels:\n    team: <TEAM>\n")},
+		}
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		createCli, err := cli.Create([]helmclient.CreateOption{
+			helmclient.CreateWithStarterSource(helmclient.ChartFromFS(starter)),
+			helmclient.CreateWithVersion("1.0.0"),
+			helmclient.CreateWithType("library"),
+			helmclient.CreateWithTemplateVars(map[string]string{"TEAM": "platform"}),
+		})
+		assert.NilError(t, err)
+		dir := t.TempDir()
+		files, err := createCli.Create(filepath.Join(dir, "mychart"))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, files, []string{
+			filepath.Join(dir, "mychart", "Chart.yaml"),
+			filepath.Join(dir, "mychart", "values.yaml"),
+			filepath.Join(dir, "mychart", "templates", "configmap.yaml"),
+		})
+
+		ch, err := loader.LoadDir(filepath.Join(dir, "mychart"))
+		assert.NilError(t, err)
+		assert.Equal(t, ch.Metadata.Version, "1.0.0")
+		assert.Equal(t, ch.Metadata.Type, "library")
+		assert.Equal(t, ch.Values["team"], "platform")
+		assert.Equal(t, string(ch.Templates[0].Data), "metadata:\n  name: mychart\n  labels:\n    team: platform\n")
+	})
+	t.Run("existing files not reported", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		createCli, err := cli.Create([]helmclient.CreateOption{})
+		assert.NilError(t, err)
+		dir := t.TempDir()
+		existing := filepath.Join(dir, "name", "README.md")
+		assert.NilError(t, os.MkdirAll(filepath.Dir(existing), 0755))
+		assert.NilError(t, ioutil.WriteFile(existing, []byte("# name\n"), 0644))
+		files, err := createCli.Create(filepath.Join(dir, "name"))
+		assert.NilError(t, err)
+		assert.Assert(t, containsPath(files, filepath.Join(dir, "name", "Chart.yaml")))
+		assert.Assert(t, !containsPath(files, existing))
+		for _, file := range files {
+			_, err := os.Stat(file)
+			assert.NilError(t, err)
+		}
+	})
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,181 @@
+package test
+
+import (
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// authRepoServer serves a chart repository which requires basic auth.
+type authRepoServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	password string
+}
+
+func (s *authRepoServer) setPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+func newAuthRepoServer(t *testing.T, password string) *authRepoServer {
+	ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+		helmclient.BuildChartWithTemplate("configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: hello\n")),
+	})
+	assert.NilError(t, err)
+	dir := t.TempDir()
+	archive, err := chartutil.Save(ch, dir)
+	assert.NilError(t, err)
+
+	s := &authRepoServer{password: password}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		password := s.password
+		s.mu.Unlock()
+		if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.FileServer(http.Dir(dir)).ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+
+	digest, err := provenance.DigestFile(archive)
+	assert.NilError(t, err)
+	index := repo.NewIndexFile()
+	assert.NilError(t, index.MustAdd(ch.Metadata, filepath.Base(archive), s.URL, digest))
+	assert.NilError(t, index.WriteFile(filepath.Join(dir, "index.yaml"), 0644))
+	return s
+}
+
+func TestCredentialProviders(t *testing.T) {
+	server := newAuthRepoServer(t, "secret")
+	home := t.TempDir()
+	repoFile := filepath.Join(home, "repositories.yaml")
+	repoCache := filepath.Join(home, "cache")
+	globalOpts := []helmclient.GlobalOption{
+		helmclient.WithRepositoryConfig(repoFile),
+		helmclient.WithRepositoryCache(repoCache),
+	}
+
+	t.Run("repo add without password", func(t *testing.T) {
+		cli := helmclient.NewHelmClientWithGlobalOpts(kubeConfigForTest, "default", globalOpts)
+		repoAddCli, err := cli.RepoAdd([]helmclient.RepoAddOption{helmclient.RepoAddWithUsername("user")})
+		assert.NilError(t, err)
+		err = repoAddCli.RepoAdd([]string{"private", server.URL})
+		assert.Error(t, err, `no password for repository "private", set one with RepoAddWithPassword or a credential provider`)
+	})
+	t.Run("repo add with static credentials", func(t *testing.T) {
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", globalOpts, []helmclient.ClientOption{
+			helmclient.ClientWithCredentialProvider(helmclient.NewStaticCredentialProvider(map[string]helmclient.RepoCredentials{
+				server.URL: {Username: "user", Password: "secret"},
+			})),
+		})
+		repoAddCli, err := cli.RepoAdd([]helmclient.RepoAddOption{})
+		assert.NilError(t, err)
+		assert.NilError(t, repoAddCli.RepoAdd([]string{"private", server.URL}))
+		f, err := repo.LoadFile(repoFile)
+		assert.NilError(t, err)
+		entry := f.Get("private")
+		assert.Equal(t, entry.URL, server.URL)
+		assert.Equal(t, entry.Username, "")
+		assert.Equal(t, entry.Password, "")
+	})
+	t.Run("package with dependency from authenticated repository", func(t *testing.T) {
+		ch, err := helmclient.BuildChart("app", "0.1.0", []helmclient.BuildChartOption{
+			helmclient.BuildChartWithDependency(&chart.Dependency{Name: "hello", Version: "0.1.0", Repository: "@private"}, nil),
+		})
+		assert.NilError(t, err)
+		dir := t.TempDir()
+		assert.NilError(t, chartutil.SaveDir(ch, dir))
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", globalOpts, []helmclient.ClientOption{
+			helmclient.ClientWithCredentialProvider(helmclient.NewStaticCredentialProvider(map[string]helmclient.RepoCredentials{
+				"private": {Username: "user", Password: "secret"},
+			})),
+		})
+		dest := t.TempDir()
+		packageCli, err := cli.Package([]helmclient.PackageOption{
+			helmclient.PackageWithDependencyUpdate(true),
+			helmclient.PackageWithDestination(dest),
+		})
+		assert.NilError(t, err)
+		assert.NilError(t, packageCli.Package([]string{filepath.Join(dir, "app")}))
+		_, err = os.Stat(filepath.Join(dir, "app", "charts", "hello-0.1.0.tgz"))
+		assert.NilError(t, err)
+	})
+	t.Run("repo update with environment credentials", func(t *testing.T) {
+		os.Setenv("TEST_REPO_PRIVATE_USERNAME", "user")
+		os.Setenv("TEST_REPO_PRIVATE_PASSWORD", "secret")
+		defer os.Unsetenv("TEST_REPO_PRIVATE_USERNAME")
+		defer os.Unsetenv("TEST_REPO_PRIVATE_PASSWORD")
+		indexFile := filepath.Join(repoCache, "private-index.yaml")
+		assert.NilError(t, os.Remove(indexFile))
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", globalOpts, []helmclient.ClientOption{
+			helmclient.ClientWithCredentialProvider(helmclient.NewEnvCredentialProvider("TEST_REPO_")),
+		})
+		repoUpdateCli, err := cli.RepoUpdate([]helmclient.RepoUpdateOption{})
+		assert.NilError(t, err)
+		_, err = repoUpdateCli.RepoUpdate([]string{})
+		assert.NilError(t, err)
+		_, err = os.Stat(indexFile)
+		assert.NilError(t, err)
+	})
+	t.Run("pull with file credentials", func(t *testing.T) {
+		credentialsFile := filepath.Join(home, "credentials.yaml")
+		assert.NilError(t, ioutil.WriteFile(credentialsFile, []byte("private:\n  username: user\n  password: secret\n"), 0600))
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", globalOpts, []helmclient.ClientOption{
+			helmclient.ClientWithCredentialProvider(helmclient.NewFileCredentialProvider(credentialsFile)),
+		})
+		dest := t.TempDir()
+		pullCli, err := cli.Pull([]helmclient.PullOption{helmclient.PullWithDestDir(dest)}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		assert.NilError(t, pullCli.Pull([]string{"private/hello"}))
+		_, err = os.Stat(filepath.Join(dest, "hello-0.1.0.tgz"))
+		assert.NilError(t, err)
+
+		// the rotated password is read from the file again
+		server.setPassword("rotated")
+		defer server.setPassword("secret")
+		assert.NilError(t, ioutil.WriteFile(credentialsFile, []byte("private:\n  username: user\n  password: rotated\n"), 0600))
+		assert.NilError(t, os.Remove(filepath.Join(dest, "hello-0.1.0.tgz")))
+		assert.NilError(t, pullCli.Pull([]string{"private/hello"}))
+		_, err = os.Stat(filepath.Join(dest, "hello-0.1.0.tgz"))
+		assert.NilError(t, err)
+	})
+	t.Run("template with callback credentials", func(t *testing.T) {
+		var asked []string
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", globalOpts, []helmclient.ClientOption{
+			helmclient.ClientWithCredentialProvider(helmclient.CredentialProviderFunc(func(name string, url string) (*helmclient.RepoCredentials, error) {
+				asked = append(asked, name+" "+url)
+				return &helmclient.RepoCredentials{Username: "user", Password: "secret"}, nil
+			})),
+		})
+		templateCli, err := cli.Template([]helmclient.TemplateOption{}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		assert.NilError(t, templateCli.Template([]string{"private/hello"}))
+		// asked for the chart path options and for the repositories file
+		assert.Assert(t, len(asked) > 0)
+		for _, a := range asked {
+			assert.Equal(t, a, "private "+server.URL)
+		}
+	})
+	t.Run("pull without credentials", func(t *testing.T) {
+		cli := helmclient.NewHelmClientWithGlobalOpts(kubeConfigForTest, "default", globalOpts)
+		pullCli, err := cli.Pull([]helmclient.PullOption{helmclient.PullWithDestDir(t.TempDir())}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		err = pullCli.Pull([]string{"private/hello"})
+		assert.Assert(t, err != nil && strings.Contains(err.Error(), "401"), err)
+	})
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/release"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const lookupTemplateForTest = `{{- $secret := lookup "v1" "Secret" .Release.Namespace "hello-password" }}
+apiVersion: v1
+kind: Secret
+metadata:
+  name: hello-password
+data:
+  password: {{ if $secret }}{{ index $secret.data "password" }}{{ else }}{{ "generated" | b64enc }}{{ end }}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: hello
+data:
+  configMaps: {{ (lookup "v1" "ConfigMap" "" "").items | len | quote }}
+  monitors: {{ (lookup "monitoring.coreos.com/v1" "ServiceMonitor" "monitoring" "").items | len | quote }}
+`
+
+const fakeClusterForTest = `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: one
+    namespace: default
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: two
+    namespace: other
+---
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: hello
+  namespace: monitoring
+`
+
+func TestFakeCluster(t *testing.T) {
+	ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+		helmclient.BuildChartWithTemplate("lookup.yaml", []byte(lookupTemplateForTest)),
+	})
+	assert.NilError(t, err)
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "hello-password", "namespace": "default"},
+		"data":       map[string]interface{}{"password": "c2VjcmV0"},
+	}}
+	monitor := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       "ServiceMonitor",
+		"metadata":   map[string]interface{}{"name": "other", "namespace": "default"},
+	}}
+
+	t.Run("objects", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		installCli, err := cli.Install([]helmclient.InstallOption{
+			helmclient.InstallWithDryRun(true),
+			helmclient.InstallWithFakeCluster(helmclient.NewFakeCluster([]*unstructured.Unstructured{secret, monitor})),
+		}, []helmclient.ValueOption{}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		rel, err := installCli.InstallChart("hello", helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(rel.Manifest, "password: c2VjcmV0"), rel.Manifest)
+		assert.Assert(t, strings.Contains(rel.Manifest, `configMaps: "0"`), rel.Manifest)
+		assert.Assert(t, strings.Contains(rel.Manifest, `monitors: "0"`), rel.Manifest)
+		assert.Equal(t, rel.Info.Status, release.StatusPendingInstall)
+		assert.Equal(t, rel.Info.Description, "Dry run complete")
+	})
+	t.Run("yaml files", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "fake-cluster")
+		assert.NilError(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "state.yaml")
+		assert.NilError(t, ioutil.WriteFile(path, []byte(fakeClusterForTest), 0644))
+		fakeCluster, err := helmclient.LoadFakeCluster([]string{path})
+		assert.NilError(t, err)
+
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		installCli, err := cli.Install([]helmclient.InstallOption{
+			helmclient.InstallWithDryRun(true),
+			helmclient.InstallWithFakeCluster(fakeCluster),
+		}, []helmclient.ValueOption{}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		rel, err := installCli.InstallChart("hello", helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(rel.Manifest, "password: Z2VuZXJhdGVk"), rel.Manifest)
+		assert.Assert(t, strings.Contains(rel.Manifest, `configMaps: "2"`), rel.Manifest)
+		assert.Assert(t, strings.Contains(rel.Manifest, `monitors: "1"`), rel.Manifest)
+	})
+	t.Run("template", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		templateCli, err := cli.Template([]helmclient.TemplateOption{
+			helmclient.TemplateWithFakeCluster(helmclient.NewFakeCluster([]*unstructured.Unstructured{secret, monitor})),
+		}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		err = templateCli.TemplateChart("hello", helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+	})
+	t.Run("template can't validate", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		_, err := cli.Template([]helmclient.TemplateOption{
+			helmclient.TemplateWithFakeCluster(helmclient.NewFakeCluster(nil)),
+			helmclient.TemplateWithValidate(true),
+		}, []helmclient.ValueOption{})
+		assert.ErrorContains(t, err, "validate")
+	})
+	t.Run("requires dry run", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		installCli, err := cli.Install([]helmclient.InstallOption{
+			helmclient.InstallWithFakeCluster(helmclient.NewFakeCluster(nil)),
+		}, []helmclient.ValueOption{}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		_, err = installCli.InstallChart("hello", helmclient.ChartFromObject(ch))
+		assert.ErrorContains(t, err, "dry run")
+	})
+	t.Run("unknown kind", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		installCli, err := cli.Install([]helmclient.InstallOption{
+			helmclient.InstallWithDryRun(true),
+			helmclient.InstallWithFakeCluster(helmclient.NewFakeCluster(nil)),
+		}, []helmclient.ValueOption{}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		_, err = installCli.InstallChart("hello", helmclient.ChartFromObject(ch))
+		assert.ErrorContains(t, err, "ServiceMonitor")
+	})
+}
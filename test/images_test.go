@@ -0,0 +1,149 @@
+package test
+
+import (
+	"fmt"
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"testing"
+)
+
+const workloadsForImagesTest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrate
+          image: registry.example.com/team/migrate:1.2.0
+      containers:
+        - name: web
+          image: nginx
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: backup
+  namespace: jobs
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: backup
+              image: busybox@sha256:fc9ec0e8a8a8d8d5b9d8e4d0c0b2f4f8d0fc2a3f5c1d9e9a6b7c2d2e1f0a9b8c
+---
+apiVersion: v1
+kind: List
+items:
+  - apiVersion: v1
+    kind: Pod
+    metadata:
+      name: debug
+    spec:
+      containers:
+        - name: app
+          image: alpine:3.14
+      ephemeralContainers:
+        - name: shell
+          image: busybox:1.33
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+`
+
+const hooksForImagesTest = `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: setup
+  annotations:
+    helm.sh/hook: pre-install
+spec:
+  template:
+    spec:
+      containers:
+        - name: setup
+          image: quay.io/example/setup:v1
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: test
+  annotations:
+    helm.sh/hook: test
+spec:
+  containers:
+    - name: test
+      image: curlimages/curl:7.78.0
+`
+
+func TestImages(t *testing.T) {
+	ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+		helmclient.BuildChartWithTemplate("workloads.yaml", []byte(workloadsForImagesTest)),
+		helmclient.BuildChartWithTemplate("hooks.yaml", []byte(hooksForImagesTest)),
+	})
+	assert.NilError(t, err)
+
+	t.Run("chart images", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		templateCli, err := cli.Template([]helmclient.TemplateOption{}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		images, err := templateCli.ImagesChart("hello", helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+		byPath := map[string]*helmclient.ImageReference{}
+		for _, image := range images {
+			byPath[image.Resource()+":"+image.Path] = image
+		}
+		assert.Equal(t, len(byPath), 7, images)
+
+		migrate := byPath["Deployment/default/web:spec.template.spec.initContainers[0]"]
+		assert.Equal(t, migrate.Repository, "registry.example.com/team/migrate")
+		assert.Equal(t, migrate.Tag, "1.2.0")
+		assert.Equal(t, migrate.Container, "migrate")
+		assert.Equal(t, migrate.Template, "templates/workloads.yaml")
+		assert.Equal(t, migrate.Release, "hello")
+		web := byPath["Deployment/default/web:spec.template.spec.containers[0]"]
+		assert.Equal(t, web.Image, "nginx")
+		assert.Equal(t, web.Repository, "docker.io/library/nginx")
+		assert.Equal(t, web.Tag, "")
+		backup := byPath["CronJob/jobs/backup:spec.jobTemplate.spec.template.spec.containers[0]"]
+		assert.Equal(t, backup.Digest, "sha256:fc9ec0e8a8a8d8d5b9d8e4d0c0b2f4f8d0fc2a3f5c1d9e9a6b7c2d2e1f0a9b8c")
+		assert.Equal(t, byPath["Pod/default/debug:spec.containers[0]"].Tag, "3.14")
+		assert.Equal(t, byPath["Pod/default/debug:spec.ephemeralContainers[0]"].Container, "shell")
+		setup := byPath["Job/default/setup:spec.template.spec.containers[0]"]
+		assert.Assert(t, setup.Hook)
+		assert.Equal(t, setup.Repository, "quay.io/example/setup")
+		assert.Assert(t, byPath["Pod/default/test:spec.containers[0]"].Hook)
+	})
+	t.Run("chart images without tests", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		templateCli, err := cli.Template([]helmclient.TemplateOption{helmclient.TemplateWithSkipTests(true)}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		images, err := templateCli.ImagesChart("hello", helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+		assert.Equal(t, len(images), 6, images)
+		for _, image := range images {
+			assert.Assert(t, image.Name != "test", image)
+		}
+	})
+	t.Run("release images", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		getManifest, err := cli.GetManifest([]helmclient.GetManifestOption{})
+		assert.Equal(t, err, nil)
+		images, err := getManifest.Images("RELEASE_NAME")
+		assert.Equal(t, err, nil)
+		fmt.Println(images)
+	})
+	t.Run("all release images", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		listCli, err := cli.List([]helmclient.ListOption{})
+		assert.Equal(t, err, nil)
+		images, err := listCli.Images()
+		assert.Equal(t, err, nil)
+		fmt.Println(images)
+	})
+}
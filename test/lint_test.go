@@ -1,18 +1,389 @@
 package test
 
 import (
-	helmclient "github.com/outgnaY/helm-go-client"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	helmclient "github.com/outgnaY/helm-go-client"
+	"github.com/pkg/errors"
 	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
+const deploymentForLintTest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+spec:
+  selector:
+    matchLabels:
+      app: hello
+  template:
+    metadata:
+      labels:
+        app: hello
+    spec:
+      containers:
+        - name: hello
+          image: nginx
+`
+
+var resourceLimitsRule = helmclient.LintRuleFunc("org/resource-limits", func(target *helmclient.LintTarget) []helmclient.LintMessage {
+	var messages []helmclient.LintMessage
+	for _, obj := range target.Objects {
+		if obj.Object.GetKind() != "Deployment" {
+			continue
+		}
+		containers, _, _ := unstructured.NestedSlice(obj.Object.Object, "spec", "template", "spec", "containers")
+		for _, c := range containers {
+			if _, found, _ := unstructured.NestedMap(c.(map[string]interface{}), "resources", "limits"); !found {
+				messages = append(messages, helmclient.LintMessage{
+					Severity: helmclient.LintSeverityError,
+					Path:     obj.Path,
+					Err:      errors.Errorf("container %s has no resource limits", c.(map[string]interface{})["name"]),
+				})
+			}
+		}
+	}
+	return messages
+})
+
+var maintainersRule = helmclient.LintRuleFunc("org/maintainers", func(target *helmclient.LintTarget) []helmclient.LintMessage {
+	if len(target.Chart.Metadata.Maintainers) > 0 {
+		return nil
+	}
+	return []helmclient.LintMessage{{Severity: helmclient.LintSeverityWarning, Path: "Chart.yaml", Err: errors.New("chart declares no maintainers")}}
+})
+
 func TestLint(t *testing.T) {
 	t.Run("lint", func(t *testing.T) {
 		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
 		lintCli, err := cli.Lint([]helmclient.LintOption{}, []helmclient.ValueOption{})
 		assert.Equal(t, err, nil)
-		err = lintCli.Lint([]string{"PATH"})
-		fmt.Println(err)
+		results, err := lintCli.Lint([]string{"PATH"})
+		fmt.Println(results, err)
+	})
+	t.Run("lint with custom rules", func(t *testing.T) {
+		ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+			helmclient.BuildChartWithIcon("https://example.com/icon.png"),
+			helmclient.BuildChartWithTemplate("deployment.yaml", []byte(deploymentForLintTest)),
+		})
+		assert.NilError(t, err)
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint([]helmclient.LintOption{
+			helmclient.LintWithRule(resourceLimitsRule),
+			helmclient.LintWithRule(maintainersRule),
+		}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		result, err := lintCli.LintChart(helmclient.ChartFromObject(ch))
+		assert.Error(t, err, "1 chart(s) linted, 1 chart(s) failed")
+		assert.Assert(t, result.Failed)
+		rules := map[string]helmclient.LintMessage{}
+		for _, msg := range result.Messages {
+			rules[msg.Rule] = msg
+		}
+		assert.Equal(t, rules["org/resource-limits"].Path, "templates/deployment.yaml")
+		assert.Equal(t, rules["org/resource-limits"].Severity, helmclient.LintSeverityError)
+		assert.Equal(t, rules["org/maintainers"].Severity, helmclient.LintSeverityWarning)
+	})
+	t.Run("lint reports helm rules", func(t *testing.T) {
+		ch := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "hello", Version: "0.1.0"}}
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint([]helmclient.LintOption{}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		result, err := lintCli.LintChart(helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+		assert.Assert(t, !result.Failed)
+		for _, msg := range result.Messages {
+			if msg.Path == "Chart.yaml" {
+				assert.Equal(t, msg.Rule, helmclient.LintRuleHelmChartfile)
+			}
+		}
+	})
+}
+
+func TestLintReport(t *testing.T) {
+	ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+		helmclient.BuildChartWithTemplate("deployment.yaml", []byte(deploymentForLintTest)),
+	})
+	assert.NilError(t, err)
+	t.Run("sarif", func(t *testing.T) {
+		// lintSARIF lints the chart at path, or the chart object when path is empty
+		lintSARIF := func(t *testing.T, path string) sarifLogForTest {
+			var out bytes.Buffer
+			cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+			lintCli, err := cli.Lint([]helmclient.LintOption{
+				helmclient.LintWithRule(resourceLimitsRule),
+				helmclient.LintWithReport(helmclient.LintFormatSARIF, &out),
+			}, []helmclient.ValueOption{})
+			assert.NilError(t, err)
+			if path == "" {
+				_, err = lintCli.LintChart(helmclient.ChartFromObject(ch))
+			} else {
+				_, err = lintCli.Lint([]string{path})
+			}
+			assert.Assert(t, err != nil)
+			var log sarifLogForTest
+			assert.NilError(t, json.Unmarshal(out.Bytes(), &log))
+			assert.Equal(t, log.Version, "2.1.0")
+			found := false
+			for _, r := range log.Runs[0].Results {
+				if r.RuleID == "org/resource-limits" {
+					found = true
+					assert.Equal(t, r.Level, "error")
+					assert.Equal(t, r.Locations[0].PhysicalLocation.ArtifactLocation.URI, "templates/deployment.yaml")
+					assert.Equal(t, r.Locations[0].PhysicalLocation.ArtifactLocation.URIBaseID, "CHART1")
+				}
+			}
+			assert.Assert(t, found)
+			return log
+		}
+
+		log := lintSARIF(t, "")
+		assert.Equal(t, log.Runs[0].OriginalURIBaseIDs["CHART1"].URI, "")
+
+		dir := t.TempDir()
+		assert.NilError(t, chartutil.SaveDir(ch, dir))
+		log = lintSARIF(t, filepath.Join(dir, "hello"))
+		assert.Equal(t, log.Runs[0].OriginalURIBaseIDs["CHART1"].URI, "file://"+filepath.ToSlash(filepath.Join(dir, "hello"))+"/")
+	})
+	t.Run("junit", func(t *testing.T) {
+		var out bytes.Buffer
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint([]helmclient.LintOption{
+			helmclient.LintWithRule(resourceLimitsRule),
+			helmclient.LintWithReport(helmclient.LintFormatJUnit, &out),
+		}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		_, err = lintCli.LintChart(helmclient.ChartFromObject(ch))
+		assert.Assert(t, err != nil)
+		var suites struct {
+			Tests    int `xml:"tests,attr"`
+			Failures int `xml:"failures,attr"`
+			Suites   []struct {
+				Name      string `xml:"name,attr"`
+				TestCases []struct {
+					Name    string    `xml:"name,attr"`
+					Failure *struct{} `xml:"failure"`
+				} `xml:"testcase"`
+			} `xml:"testsuite"`
+		}
+		assert.NilError(t, xml.Unmarshal(out.Bytes(), &suites))
+		assert.Equal(t, suites.Tests, 5)
+		assert.Equal(t, suites.Failures, 1)
+		assert.Equal(t, suites.Suites[0].Name, "hello")
+		assert.Equal(t, suites.Suites[0].TestCases[4].Name, "org/resource-limits")
+		assert.Assert(t, suites.Suites[0].TestCases[4].Failure != nil)
+	})
+}
+
+const ingressForLintTest = `{{- if .Capabilities.APIVersions.Has "networking.k8s.io/v1/Ingress" }}
+apiVersion: networking.k8s.io/v1
+{{- else }}
+apiVersion: extensions/v1beta1
+{{- end }}
+kind: Ingress
+metadata:
+  name: {{ .Release.Name }}
+`
+
+const cronJobForLintTest = `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: {{ .Release.Name }}
+`
+
+func TestLintKubeVersion(t *testing.T) {
+	ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+		helmclient.BuildChartWithIcon("https://example.com/icon.png"),
+		helmclient.BuildChartWithKubeVersion(">=1.18.0-0"),
+		helmclient.BuildChartWithTemplate("ingress.yaml", []byte(ingressForLintTest)),
+		helmclient.BuildChartWithTemplate("cronjob.yaml", []byte(cronJobForLintTest)),
 	})
+	assert.NilError(t, err)
+	lint := func(opts ...helmclient.LintOption) (map[string][]helmclient.LintMessage, error) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint(opts, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		result, err := lintCli.LintChart(helmclient.ChartFromObject(ch))
+		messages := map[string][]helmclient.LintMessage{}
+		if result != nil {
+			for _, msg := range result.Messages {
+				messages[msg.Rule] = append(messages[msg.Rule], msg)
+			}
+		}
+		return messages, err
+	}
+	t.Run("removed api", func(t *testing.T) {
+		messages, err := lint(helmclient.LintWithKubeVersion("v1.25.3"))
+		assert.Assert(t, err != nil)
+		deprecated := messages[helmclient.LintRuleDeprecatedAPIs]
+		assert.Equal(t, len(deprecated), 2)
+		assert.Equal(t, deprecated[0].Path, "templates/cronjob.yaml")
+		assert.Equal(t, deprecated[0].Severity, helmclient.LintSeverityError)
+		assert.Equal(t, deprecated[1].Path, "templates/ingress.yaml")
+	})
+	t.Run("deprecated api", func(t *testing.T) {
+		messages, err := lint(helmclient.LintWithKubeVersion("1.21.0"))
+		assert.NilError(t, err)
+		deprecated := messages[helmclient.LintRuleDeprecatedAPIs]
+		assert.Equal(t, len(deprecated), 2)
+		for _, msg := range deprecated {
+			assert.Equal(t, msg.Severity, helmclient.LintSeverityWarning)
+		}
+	})
+	t.Run("extra apis", func(t *testing.T) {
+		messages, err := lint(
+			helmclient.LintWithKubeVersion("1.22.0"),
+			helmclient.LintWithExtraAPIs([]string{"networking.k8s.io/v1/Ingress"}),
+		)
+		assert.NilError(t, err)
+		deprecated := messages[helmclient.LintRuleDeprecatedAPIs]
+		assert.Equal(t, len(deprecated), 1)
+		assert.Equal(t, deprecated[0].Path, "templates/cronjob.yaml")
+	})
+	t.Run("incompatible kube version", func(t *testing.T) {
+		messages, err := lint(helmclient.LintWithKubeVersion("1.17.0"))
+		assert.Assert(t, err != nil)
+		assert.Equal(t, len(messages[helmclient.LintRuleKubeVersion]), 1)
+	})
+	t.Run("invalid kube version", func(t *testing.T) {
+		_, err := lint(helmclient.LintWithKubeVersion("invalid"))
+		assert.ErrorContains(t, err, "invalid kube version")
+	})
+	t.Run("templates rendered for the version", func(t *testing.T) {
+		ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+			helmclient.BuildChartWithIcon("https://example.com/icon.png"),
+			helmclient.BuildChartWithTemplate("configmap.yaml", []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+{{- if semverCompare "<1.19-0" .Capabilities.KubeVersion.Version }}
+data: [
+{{- end }}
+`)),
+		})
+		assert.NilError(t, err)
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint([]helmclient.LintOption{helmclient.LintWithKubeVersion("1.18.0")}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		result, err := lintCli.LintChart(helmclient.ChartFromObject(ch))
+		assert.Assert(t, err != nil)
+		var templates []helmclient.LintMessage
+		for _, msg := range result.Messages {
+			if msg.Rule == helmclient.LintRuleHelmTemplates {
+				templates = append(templates, msg)
+			}
+		}
+		assert.Equal(t, len(templates), 1)
+		assert.Equal(t, templates[0].Path, "templates/configmap.yaml")
+		assert.Equal(t, templates[0].Severity, helmclient.LintSeverityError)
+
+		lintCli, err = cli.Lint([]helmclient.LintOption{helmclient.LintWithKubeVersion("1.19.0")}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		_, err = lintCli.LintChart(helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+	})
+	t.Run("template source checked", func(t *testing.T) {
+		ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+			helmclient.BuildChartWithIcon("https://example.com/icon.png"),
+			helmclient.BuildChartWithTemplate("configmap.yaml", []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+data:
+  time: {{ .Release.Time | quote }}
+`)),
+		})
+		assert.NilError(t, err)
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint([]helmclient.LintOption{helmclient.LintWithKubeVersion("1.19.0")}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		result, err := lintCli.LintChart(helmclient.ChartFromObject(ch))
+		assert.Assert(t, err != nil)
+		var releaseTime []helmclient.LintMessage
+		for _, msg := range result.Messages {
+			if strings.Contains(msg.Err.Error(), ".Release.Time") {
+				releaseTime = append(releaseTime, msg)
+			}
+		}
+		assert.Equal(t, len(releaseTime), 1)
+		assert.Equal(t, releaseTime[0].Rule, helmclient.LintRuleHelmTemplates)
+		assert.Equal(t, releaseTime[0].Path, "templates/configmap.yaml")
+		assert.Equal(t, releaseTime[0].Severity, helmclient.LintSeverityError)
+	})
+}
+
+func TestLintMatrix(t *testing.T) {
+	ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+		helmclient.BuildChartWithIcon("https://example.com/icon.png"),
+		helmclient.BuildChartWithValues(map[string]interface{}{"name": "world"}),
+		helmclient.BuildChartWithSchema([]byte(`{"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}`)),
+		helmclient.BuildChartWithTemplate("configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Values.name }}\n")),
+	})
+	assert.NilError(t, err)
+	dir, err := ioutil.TempDir("", "lint-matrix")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NilError(t, chartutil.SaveDir(ch, dir))
+	chartPath := filepath.Join(dir, "hello")
+	ciDir := filepath.Join(chartPath, "ci")
+	assert.NilError(t, os.Mkdir(ciDir, 0755))
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(ciDir, "default-values.yaml"), []byte("{}\n"), 0644))
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(ciDir, "unnamed-values.yaml"), []byte("name: 1\n"), 0644))
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(ciDir, "README.md"), []byte("not values\n"), 0644))
+
+	t.Run("named value sets", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint([]helmclient.LintOption{helmclient.LintWithConcurrency(2)}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		results, err := lintCli.LintMatrix(chartPath, map[string][]helmclient.ValueOption{
+			"custom":  {helmclient.WithValues([]string{"name=custom"})},
+			"missing": {helmclient.WithValues([]string{"name=1"})},
+		})
+		assert.Error(t, err, "2 chart(s) linted, 1 chart(s) failed")
+		assert.Equal(t, len(results), 2)
+		assert.Assert(t, !results["custom"].Failed)
+		assert.Assert(t, results["missing"].Failed)
+		assert.Equal(t, results["missing"].ValueSet, "missing")
+	})
+	t.Run("values dir", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint([]helmclient.LintOption{}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		results, err := lintCli.LintValuesDir(chartPath, ciDir)
+		assert.Assert(t, err != nil)
+		assert.Equal(t, len(results), 2)
+		assert.Assert(t, !results["default-values"].Failed)
+		assert.Assert(t, results["unnamed-values"].Failed)
+	})
+}
+
+type sarifLogForTest struct {
+	Version string `json:"version"`
+	Runs    []struct {
+		OriginalURIBaseIDs map[string]struct {
+			URI string `json:"uri"`
+		} `json:"originalUriBaseIds"`
+		Results []struct {
+			RuleID    string `json:"ruleId"`
+			Level     string `json:"level"`
+			Locations []struct {
+				PhysicalLocation struct {
+					ArtifactLocation struct {
+						URI       string `json:"uri"`
+						URIBaseID string `json:"uriBaseId"`
+					} `json:"artifactLocation"`
+				} `json:"physicalLocation"`
+			} `json:"locations"`
+		} `json:"results"`
+	} `json:"runs"`
 }
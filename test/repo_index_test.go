@@ -3,7 +3,14 @@ package test
 import (
 	helmclient "github.com/outgnaY/helm-go-client"
 	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestRepoIndex(t *testing.T) {
@@ -11,7 +18,111 @@ func TestRepoIndex(t *testing.T) {
 		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
 		repoIndexCli, err := cli.RepoIndex([]helmclient.RepoIndexOption{})
 		assert.Equal(t, err, nil)
-		err = repoIndexCli.RepoIndex("DIR")
+		_, err = repoIndexCli.RepoIndex("DIR")
 		assert.Equal(t, err, nil)
 	})
 }
+
+func TestRepoIndexIncremental(t *testing.T) {
+	dir := t.TempDir()
+	copyArchive := func(archive string, dest string) {
+		data, err := ioutil.ReadFile(archive)
+		assert.NilError(t, err)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, dest)), 0755))
+		assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, dest), data, 0644))
+	}
+	copyArchive(packageChart(t, "alpha", "0.1.0"), "alpha-0.1.0.tgz")
+	copyArchive(packageChart(t, "beta", "0.1.0"), "beta/beta-0.1.0.tgz")
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "broken.tgz"), []byte("not a chart"), 0644))
+
+	cache := t.TempDir()
+	cli := helmclient.NewHelmClientWithGlobalOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{
+		helmclient.WithRepositoryCache(cache),
+	})
+	repoIndexCli, err := cli.RepoIndex([]helmclient.RepoIndexOption{
+		helmclient.RepoIndexWithUrl("https://charts.example.com"),
+		helmclient.RepoIndexWithConcurrency(2),
+	})
+	assert.NilError(t, err)
+
+	report, err := repoIndexCli.RepoIndex(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Hashed, 3)
+	assert.Equal(t, report.Reused, 0)
+	assert.Equal(t, len(report.Added), 2)
+	assert.Equal(t, report.Added[0].Name, "alpha")
+	assert.Equal(t, len(report.Removed), 0)
+	index, err := repo.LoadIndexFile(filepath.Join(dir, "index.yaml"))
+	assert.NilError(t, err)
+	beta, err := index.Get("beta", "0.1.0")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, beta.URLs, []string{"https://charts.example.com/beta/beta-0.1.0.tgz"})
+	digest, err := provenance.DigestFile(filepath.Join(dir, "beta", "beta-0.1.0.tgz"))
+	assert.NilError(t, err)
+	assert.Equal(t, beta.Digest, digest)
+	// the state is kept in the repository cache, not in the indexed directory
+	states, err := filepath.Glob(filepath.Join(cache, "index-state", "*.json"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(states), 1)
+	_, err = os.Stat(filepath.Join(dir, ".index-state.json"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	// unchanged archives keep their entries
+	report, err = repoIndexCli.RepoIndex(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Hashed, 0)
+	assert.Equal(t, report.Reused, 3)
+	assert.Equal(t, len(report.Added)+len(report.Removed)+len(report.Changed), 0)
+	again, err := repo.LoadIndexFile(filepath.Join(dir, "index.yaml"))
+	assert.NilError(t, err)
+	betaAgain, err := again.Get("beta", "0.1.0")
+	assert.NilError(t, err)
+	assert.Assert(t, betaAgain.Created.Equal(beta.Created))
+
+	// new, replaced and removed archives are reported
+	copyArchive(packageChart(t, "gamma", "1.0.0"), "gamma-1.0.0.tgz")
+	replaced, err := helmclient.BuildChart("alpha", "0.1.0", []helmclient.BuildChartOption{
+		helmclient.BuildChartWithTemplate("other.yaml", []byte("kind: ConfigMap\n")),
+	})
+	assert.NilError(t, err)
+	archive, err := chartutil.Save(replaced, t.TempDir())
+	assert.NilError(t, err)
+	copyArchive(archive, "alpha-0.1.0.tgz")
+	future := time.Now().Add(time.Minute)
+	assert.NilError(t, os.Chtimes(filepath.Join(dir, "alpha-0.1.0.tgz"), future, future))
+	assert.NilError(t, os.Remove(filepath.Join(dir, "beta", "beta-0.1.0.tgz")))
+	report, err = repoIndexCli.RepoIndex(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Hashed, 2)
+	assert.Equal(t, report.Reused, 1)
+	assert.Equal(t, len(report.Added), 1)
+	assert.Equal(t, report.Added[0].Name, "gamma")
+	assert.Equal(t, len(report.Changed), 1)
+	assert.Equal(t, report.Changed[0].Name, "alpha")
+	assert.Equal(t, len(report.Removed), 1)
+	assert.Equal(t, report.Removed[0].Name, "beta")
+}
+
+func TestRepoIndexStateFile(t *testing.T) {
+	dir := t.TempDir()
+	data, err := ioutil.ReadFile(packageChart(t, "alpha", "0.1.0"))
+	assert.NilError(t, err)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "alpha-0.1.0.tgz"), data, 0644))
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+	repoIndexCli, err := cli.RepoIndex([]helmclient.RepoIndexOption{helmclient.RepoIndexWithStateFile(stateFile)})
+	assert.NilError(t, err)
+	report, err := repoIndexCli.RepoIndex(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Hashed, 1)
+	_, err = os.Stat(stateFile)
+	assert.NilError(t, err)
+	_, err = os.Stat(filepath.Join(dir, ".index-state.json"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	report, err = repoIndexCli.RepoIndex(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Hashed, 0)
+	assert.Equal(t, report.Reused, 1)
+}
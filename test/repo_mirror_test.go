@@ -0,0 +1,103 @@
+package test
+
+import (
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoMirror(t *testing.T) {
+	upstream := helmclient.NewMemoryChartStorage()
+	for _, chart := range [][2]string{{"hello", "0.1.0"}, {"hello", "0.2.0"}, {"other", "1.0.0"}} {
+		archive := packageChart(t, chart[0], chart[1])
+		data, err := ioutil.ReadFile(archive)
+		assert.NilError(t, err)
+		assert.NilError(t, upstream.Put(filepath.Base(archive), data))
+		if chart[1] == "0.2.0" {
+			assert.NilError(t, upstream.Put(filepath.Base(archive)+".prov", signChart(t, archive)))
+		}
+	}
+	server := httptest.NewServer(helmclient.NewRepoServer(upstream, []helmclient.RepoServerOption{
+		helmclient.RepoServerWithProvenance(true),
+	}))
+	defer server.Close()
+
+	cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{
+		helmclient.WithRepositoryCache(t.TempDir()),
+	}, []helmclient.ClientOption{helmclient.ClientWithRepoStore(helmclient.NewMemoryRepoStore())})
+	repoAddCli, err := cli.RepoAdd([]helmclient.RepoAddOption{})
+	assert.NilError(t, err)
+	assert.NilError(t, repoAddCli.RepoAdd([]string{"upstream", server.URL}))
+	repoMirrorCli, err := cli.RepoMirror([]helmclient.RepoMirrorOption{
+		helmclient.RepoMirrorWithChart("upstream/hello", ">=0.2.0"),
+		helmclient.RepoMirrorWithChart("*/oth*", ""),
+		helmclient.RepoMirrorWithUrl("https://mirror.example.com"),
+	})
+	assert.NilError(t, err)
+
+	t.Run("directory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "mirror")
+		report, err := repoMirrorCli.RepoMirror(dir)
+		assert.NilError(t, err)
+		assert.Equal(t, len(report.Results), 2)
+		assert.Equal(t, report.Results[0].File, "hello-0.2.0.tgz")
+		assert.Equal(t, report.Results[0].Status, helmclient.RepoMirrorFetched)
+		assert.Assert(t, report.Results[0].Provenance)
+		assert.Equal(t, report.Results[1].File, "other-1.0.0.tgz")
+		assert.Equal(t, report.Results[1].Status, helmclient.RepoMirrorFetched)
+		assert.Assert(t, !report.Results[1].Provenance)
+		_, err = os.Stat(filepath.Join(dir, "hello-0.2.0.tgz.prov"))
+		assert.NilError(t, err)
+		// only what is served is written to the mirror
+		files, err := ioutil.ReadDir(dir)
+		assert.NilError(t, err)
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		assert.DeepEqual(t, names, []string{"hello-0.2.0.tgz", "hello-0.2.0.tgz.prov", "index.yaml", "other-1.0.0.tgz"})
+
+		index, err := repo.LoadIndexFile(filepath.Join(dir, "index.yaml"))
+		assert.NilError(t, err)
+		assert.Assert(t, !index.Has("hello", "0.1.0"))
+		hello, err := index.Get("hello", "0.2.0")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, hello.URLs, []string{"https://mirror.example.com/hello-0.2.0.tgz"})
+		assert.Equal(t, hello.Digest, report.Results[0].Version.Digest)
+
+		// mirroring again downloads nothing
+		report, err = repoMirrorCli.RepoMirror(dir)
+		assert.NilError(t, err)
+		for _, result := range report.Results {
+			assert.Equal(t, result.Status, helmclient.RepoMirrorUnchanged)
+		}
+		assert.Assert(t, report.Results[0].Provenance)
+		index, err = repo.LoadIndexFile(filepath.Join(dir, "index.yaml"))
+		assert.NilError(t, err)
+		again, err := index.Get("hello", "0.2.0")
+		assert.NilError(t, err)
+		assert.Assert(t, again.Created.Equal(hello.Created))
+	})
+	t.Run("storage", func(t *testing.T) {
+		storage := helmclient.NewMemoryChartStorage()
+		report, err := repoMirrorCli.RepoMirrorToStorage(storage)
+		assert.NilError(t, err)
+		assert.Equal(t, len(report.Results), 2)
+		other, err := report.Index.Get("other", "1.0.0")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, other.URLs, []string{"https://mirror.example.com/charts/other-1.0.0.tgz"})
+		_, err = storage.Get("hello-0.2.0.tgz.prov")
+		assert.NilError(t, err)
+	})
+	t.Run("invalid selection", func(t *testing.T) {
+		invalidCli, err := cli.RepoMirror([]helmclient.RepoMirrorOption{helmclient.RepoMirrorWithChart("hello", "")})
+		assert.NilError(t, err)
+		_, err = invalidCli.RepoMirror(t.TempDir())
+		assert.ErrorContains(t, err, "expected repo/chart")
+	})
+}
@@ -0,0 +1,115 @@
+package test
+
+import (
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneIndex(t *testing.T) {
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	index := repo.NewIndexFile()
+	add := func(name string, version string, age time.Duration) {
+		index.Entries[name] = append(index.Entries[name], &repo.ChartVersion{
+			Metadata: &chart.Metadata{Name: name, Version: version},
+			Created:  now.Add(-age),
+		})
+	}
+	add("hello", "0.1.0", 90*day)
+	add("hello", "0.2.0", 60*day)
+	add("hello", "0.3.0-rc.1", 40*day)
+	add("hello", "0.3.0", 30*day)
+	add("hello", "0.4.0", 20*day)
+	add("hello", "0.5.0-rc.1", 2*day)
+	add("other", "1.0.0", 100*day)
+	deployed := []*release.Release{{Chart: &chart.Chart{Metadata: &chart.Metadata{Name: "hello", Version: "0.1.0"}}}}
+
+	pruned, removed := helmclient.PruneIndex(index, []helmclient.RetentionOption{
+		helmclient.RetentionWithKeepLast(2),
+		helmclient.RetentionWithKeepNewerThan(35 * day),
+		helmclient.RetentionWithPrereleaseMaxAge(7 * day),
+		helmclient.RetentionWithDeployedReleases(deployed),
+		helmclient.RetentionWithTime(now),
+	})
+	versions := func(cvs []*repo.ChartVersion) []string {
+		var s []string
+		for _, cv := range cvs {
+			s = append(s, cv.Name+"-"+cv.Version)
+		}
+		return s
+	}
+	assert.DeepEqual(t, versions(pruned.Entries["hello"]), []string{"hello-0.5.0-rc.1", "hello-0.4.0", "hello-0.3.0", "hello-0.1.0"})
+	assert.DeepEqual(t, versions(pruned.Entries["other"]), []string{"other-1.0.0"})
+	assert.DeepEqual(t, versions(removed), []string{"hello-0.3.0-rc.1", "hello-0.2.0"})
+	// the index itself is not changed
+	assert.Equal(t, len(index.Entries["hello"]), 6)
+
+	// without policies only the old pre-releases are dropped
+	_, removed = helmclient.PruneIndex(index, []helmclient.RetentionOption{
+		helmclient.RetentionWithPrereleaseMaxAge(7 * day),
+		helmclient.RetentionWithTime(now),
+	})
+	assert.DeepEqual(t, versions(removed), []string{"hello-0.3.0-rc.1"})
+}
+
+func TestRepoPrune(t *testing.T) {
+	dir := t.TempDir()
+	for _, version := range []string{"0.1.0", "0.2.0", "0.3.0"} {
+		data, err := ioutil.ReadFile(packageChart(t, "hello", version))
+		assert.NilError(t, err)
+		assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "hello-"+version+".tgz"), data, 0644))
+	}
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "hello-0.1.0.tgz.prov"), []byte("signature"), 0644))
+
+	cli := helmclient.NewHelmClientWithGlobalOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{
+		helmclient.WithRepositoryCache(t.TempDir()),
+	})
+	dryRunCli, err := cli.RepoIndex([]helmclient.RepoIndexOption{
+		helmclient.RepoIndexWithRetention([]helmclient.RetentionOption{helmclient.RetentionWithKeepLast(1)}),
+		helmclient.RepoIndexWithDryRun(true),
+	})
+	assert.NilError(t, err)
+	result, err := dryRunCli.RepoPrune(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(result.Removed), 2)
+	assert.DeepEqual(t, result.Archives, []string{
+		filepath.Join(dir, "hello-0.1.0.tgz"),
+		filepath.Join(dir, "hello-0.1.0.tgz.prov"),
+		filepath.Join(dir, "hello-0.2.0.tgz"),
+	})
+	assert.Assert(t, result.Index.Has("hello", "0.3.0"))
+	_, err = os.Stat(filepath.Join(dir, "index.yaml"))
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "hello-0.1.0.tgz"))
+	assert.NilError(t, err)
+
+	repoIndexCli, err := cli.RepoIndex([]helmclient.RepoIndexOption{
+		helmclient.RepoIndexWithRetention([]helmclient.RetentionOption{helmclient.RetentionWithKeepLast(1)}),
+	})
+	assert.NilError(t, err)
+	result, err = repoIndexCli.RepoPrune(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(result.Archives), 3)
+	for _, archive := range result.Archives {
+		_, err = os.Stat(archive)
+		assert.Assert(t, os.IsNotExist(err))
+	}
+	index, err := repo.LoadIndexFile(filepath.Join(dir, "index.yaml"))
+	assert.NilError(t, err)
+	assert.Assert(t, index.Has("hello", "0.3.0"))
+	assert.Assert(t, !index.Has("hello", "0.2.0"))
+
+	// the pruned directory indexes without changes
+	report, err := repoIndexCli.RepoIndex(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Reused, 1)
+	assert.Equal(t, len(report.Added)+len(report.Removed)+len(report.Changed), 0)
+}
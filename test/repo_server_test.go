@@ -0,0 +1,220 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	helmclient "github.com/outgnaY/helm-go-client"
+	"golang.org/x/crypto/openpgp"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"testing"
+)
+
+// packageChart builds and packages a chart, returning the path of the archive.
+func packageChart(t *testing.T, name string, version string) string {
+	ch, err := helmclient.BuildChart(name, version, []helmclient.BuildChartOption{
+		helmclient.BuildChartWithTemplate("configmap.yaml", []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: "+name+"\n")),
+	})
+	assert.NilError(t, err)
+	archive, err := chartutil.Save(ch, t.TempDir())
+	assert.NilError(t, err)
+	return archive
+}
+
+// signChart makes a provenance file for the archive with a new key.
+func signChart(t *testing.T, archive string) []byte {
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	assert.NilError(t, err)
+	signatory := &provenance.Signatory{Entity: entity}
+	prov, err := signatory.ClearSign(archive)
+	assert.NilError(t, err)
+	return []byte(prov)
+}
+
+func doRequest(t *testing.T, method string, url string, contentType string, body []byte, auth ...string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	assert.NilError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if len(auth) == 2 {
+		req.SetBasicAuth(auth[0], auth[1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NilError(t, err)
+	return resp, data
+}
+
+func multipartUpload(t *testing.T, fields map[string][]byte) (string, []byte) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for field, data := range fields {
+		part, err := w.CreateFormFile(field, field)
+		assert.NilError(t, err)
+		_, err = part.Write(data)
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, w.Close())
+	return w.FormDataContentType(), body.Bytes()
+}
+
+func loadServedIndex(t *testing.T, url string) *repo.IndexFile {
+	resp, data := doRequest(t, http.MethodGet, url+"/index.yaml", "", nil)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	index := repo.NewIndexFile()
+	assert.NilError(t, yaml.Unmarshal(data, index))
+	return index
+}
+
+func TestRepoServer(t *testing.T) {
+	storage := helmclient.NewMemoryChartStorage()
+	server := httptest.NewServer(helmclient.NewRepoServer(storage, []helmclient.RepoServerOption{
+		helmclient.RepoServerWithProvenance(true),
+		helmclient.RepoServerWithWriteAuth(helmclient.StaticBasicAuth("admin", "secret")),
+	}))
+	defer server.Close()
+	first := packageChart(t, "hello", "0.1.0")
+	firstData, err := ioutil.ReadFile(first)
+	assert.NilError(t, err)
+
+	t.Run("upload", func(t *testing.T) {
+		resp, _ := doRequest(t, http.MethodPost, server.URL+"/api/charts", "application/gzip", firstData)
+		assert.Equal(t, resp.StatusCode, http.StatusUnauthorized)
+		resp, body := doRequest(t, http.MethodPost, server.URL+"/api/charts", "application/gzip", firstData, "admin", "secret")
+		assert.Equal(t, resp.StatusCode, http.StatusCreated, string(body))
+		resp, body = doRequest(t, http.MethodPost, server.URL+"/api/charts", "application/gzip", firstData, "admin", "secret")
+		assert.Equal(t, resp.StatusCode, http.StatusConflict)
+		assert.Equal(t, string(body), "{\"error\":\"file already exists\"}\n")
+
+		index := loadServedIndex(t, server.URL)
+		version, err := index.Get("hello", "0.1.0")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, version.URLs, []string{"charts/hello-0.1.0.tgz"})
+		digest, err := provenance.DigestFile(first)
+		assert.NilError(t, err)
+		assert.Equal(t, version.Digest, digest)
+	})
+	t.Run("upload with provenance", func(t *testing.T) {
+		second := packageChart(t, "hello", "0.2.0")
+		secondData, err := ioutil.ReadFile(second)
+		assert.NilError(t, err)
+		prov := signChart(t, second)
+		contentType, body := multipartUpload(t, map[string][]byte{"chart": secondData, "prov": prov})
+		resp, data := doRequest(t, http.MethodPost, server.URL+"/api/charts", contentType, body, "admin", "secret")
+		assert.Equal(t, resp.StatusCode, http.StatusCreated, string(data))
+		resp, data = doRequest(t, http.MethodGet, server.URL+"/charts/hello-0.2.0.tgz.prov", "", nil)
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+		assert.DeepEqual(t, data, prov)
+
+		// a provenance file alone is stored for the chart it was made for
+		third := packageChart(t, "other", "1.0.0")
+		resp, data = doRequest(t, http.MethodPost, server.URL+"/api/prov", "application/pgp-signature", signChart(t, third), "admin", "secret")
+		assert.Equal(t, resp.StatusCode, http.StatusCreated, string(data))
+		_, err = storage.Get("other-1.0.0.tgz.prov")
+		assert.NilError(t, err)
+	})
+	t.Run("chart API", func(t *testing.T) {
+		resp, data := doRequest(t, http.MethodGet, server.URL+"/api/charts/hello/latest", "", nil)
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+		var version repo.ChartVersion
+		assert.NilError(t, json.Unmarshal(data, &version))
+		assert.Equal(t, version.Version, "0.2.0")
+		resp, _ = doRequest(t, http.MethodGet, server.URL+"/api/charts/missing", "", nil)
+		assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+		resp, data = doRequest(t, http.MethodGet, server.URL+"/health", "", nil)
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+		assert.Equal(t, string(data), "{\"healthy\":true}\n")
+	})
+	t.Run("pull from the server", func(t *testing.T) {
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{
+			helmclient.WithRepositoryCache(t.TempDir()),
+		}, []helmclient.ClientOption{helmclient.ClientWithRepoStore(helmclient.NewMemoryRepoStore())})
+		repoAddCli, err := cli.RepoAdd([]helmclient.RepoAddOption{})
+		assert.NilError(t, err)
+		assert.NilError(t, repoAddCli.RepoAdd([]string{"local", server.URL}))
+		dest := t.TempDir()
+		pullCli, err := cli.Pull([]helmclient.PullOption{helmclient.PullWithDestDir(dest)}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		assert.NilError(t, pullCli.Pull([]string{"local/hello"}))
+		_, err = os.Stat(filepath.Join(dest, "hello-0.2.0.tgz"))
+		assert.NilError(t, err)
+	})
+	t.Run("delete", func(t *testing.T) {
+		resp, _ := doRequest(t, http.MethodDelete, server.URL+"/api/charts/hello/0.2.0", "", nil)
+		assert.Equal(t, resp.StatusCode, http.StatusUnauthorized)
+		resp, data := doRequest(t, http.MethodDelete, server.URL+"/api/charts/hello/0.2.0", "", nil, "admin", "secret")
+		assert.Equal(t, resp.StatusCode, http.StatusOK, string(data))
+		resp, _ = doRequest(t, http.MethodDelete, server.URL+"/api/charts/hello/0.2.0", "", nil, "admin", "secret")
+		assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+		_, err := storage.Get("hello-0.2.0.tgz.prov")
+		assert.Assert(t, os.IsNotExist(err))
+		index := loadServedIndex(t, server.URL)
+		assert.Assert(t, !index.Has("hello", "0.2.0"))
+		assert.Assert(t, index.Has("hello", "0.1.0"))
+	})
+}
+
+func TestRepoServerDirectory(t *testing.T) {
+	dir := t.TempDir()
+	archive := packageChart(t, "hello", "0.1.0")
+	data, err := ioutil.ReadFile(archive)
+	assert.NilError(t, err)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "hello-0.1.0.tgz"), data, 0644))
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "hello-0.1.0.tgz.prov"), []byte("not served"), 0644))
+	server := httptest.NewServer(helmclient.NewRepoServer(helmclient.NewDirChartStorage(dir), []helmclient.RepoServerOption{
+		helmclient.RepoServerWithURL("https://charts.example.com/"),
+		helmclient.RepoServerWithReadAuth(helmclient.StaticBasicAuth("reader", "secret")),
+	}))
+	defer server.Close()
+
+	resp, _ := doRequest(t, http.MethodGet, server.URL+"/index.yaml", "", nil)
+	assert.Equal(t, resp.StatusCode, http.StatusUnauthorized)
+	assert.Equal(t, resp.Header.Get("WWW-Authenticate"), `Basic realm="chart repository"`)
+
+	resp, body := doRequest(t, http.MethodGet, server.URL+"/index.yaml", "", nil, "reader", "secret")
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	index := repo.NewIndexFile()
+	assert.NilError(t, yaml.Unmarshal(body, index))
+	version, err := index.Get("hello", "0.1.0")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, version.URLs, []string{"https://charts.example.com/charts/hello-0.1.0.tgz"})
+
+	// the cached index is served conditionally
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/index.yaml", nil)
+	assert.NilError(t, err)
+	req.SetBasicAuth("reader", "secret")
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusNotModified)
+
+	// provenance files are only served when enabled
+	resp, _ = doRequest(t, http.MethodGet, server.URL+"/charts/hello-0.1.0.tgz.prov", "", nil, "reader", "secret")
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+	resp, _ = doRequest(t, http.MethodGet, server.URL+"/charts/hello-0.1.0.tgz", "", nil, "reader", "secret")
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+
+	// a chart copied to the directory shows up in the index
+	other := packageChart(t, "other", "1.0.0")
+	data, err = ioutil.ReadFile(other)
+	assert.NilError(t, err)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "other-1.0.0.tgz"), data, 0644))
+	resp, body = doRequest(t, http.MethodGet, server.URL+"/index.yaml", "", nil, "reader", "secret")
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.NilError(t, yaml.Unmarshal(body, index))
+	assert.Assert(t, index.Has("other", "1.0.0"))
+	assert.Assert(t, index.Has("hello", "0.1.0"))
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"context"
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/repo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRepoStore(t *testing.T) {
+	server := newAuthRepoServer(t, "secret")
+	credentials := helmclient.ClientWithCredentialProvider(helmclient.NewStaticCredentialProvider(map[string]helmclient.RepoCredentials{
+		"private": {Username: "user", Password: "secret"},
+	}))
+
+	t.Run("memory store shared by clients", func(t *testing.T) {
+		home := t.TempDir()
+		repoFile := filepath.Join(home, "repositories.yaml")
+		store := helmclient.NewMemoryRepoStore()
+		newClient := func() helmclient.HelmClient {
+			// every client has its own cache, like replicas without a shared disk
+			return helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{
+				helmclient.WithRepositoryConfig(repoFile),
+				helmclient.WithRepositoryCache(t.TempDir()),
+			}, []helmclient.ClientOption{credentials, helmclient.ClientWithRepoStore(store)})
+		}
+
+		repoListCli, err := newClient().RepoList()
+		assert.NilError(t, err)
+		_, err = repoListCli.RepoList()
+		assert.Error(t, err, "no repositories to show")
+
+		repoAddCli, err := newClient().RepoAdd([]helmclient.RepoAddOption{})
+		assert.NilError(t, err)
+		assert.NilError(t, repoAddCli.RepoAdd([]string{"private", server.URL}))
+		_, err = os.Stat(repoFile)
+		assert.Assert(t, os.IsNotExist(err), "the repositories file is not written")
+
+		other := newClient()
+		repoListCli, err = other.RepoList()
+		assert.NilError(t, err)
+		entries, err := repoListCli.RepoList()
+		assert.NilError(t, err)
+		assert.Equal(t, len(entries), 1)
+		assert.Equal(t, entries[0].URL, server.URL)
+
+		repoUpdateCli, err := other.RepoUpdate([]helmclient.RepoUpdateOption{})
+		assert.NilError(t, err)
+		_, err = repoUpdateCli.RepoUpdate([]string{})
+		assert.NilError(t, err)
+		searchRepoCli, err := other.SearchRepo([]helmclient.SearchRepoOption{})
+		assert.NilError(t, err)
+		results, err := searchRepoCli.SearchRepo([]string{"hello"})
+		assert.NilError(t, err)
+		assert.Equal(t, len(results.Results), 1)
+		assert.Equal(t, results.Results[0].Name, "private/hello")
+
+		dest := t.TempDir()
+		pullCli, err := other.Pull([]helmclient.PullOption{helmclient.PullWithDestDir(dest)}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		assert.NilError(t, pullCli.Pull([]string{"private/hello"}))
+		_, err = os.Stat(filepath.Join(dest, "hello-0.1.0.tgz"))
+		assert.NilError(t, err)
+
+		templateCli, err := other.Template([]helmclient.TemplateOption{}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		assert.NilError(t, templateCli.Template([]string{"private/hello"}))
+
+		repoRemoveCli, err := newClient().RepoRemove()
+		assert.NilError(t, err)
+		assert.NilError(t, repoRemoveCli.RepoRemove([]string{"private"}))
+		f, err := store.Load()
+		assert.NilError(t, err)
+		assert.Equal(t, len(f.Repositories), 0)
+		err = repoRemoveCli.RepoRemove([]string{"private"})
+		assert.Error(t, err, "no repositories configured")
+	})
+	t.Run("file store", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "nested", "repositories.yaml")
+		store := helmclient.NewFileRepoStore(path)
+		testRepoStoreUpdates(t, store)
+		f, err := repo.LoadFile(path)
+		assert.NilError(t, err)
+		assert.Equal(t, len(f.Repositories), 8)
+	})
+	t.Run("secret store", func(t *testing.T) {
+		client := fake.NewSimpleClientset()
+		store := helmclient.NewSecretRepoStore(client, "helm", "repositories")
+		testRepoStoreUpdates(t, store)
+		secret, err := client.CoreV1().Secrets("helm").Get(context.Background(), "repositories", metav1.GetOptions{})
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(string(secret.Data["repositories.yaml"]), "repo-7"))
+
+		// another replica sees the same repositories
+		f, err := helmclient.NewSecretRepoStore(client, "helm", "repositories").Load()
+		assert.NilError(t, err)
+		assert.Equal(t, len(f.Repositories), 8)
+	})
+	t.Run("config map store", func(t *testing.T) {
+		client := fake.NewSimpleClientset()
+		store := helmclient.NewConfigMapRepoStore(client, "helm", "repositories")
+		testRepoStoreUpdates(t, store)
+		configMap, err := client.CoreV1().ConfigMaps("helm").Get(context.Background(), "repositories", metav1.GetOptions{})
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(configMap.Data["repositories.yaml"], "repo-7"))
+	})
+}
+
+// testRepoStoreUpdates checks that store starts empty and keeps concurrent
+// updates.
+func testRepoStoreUpdates(t *testing.T, store helmclient.RepoStore) {
+	_, err := store.Load()
+	assert.Assert(t, err != nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- store.Update(func(f *repo.File) error {
+				f.Update(&repo.Entry{Name: "repo-" + string(rune('0'+i)), URL: "https://example.com"})
+				return nil
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NilError(t, err)
+	}
+
+	f, err := store.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, len(f.Repositories), 8)
+	assert.Assert(t, f.Has("repo-0") && f.Has("repo-7"))
+}
@@ -1,17 +1,179 @@
 package test
 
 import (
+	"crypto/sha256"
+	"fmt"
 	helmclient "github.com/outgnaY/helm-go-client"
 	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRepoUpdate(t *testing.T) {
 	t.Run("repo update", func(t *testing.T) {
 		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
-		repoUpdateCli, err := cli.RepoUpdate()
+		repoUpdateCli, err := cli.RepoUpdate([]helmclient.RepoUpdateOption{})
 		assert.Equal(t, err, nil)
-		err = repoUpdateCli.RepoUpdate()
+		_, err = repoUpdateCli.RepoUpdate([]string{})
 		assert.Equal(t, err, nil)
 	})
 }
+
+// indexServer serves an index.yaml with an ETag and counts full downloads.
+type indexServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	index     []byte
+	downloads int
+}
+
+func (s *indexServer) setIndex(t *testing.T, charts ...string) {
+	index := repo.NewIndexFile()
+	for _, name := range charts {
+		assert.NilError(t, index.MustAdd(&chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: "0.1.0"}, name+"-0.1.0.tgz", s.URL, "sha256:0"))
+	}
+	data, err := yaml.Marshal(index)
+	assert.NilError(t, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index = data
+}
+
+func newIndexServer(t *testing.T, charts ...string) *indexServer {
+	s := &indexServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		index := s.index
+		s.mu.Unlock()
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(index))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		s.mu.Lock()
+		s.downloads++
+		s.mu.Unlock()
+		w.Write(index)
+	}))
+	t.Cleanup(s.Close)
+	s.setIndex(t, charts...)
+	return s
+}
+
+func TestRepoUpdateResults(t *testing.T) {
+	first := newIndexServer(t, "alpha", "beta")
+	second := newIndexServer(t, "gamma")
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	store := helmclient.NewMemoryRepoStore()
+	assert.NilError(t, store.Update(func(f *repo.File) error {
+		f.Update(&repo.Entry{Name: "first", URL: first.URL}, &repo.Entry{Name: "second", URL: second.URL + "/"}, &repo.Entry{Name: "missing", URL: missing.URL})
+		return nil
+	}))
+	cache := t.TempDir()
+	cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{
+		helmclient.WithRepositoryCache(cache),
+	}, []helmclient.ClientOption{helmclient.ClientWithRepoStore(store)})
+	repoUpdateCli, err := cli.RepoUpdate([]helmclient.RepoUpdateOption{
+		helmclient.RepoUpdateWithConcurrency(1),
+		helmclient.RepoUpdateWithTimeout(10 * time.Second),
+	})
+	assert.NilError(t, err)
+
+	t.Run("all repositories", func(t *testing.T) {
+		results, err := repoUpdateCli.RepoUpdate([]string{})
+		assert.Error(t, err, "2 repository(s) updated, 0 unchanged, 1 failed")
+		assert.Equal(t, len(results), 3)
+		assert.Equal(t, results[0].Name, "first")
+		assert.Equal(t, results[0].Status, helmclient.RepoUpdateUpdated)
+		assert.Equal(t, results[0].Charts, 2)
+		assert.Equal(t, results[0].ChartVersions, 2)
+		info, err := os.Stat(filepath.Join(cache, "first-index.yaml"))
+		assert.NilError(t, err)
+		assert.Equal(t, results[0].IndexSize, info.Size())
+		assert.Equal(t, results[1].Status, helmclient.RepoUpdateUpdated)
+		assert.Equal(t, results[1].Charts, 1)
+		assert.Equal(t, results[2].Status, helmclient.RepoUpdateFailed)
+		assert.Assert(t, results[2].Err != nil && strings.Contains(results[2].Err.Error(), "404"), results[2].Err)
+	})
+	t.Run("unchanged index is not downloaded again", func(t *testing.T) {
+		results, err := repoUpdateCli.RepoUpdate([]string{"first", "second"})
+		assert.NilError(t, err)
+		assert.Equal(t, len(results), 2)
+		assert.Equal(t, results[0].Status, helmclient.RepoUpdateUnchanged)
+		assert.Equal(t, results[0].Charts, 2)
+		assert.Equal(t, results[1].Status, helmclient.RepoUpdateUnchanged)
+		assert.Equal(t, first.downloads, 1)
+		assert.Equal(t, second.downloads, 1)
+	})
+	t.Run("changed index is downloaded", func(t *testing.T) {
+		first.setIndex(t, "alpha", "beta", "delta")
+		results, err := repoUpdateCli.RepoUpdate([]string{"first"})
+		assert.NilError(t, err)
+		assert.Equal(t, len(results), 1)
+		assert.Equal(t, results[0].Status, helmclient.RepoUpdateUpdated)
+		assert.Equal(t, results[0].Charts, 3)
+		assert.Equal(t, first.downloads, 2)
+		charts, err := ioutil.ReadFile(filepath.Join(cache, "first-charts.txt"))
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(string(charts), "delta"))
+	})
+	t.Run("unchanged repositories are counted apart", func(t *testing.T) {
+		results, err := repoUpdateCli.RepoUpdate([]string{})
+		assert.Error(t, err, "0 repository(s) updated, 2 unchanged, 1 failed")
+		assert.Equal(t, len(results), 3)
+	})
+	t.Run("unknown repository", func(t *testing.T) {
+		_, err := repoUpdateCli.RepoUpdate([]string{"first", "unknown"})
+		assert.Error(t, err, "no repositories found matching 'unknown'. Nothing will be updated")
+	})
+}
+
+func TestRepoUpdatePassCredentialsAll(t *testing.T) {
+	index := newIndexServer(t, "alpha")
+	var mu sync.Mutex
+	var auth []string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auth = append(auth, r.Header.Get("Authorization"))
+		mu.Unlock()
+		index.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer mirror.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, mirror.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	update := func(passCredentialsAll bool) {
+		store := helmclient.NewMemoryRepoStore()
+		assert.NilError(t, store.Update(func(f *repo.File) error {
+			f.Update(&repo.Entry{Name: "private", URL: redirect.URL, Username: "user", Password: "secret", PassCredentialsAll: passCredentialsAll})
+			return nil
+		}))
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{
+			helmclient.WithRepositoryCache(t.TempDir()),
+		}, []helmclient.ClientOption{helmclient.ClientWithRepoStore(store)})
+		repoUpdateCli, err := cli.RepoUpdate([]helmclient.RepoUpdateOption{})
+		assert.NilError(t, err)
+		_, err = repoUpdateCli.RepoUpdate([]string{})
+		assert.NilError(t, err)
+	}
+	update(false)
+	update(true)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.DeepEqual(t, auth, []string{"", "Basic dXNlcjpzZWNyZXQ="})
+}
@@ -1,10 +1,16 @@
 package test
 
 import (
+	"errors"
 	helmclient "github.com/outgnaY/helm-go-client"
 	"fmt"
 	"gotest.tools/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestSearchHub(t *testing.T) {
@@ -17,3 +23,125 @@ func TestSearchHub(t *testing.T) {
 		fmt.Println(searchResults)
 	})
 }
+
+// hubStandIn serves the parts of the Artifact Hub API the hub client uses. It
+// records the query of the last search, for the test to check.
+type hubStandIn struct {
+	*httptest.Server
+	mu          sync.Mutex
+	searchQuery url.Values
+}
+
+func (s *hubStandIn) lastSearchQuery() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.searchQuery
+}
+
+func newHubStandIn() *hubStandIn {
+	s := &hubStandIn{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/packages/search", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.searchQuery = r.URL.Query()
+		s.mu.Unlock()
+		w.Header().Set("Pagination-Total-Count", "3")
+		fmt.Fprint(w, `{"packages":[{"package_id":"id-1","name":"nginx","version":"9.1.0","app_version":"1.21.1",`+
+			`"security_report_summary":{"high":2},"repository":{"kind":0,"name":"bitnami","verified_publisher":true}}]}`)
+	})
+	mux.HandleFunc("/api/v1/packages/helm/bitnami/nginx", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"package_id":"id-1","name":"nginx","version":"9.1.0","digest":"sha256:abc","has_values_schema":true,`+
+			`"available_versions":[{"version":"9.1.0","ts":1630000000},{"version":"9.0.0","prerelease":false}]}`)
+	})
+	mux.HandleFunc("/api/v1/packages/helm/bitnami/nginx/9.0.0", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"package_id":"id-1","name":"nginx","version":"9.0.0"}`)
+	})
+	mux.HandleFunc("/api/v1/packages/id-1/9.0.0/values", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "replicaCount: 1\n")
+	})
+	mux.HandleFunc("/api/v1/packages/id-1/9.1.0/values-schema", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"type":"object"}`)
+	})
+	mux.HandleFunc("/api/v1/packages/id-1/9.1.0/security-report", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"bitnami/nginx:1.21.1":{"Results":[{"Target":"debian","Vulnerabilities":[`+
+			`{"VulnerabilityID":"CVE-2021-0001","PkgName":"libc","Severity":"HIGH"}]}]}}`)
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func TestSearchHubArtifactHub(t *testing.T) {
+	server := newHubStandIn()
+	defer server.Close()
+	cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+	searchHubCli, err := cli.SearchHub([]helmclient.SearchHubOption{
+		helmclient.SearchHubWithSearchEndpoint(server.URL),
+		helmclient.SearchHubWithVerifiedPublisher(true),
+		helmclient.SearchHubWithRepositories([]string{"bitnami"}),
+		helmclient.SearchHubWithLimit(1),
+		helmclient.SearchHubWithOffset(1),
+	})
+	assert.NilError(t, err)
+
+	t.Run("search", func(t *testing.T) {
+		result, err := searchHubCli.SearchHub([]string{"nginx"})
+		assert.NilError(t, err)
+		query := server.lastSearchQuery()
+		assert.Equal(t, query.Get("ts_query_web"), "nginx")
+		assert.DeepEqual(t, query["kind"], []string{"0"})
+		assert.Equal(t, query.Get("verified_publisher"), "true")
+		assert.Equal(t, query.Get("official"), "")
+		assert.DeepEqual(t, query["repo"], []string{"bitnami"})
+		assert.Equal(t, query.Get("limit"), "1")
+		assert.Equal(t, query.Get("offset"), "1")
+		assert.Equal(t, result.Total, 3)
+		assert.Equal(t, len(result.Packages), 1)
+		assert.Equal(t, result.Packages[0].AppVersion, "1.21.1")
+		assert.Equal(t, result.Packages[0].SecurityReportSummary.High, 2)
+		assert.Assert(t, result.Packages[0].Repository.VerifiedPublisher)
+	})
+	t.Run("package", func(t *testing.T) {
+		p, err := searchHubCli.HubPackage("bitnami/nginx", "")
+		assert.NilError(t, err)
+		assert.Equal(t, p.Version, "9.1.0")
+		assert.Equal(t, p.Digest, "sha256:abc")
+		versions, err := searchHubCli.HubVersions("bitnami/nginx")
+		assert.NilError(t, err)
+		assert.Equal(t, len(versions), 2)
+		assert.Equal(t, versions[1].Version, "9.0.0")
+		_, err = searchHubCli.HubPackage("bitnami/missing", "")
+		assert.Assert(t, errors.Is(err, helmclient.ErrHubNotFound))
+		_, err = searchHubCli.HubPackage("nginx", "")
+		assert.ErrorContains(t, err, "expected repo/name")
+	})
+	t.Run("assets", func(t *testing.T) {
+		values, err := searchHubCli.HubValues("bitnami/nginx", "9.0.0")
+		assert.NilError(t, err)
+		assert.Equal(t, string(values), "replicaCount: 1\n")
+		schema, err := searchHubCli.HubValuesSchema("bitnami/nginx", "")
+		assert.NilError(t, err)
+		assert.Equal(t, string(schema), `{"type":"object"}`)
+		report, err := searchHubCli.HubSecurityReport("bitnami/nginx", "")
+		assert.NilError(t, err)
+		vulnerabilities := report["bitnami/nginx:1.21.1"].Results[0].Vulnerabilities
+		assert.Equal(t, len(vulnerabilities), 1)
+		assert.Equal(t, vulnerabilities[0].VulnerabilityID, "CVE-2021-0001")
+	})
+}
+
+func TestSearchHubTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+	searchHubCli, err := cli.SearchHub([]helmclient.SearchHubOption{
+		helmclient.SearchHubWithSearchEndpoint(server.URL),
+		helmclient.SearchHubWithTimeout(50 * time.Millisecond),
+	})
+	assert.NilError(t, err)
+	_, err = searchHubCli.SearchHub([]string{"nginx"})
+	assert.ErrorContains(t, err, "Client.Timeout exceeded")
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSearchRepoIndex(t *testing.T) {
+	cache := t.TempDir()
+	store := helmclient.NewMemoryRepoStore()
+	modTime := time.Now()
+	writeIndex := func(name string, charts ...[2]string) {
+		assert.NilError(t, store.Update(func(f *repo.File) error {
+			f.Update(&repo.Entry{Name: name, URL: "https://" + name + ".example.com"})
+			return nil
+		}))
+		index := repo.NewIndexFile()
+		for _, c := range charts {
+			index.Entries[c[0]] = append(index.Entries[c[0]], &repo.ChartVersion{
+				Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: c[0], Version: c[1], Description: c[0] + " chart"},
+			})
+		}
+		path := filepath.Join(cache, helmpath.CacheIndexFile(name))
+		assert.NilError(t, index.WriteFile(path, 0644))
+		// make sure the change is seen even with a coarse modification time
+		modTime = modTime.Add(time.Second)
+		assert.NilError(t, os.Chtimes(path, modTime, modTime))
+	}
+	writeIndex("stable", [2]string{"nginx", "1.0.0"}, [2]string{"nginx", "1.1.0"}, [2]string{"nats", "2.0.0"})
+	writeIndex("incubator", [2]string{"nginx-ingress", "0.1.0"})
+
+	cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{
+		helmclient.WithRepositoryCache(cache),
+	}, []helmclient.ClientOption{helmclient.ClientWithRepoStore(store)})
+
+	t.Run("search and complete", func(t *testing.T) {
+		index, err := cli.SearchRepoIndex([]helmclient.SearchRepoIndexOption{helmclient.SearchRepoIndexWithRefreshInterval(0)})
+		assert.NilError(t, err)
+		defer index.Close()
+		results, err := index.Search([]string{"nginx"}, []helmclient.SearchRepoOption{helmclient.SearchRepoWithSort(helmclient.SearchRepoSortName)})
+		assert.NilError(t, err)
+		assert.Equal(t, results.Total, 2)
+		assert.Equal(t, results.Results[0].Name, "incubator/nginx-ingress")
+		assert.Equal(t, results.Results[1].Name, "stable/nginx")
+		assert.Equal(t, results.Results[1].Version, "1.1.0")
+
+		assert.DeepEqual(t, index.CompleteChart("stable/n"), []string{"stable/nats", "stable/nginx"})
+		assert.DeepEqual(t, index.CompleteChart("inc"), []string{"incubator/nginx-ingress"})
+		assert.Equal(t, len(index.CompleteChart("other")), 0)
+		assert.DeepEqual(t, index.CompleteVersion("stable/nginx", "1."), []string{"1.1.0", "1.0.0"})
+		assert.DeepEqual(t, index.CompleteVersion("stable/nginx", "1.0"), []string{"1.0.0"})
+
+		// changed indexes are read again when the index is refreshed
+		writeIndex("stable", [2]string{"nginx", "1.2.0"})
+		assert.DeepEqual(t, index.CompleteVersion("stable/nginx", ""), []string{"1.1.0", "1.0.0"})
+		assert.NilError(t, index.Refresh())
+		assert.DeepEqual(t, index.CompleteVersion("stable/nginx", ""), []string{"1.2.0"})
+		assert.DeepEqual(t, index.CompleteChart("stable/"), []string{"stable/nginx"})
+
+		// as are removed repositories
+		assert.NilError(t, store.Update(func(f *repo.File) error {
+			f.Remove("incubator")
+			return nil
+		}))
+		assert.NilError(t, index.Refresh())
+		assert.Equal(t, len(index.CompleteChart("incubator/")), 0)
+	})
+	t.Run("background refresh", func(t *testing.T) {
+		index, err := cli.SearchRepoIndex([]helmclient.SearchRepoIndexOption{helmclient.SearchRepoIndexWithRefreshInterval(10 * time.Millisecond)})
+		assert.NilError(t, err)
+		defer index.Close()
+		writeIndex("stable", [2]string{"nginx", "1.3.0"})
+		deadline := time.Now().Add(5 * time.Second)
+		for len(index.CompleteVersion("stable/nginx", "1.3")) == 0 {
+			assert.Assert(t, time.Now().Before(deadline), "the index was not refreshed")
+			time.Sleep(5 * time.Millisecond)
+		}
+		results, err := index.Search(nil, []helmclient.SearchRepoOption{})
+		assert.NilError(t, err)
+		assert.Equal(t, results.Results[0].Version, "1.3.0")
+	})
+}
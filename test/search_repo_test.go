@@ -3,8 +3,14 @@ package test
 import (
 	helmclient "github.com/outgnaY/helm-go-client"
 	"fmt"
+	"github.com/pkg/errors"
 	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestSearchRepo(t *testing.T) {
@@ -17,3 +23,117 @@ func TestSearchRepo(t *testing.T) {
 		fmt.Println(searchResults)
 	})
 }
+
+func TestSearchRepoFilters(t *testing.T) {
+	cache := t.TempDir()
+	store := helmclient.NewMemoryRepoStore()
+	created := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	writeIndex := func(name string, versions ...*repo.ChartVersion) {
+		assert.NilError(t, store.Update(func(f *repo.File) error {
+			f.Update(&repo.Entry{Name: name, URL: "https://" + name + ".example.com"})
+			return nil
+		}))
+		index := repo.NewIndexFile()
+		for _, cv := range versions {
+			index.Entries[cv.Name] = append(index.Entries[cv.Name], cv)
+		}
+		assert.NilError(t, index.WriteFile(filepath.Join(cache, helmpath.CacheIndexFile(name)), 0644))
+	}
+	chartVersion := func(name string, version string, appVersion string, age int, md func(*chart.Metadata)) *repo.ChartVersion {
+		cv := &repo.ChartVersion{
+			Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: name, Version: version, AppVersion: appVersion, Description: name + " chart"},
+			Created:  created.AddDate(0, 0, -age),
+			Digest:   "sha256:" + name + version,
+			URLs:     []string{name + "-" + version + ".tgz"},
+		}
+		if md != nil {
+			md(cv.Metadata)
+		}
+		return cv
+	}
+	web := func(md *chart.Metadata) {
+		md.Keywords = []string{"Web", "http"}
+		md.Maintainers = []*chart.Maintainer{{Name: "Jane", Email: "jane@example.com"}}
+		md.Annotations = map[string]string{"category": "web"}
+	}
+	writeIndex("one",
+		chartVersion("nginx", "1.0.0", "1.19.0", 30, web),
+		chartVersion("nginx", "1.1.0", "1.21.0", 10, web),
+		chartVersion("redis", "2.0.0", "6.2.0", 5, nil),
+		chartVersion("legacy", "0.1.0", "1.0.0", 1, func(md *chart.Metadata) { md.Deprecated = true }),
+	)
+	writeIndex("two", chartVersion("apache", "3.0.0", "2.4.0", 20, web))
+
+	cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{
+		helmclient.WithRepositoryCache(cache),
+	}, []helmclient.ClientOption{helmclient.ClientWithRepoStore(store)})
+	search := func(args []string, opts ...helmclient.SearchRepoOption) *helmclient.SearchRepoResults {
+		searchRepoCli, err := cli.SearchRepo(opts)
+		assert.NilError(t, err)
+		results, err := searchRepoCli.SearchRepo(args)
+		assert.NilError(t, err)
+		return results
+	}
+	names := func(results *helmclient.SearchRepoResults) []string {
+		var s []string
+		for _, r := range results.Results {
+			s = append(s, r.Name+"@"+r.Version)
+		}
+		return s
+	}
+
+	t.Run("sort", func(t *testing.T) {
+		results := search(nil, helmclient.SearchRepoWithSort(helmclient.SearchRepoSortName))
+		assert.DeepEqual(t, names(results), []string{"one/legacy@0.1.0", "one/nginx@1.1.0", "one/redis@2.0.0", "two/apache@3.0.0"})
+		results = search(nil, helmclient.SearchRepoWithSort(helmclient.SearchRepoSortNewest))
+		assert.DeepEqual(t, names(results), []string{"one/legacy@0.1.0", "one/redis@2.0.0", "one/nginx@1.1.0", "two/apache@3.0.0"})
+		nginx := results.Results[2]
+		assert.Equal(t, nginx.Repo, "one")
+		assert.Equal(t, nginx.AppVersion, "1.21.0")
+		assert.Equal(t, nginx.Description, "nginx chart")
+		assert.Equal(t, nginx.Digest, "sha256:nginx1.1.0")
+		assert.DeepEqual(t, nginx.URLs, []string{"nginx-1.1.0.tgz"})
+		assert.Assert(t, nginx.Created.Equal(created.AddDate(0, 0, -10)))
+		// the chart name is a better match than the description
+		results = search([]string{"redis"}, helmclient.SearchRepoWithMaxScore(5))
+		assert.DeepEqual(t, names(results), []string{"one/redis@2.0.0"})
+	})
+	t.Run("filters", func(t *testing.T) {
+		results := search(nil, helmclient.SearchRepoWithKeywords([]string{"web"}), helmclient.SearchRepoWithSort(helmclient.SearchRepoSortName))
+		assert.DeepEqual(t, names(results), []string{"one/nginx@1.1.0", "two/apache@3.0.0"})
+		results = search(nil, helmclient.SearchRepoWithAnnotation("category", "web"), helmclient.SearchRepoWithRepos([]string{"two"}))
+		assert.DeepEqual(t, names(results), []string{"two/apache@3.0.0"})
+		results = search(nil, helmclient.SearchRepoWithMaintainer("JANE@example.com"), helmclient.SearchRepoWithAppVersion("<1.20.0"))
+		assert.DeepEqual(t, names(results), []string{"one/nginx@1.0.0"})
+		results = search(nil, helmclient.SearchRepoWithDeprecated(true))
+		assert.DeepEqual(t, names(results), []string{"one/legacy@0.1.0"})
+		results = search(nil, helmclient.SearchRepoWithDeprecated(false))
+		assert.Equal(t, results.Total, 3)
+	})
+	t.Run("paging", func(t *testing.T) {
+		results := search(nil, helmclient.SearchRepoWithSort(helmclient.SearchRepoSortName), helmclient.SearchRepoWithOffset(1), helmclient.SearchRepoWithLimit(2))
+		assert.Equal(t, results.Total, 4)
+		assert.DeepEqual(t, names(results), []string{"one/nginx@1.1.0", "one/redis@2.0.0"})
+		results = search(nil, helmclient.SearchRepoWithOffset(10))
+		assert.Equal(t, results.Total, 4)
+		assert.Equal(t, len(results.Results), 0)
+	})
+}
+
+// brokenRepoStore fails to load the repositories.
+type brokenRepoStore struct {
+	helmclient.RepoStore
+}
+
+func (brokenRepoStore) Load() (*repo.File, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func TestSearchRepoStoreError(t *testing.T) {
+	cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{},
+		[]helmclient.ClientOption{helmclient.ClientWithRepoStore(brokenRepoStore{})})
+	searchRepoCli, err := cli.SearchRepo([]helmclient.SearchRepoOption{})
+	assert.NilError(t, err)
+	_, err = searchRepoCli.SearchRepo([]string{})
+	assert.Error(t, err, "store unavailable")
+}
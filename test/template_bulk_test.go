@@ -0,0 +1,79 @@
+package test
+
+import (
+	"fmt"
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"strings"
+	"testing"
+)
+
+const configMapForBulkTest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+  namespace: {{ .Release.Namespace }}
+data:
+  tenant: {{ required "tenant is required" .Values.tenant | quote }}
+`
+
+const subchartConfigMapForBulkTest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-cache
+`
+
+func TestBulkRender(t *testing.T) {
+	cache, err := helmclient.BuildChart("cache", "0.1.0", []helmclient.BuildChartOption{
+		helmclient.BuildChartWithTemplate("configmap.yaml", []byte(subchartConfigMapForBulkTest)),
+	})
+	assert.NilError(t, err)
+	ch, err := helmclient.BuildChart("tenant", "0.1.0", []helmclient.BuildChartOption{
+		helmclient.BuildChartWithTemplate("configmap.yaml", []byte(configMapForBulkTest)),
+		helmclient.BuildChartWithDependency(&chart.Dependency{Name: "cache", Version: "0.1.0", Condition: "cache.enabled"}, cache),
+	})
+	assert.NilError(t, err)
+
+	t.Run("render", func(t *testing.T) {
+		var requests []helmclient.BulkRenderRequest
+		for i := 0; i < 20; i++ {
+			requests = append(requests, helmclient.BulkRenderRequest{
+				ReleaseName: fmt.Sprintf("tenant-%d", i),
+				Namespace:   fmt.Sprintf("ns-%d", i),
+				ValueOpts: []helmclient.ValueOption{helmclient.WithValues([]string{
+					fmt.Sprintf("tenant=t%d", i),
+					fmt.Sprintf("cache.enabled=%t", i%2 == 0),
+				})},
+			})
+		}
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		templateCli, err := cli.Template([]helmclient.TemplateOption{helmclient.TemplateWithConcurrency(4)}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		results, err := templateCli.BulkRenderChart(helmclient.ChartFromObject(ch), requests)
+		assert.NilError(t, err)
+		assert.Equal(t, len(results), len(requests))
+		for i, result := range results {
+			assert.NilError(t, result.Err)
+			assert.Equal(t, result.Request.ReleaseName, fmt.Sprintf("tenant-%d", i))
+			assert.Assert(t, strings.Contains(result.Manifest, fmt.Sprintf("namespace: ns-%d", i)), result.Manifest)
+			assert.Assert(t, strings.Contains(result.Manifest, fmt.Sprintf(`tenant: "t%d"`, i)), result.Manifest)
+			assert.Equal(t, strings.Contains(result.Manifest, fmt.Sprintf("name: tenant-%d-cache", i)), i%2 == 0, result.Manifest)
+			// the release keeps the templates of the chart
+			assert.Equal(t, string(result.Release.Chart.Templates[0].Data), configMapForBulkTest)
+		}
+	})
+	t.Run("failed", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		templateCli, err := cli.Template([]helmclient.TemplateOption{}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		results, err := templateCli.BulkRenderChart(helmclient.ChartFromObject(ch), []helmclient.BulkRenderRequest{
+			{ValueOpts: []helmclient.ValueOption{helmclient.WithValues([]string{"tenant=a"})}},
+			{},
+		})
+		assert.Error(t, err, "2 release(s) rendered, 1 failed")
+		assert.NilError(t, results[0].Err)
+		assert.Assert(t, strings.Contains(results[0].Manifest, "name: RELEASE-NAME"), results[0].Manifest)
+		assert.ErrorContains(t, results[1].Err, "tenant is required")
+	})
+}
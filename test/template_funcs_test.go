@@ -0,0 +1,81 @@
+package test
+
+import (
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+const configMapForTemplateFuncsTest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+data:
+  greeting: {{ exclaim .Values.greeting | quote }}
+  literal: {{ "{{ not a template }}" | quote }}
+  {{- $cm := lookup "v1" "ConfigMap" .Release.Namespace "existing" }}
+  existing: {{ if $cm }}{{ exclaim $cm.metadata.name | quote }}{{ else }}"none"{{ end }}
+`
+
+var templateFuncsForTest = template.FuncMap{
+	"exclaim": func(s string) string { return s + "!" },
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	ch, err := helmclient.BuildChart("hello", "0.1.0", []helmclient.BuildChartOption{
+		helmclient.BuildChartWithValues(map[string]interface{}{"greeting": "hello"}),
+		helmclient.BuildChartWithTemplate("configmap.yaml", []byte(configMapForTemplateFuncsTest)),
+	})
+	assert.NilError(t, err)
+	existing := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "existing", "namespace": "default"},
+	}}
+
+	t.Run("install", func(t *testing.T) {
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{}, []helmclient.ClientOption{
+			helmclient.ClientWithTemplateFuncs(templateFuncsForTest),
+		})
+		installCli, err := cli.Install([]helmclient.InstallOption{
+			helmclient.InstallWithDryRun(true),
+			helmclient.InstallWithFakeCluster(helmclient.NewFakeCluster([]*unstructured.Unstructured{existing})),
+		}, []helmclient.ValueOption{}, []helmclient.ChartPathOption{})
+		assert.NilError(t, err)
+		rel, err := installCli.InstallChart("hello", helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(rel.Manifest, `greeting: "hello!"`), rel.Manifest)
+		assert.Assert(t, strings.Contains(rel.Manifest, `literal: "{{ not a template }}"`), rel.Manifest)
+		assert.Assert(t, strings.Contains(rel.Manifest, `existing: "existing!"`), rel.Manifest)
+		// the release keeps the templates of the chart
+		assert.Equal(t, string(rel.Chart.Templates[0].Data), configMapForTemplateFuncsTest)
+	})
+	t.Run("lint", func(t *testing.T) {
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{}, []helmclient.ClientOption{
+			helmclient.ClientWithTemplateFuncs(templateFuncsForTest),
+		})
+		lintCli, err := cli.Lint([]helmclient.LintOption{}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		result, err := lintCli.LintChart(helmclient.ChartFromObject(ch))
+		assert.NilError(t, err)
+		assert.Assert(t, !result.Failed, result.Messages)
+	})
+	t.Run("lint without funcs", func(t *testing.T) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		lintCli, err := cli.Lint([]helmclient.LintOption{}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		result, err := lintCli.LintChart(helmclient.ChartFromObject(ch))
+		assert.Error(t, err, "1 chart(s) linted, 1 chart(s) failed")
+		assert.Assert(t, result.Failed)
+	})
+	t.Run("conflict", func(t *testing.T) {
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{}, []helmclient.ClientOption{
+			helmclient.ClientWithTemplateFuncs(template.FuncMap{"upper": strings.ToUpper}),
+		})
+		_, err := cli.Template([]helmclient.TemplateOption{}, []helmclient.ValueOption{})
+		assert.Error(t, err, `template function "upper" conflicts with the built-in function of the same name`)
+	})
+}
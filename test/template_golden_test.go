@@ -0,0 +1,118 @@
+package test
+
+import (
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const goldenDeploymentForTest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}-hello
+  labels:
+    helm.sh/chart: {{ .Chart.Name }}-{{ .Chart.Version }}
+spec:
+  replicas: {{ .Values.replicas }}
+  template:
+    metadata:
+      annotations:
+        checksum/config: {{ randAlphaNum 16 | sha256sum }}
+    spec:
+      containers:
+        - name: hello
+          image: nginx
+          env:
+            - name: PASSWORD
+              value: {{ randAlphaNum 16 | quote }}
+`
+
+const goldenServiceForTest = `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name }}-hello
+`
+
+func TestTemplateGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golden")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"hello/Chart.yaml":                "apiVersion: v2\nname: hello\nversion: 0.1.0\n",
+		"hello/values.yaml":               "replicas: 1\n",
+		"hello/templates/deployment.yaml": goldenDeploymentForTest,
+		"hello/templates/service.yaml":    goldenServiceForTest,
+		"values/default.yaml":             "{}\n",
+		"values/ha.yml":                   "replicas: 3\n",
+		"values/README.md":                "not a values file\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NilError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	chartPath := filepath.Join(dir, "hello")
+	valuesDir := filepath.Join(dir, "values")
+	goldenDir := filepath.Join(dir, "golden")
+	masks := []string{
+		`metadata.labels["helm.sh/chart"]`,
+		`spec.template.metadata.annotations["checksum/*"]`,
+		`spec.template.spec.containers[*].env[*].value`,
+	}
+
+	golden := func(update bool) ([]*helmclient.GoldenResult, error) {
+		cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+		templateCli, err := cli.Template([]helmclient.TemplateOption{
+			helmclient.TemplateWithGoldenMasks(masks),
+			helmclient.TemplateWithGoldenUpdate(update),
+		}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		return templateCli.Golden([]string{chartPath}, valuesDir, goldenDir)
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		results, err := golden(false)
+		assert.ErrorContains(t, err, "2 values file(s) rendered, 2 differ from their golden files")
+		assert.Equal(t, len(results), 2)
+		assert.Equal(t, results[0].GoldenFile, filepath.Join(goldenDir, "default.golden.yaml"))
+		assert.Equal(t, results[1].GoldenFile, filepath.Join(goldenDir, "ha.golden.yaml"))
+		assert.Assert(t, strings.Contains(results[0].Diff, "+kind: Service"), results[0].Diff)
+	})
+	t.Run("update", func(t *testing.T) {
+		results, err := golden(true)
+		assert.NilError(t, err)
+		for _, result := range results {
+			assert.Assert(t, result.Updated)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(goldenDir, "ha.golden.yaml"))
+		assert.NilError(t, err)
+		output := string(data)
+		// services are installed before deployments
+		assert.Assert(t, strings.Index(output, "kind: Service") < strings.Index(output, "kind: Deployment"), output)
+		assert.Assert(t, strings.Contains(output, "# Source: templates/deployment.yaml\napiVersion: apps/v1\nkind: Deployment\n"), output)
+		assert.Assert(t, strings.Contains(output, "replicas: 3"), output)
+		assert.Assert(t, strings.Contains(output, "checksum/config: MASKED"), output)
+		assert.Assert(t, strings.Contains(output, "helm.sh/chart: MASKED"), output)
+		assert.Assert(t, strings.Contains(output, "value: MASKED"), output)
+	})
+	t.Run("unchanged", func(t *testing.T) {
+		results, err := golden(false)
+		assert.NilError(t, err)
+		for _, result := range results {
+			assert.Assert(t, !result.Failed && !result.Updated)
+		}
+	})
+	t.Run("changed", func(t *testing.T) {
+		assert.NilError(t, ioutil.WriteFile(filepath.Join(valuesDir, "ha.yml"), []byte("replicas: 5\n"), 0644))
+		results, err := golden(false)
+		assert.ErrorContains(t, err, "1 differ")
+		assert.Assert(t, !results[0].Failed)
+		assert.Assert(t, results[1].Failed)
+		assert.NilError(t, results[1].Err)
+		assert.Assert(t, strings.Contains(results[1].Diff, "-  replicas: 3\n+  replicas: 5\n"), results[1].Diff)
+	})
+}
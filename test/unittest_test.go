@@ -0,0 +1,222 @@
+package test
+
+import (
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const unitTestDeploymentForTest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}-hello
+  namespace: {{ .Release.Namespace }}
+  labels:
+    app.kubernetes.io/name: hello
+spec:
+  replicas: {{ .Values.replicas }}
+  template:
+    spec:
+      containers:
+        - name: hello
+          image: "{{ .Values.image.repository }}:{{ .Values.image.tag }}"
+          ports:
+            - name: http
+              containerPort: 80
+`
+
+const unitTestServiceForTest = `{{- if .Values.service.enabled }}
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name }}-hello
+{{- end }}
+`
+
+const unitTestSuiteForTest = `suite: deployment
+templates:
+  - deployment.yaml
+release:
+  name: my-release
+  namespace: my-namespace
+tests:
+  - it: renders the defaults
+    asserts:
+      - isKind:
+          of: Deployment
+      - hasDocuments:
+          count: 1
+      - equal:
+          path: metadata.name
+          value: my-release-hello
+      - equal:
+          path: metadata.namespace
+          value: my-namespace
+      - equal:
+          path: metadata.labels["app.kubernetes.io/name"]
+          value: hello
+      - matchRegex:
+          path: spec.template.spec.containers[0].image
+          pattern: ^nginx:1\.
+      - contains:
+          path: spec.template.spec.containers[0].ports
+          content:
+            name: http
+            containerPort: 80
+      - notExists:
+          path: spec.strategy
+      - matchSnapshot: {}
+  - it: uses the values of the test
+    values:
+      - values/ha.yaml
+    set:
+      image.tag: "2.0"
+    asserts:
+      - equal:
+          path: spec.replicas
+          value: 3
+      - equal:
+          path: spec.template.spec.containers[0].image
+          value: nginx:2.0
+  - it: skips the service
+    template: service.yaml
+    asserts:
+      - hasDocuments:
+          count: 0
+      - hasDocuments:
+          count: 1
+        not: true
+`
+
+const unitTestFailingSuiteForTest = `suite: failing
+tests:
+  - it: fails
+    template: deployment.yaml
+    asserts:
+      - equal:
+          path: spec.replicas
+          value: 2
+      - isKind:
+          of: Deployment
+        not: true
+      - matchSnapshot:
+          path: spec.template.spec.containers[0].image
+`
+
+func writeUnitTestChart(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "unittest")
+	assert.NilError(t, err)
+	files := map[string]string{
+		"Chart.yaml":                 "apiVersion: v2\nname: hello\nversion: 0.1.0\n",
+		"values.yaml":                "replicas: 1\nimage:\n  repository: nginx\n  tag: \"1.21\"\nservice:\n  enabled: false\n",
+		"templates/deployment.yaml":  unitTestDeploymentForTest,
+		"templates/service.yaml":     unitTestServiceForTest,
+		"tests/deployment_test.yaml": unitTestSuiteForTest,
+		"tests/values/ha.yaml":       "replicas: 3\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NilError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestUnitTest(t *testing.T) {
+	cli := helmclient.NewHelmClient(kubeConfigForTest, "default")
+
+	t.Run("pass", func(t *testing.T) {
+		dir := writeUnitTestChart(t)
+		defer os.RemoveAll(dir)
+		unitTestCli, err := cli.UnitTest([]helmclient.UnitTestOption{}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		result, err := unitTestCli.UnitTest(dir)
+		assert.NilError(t, err)
+		assert.Equal(t, len(result.Suites), 1)
+		suite := result.Suites[0]
+		assert.Equal(t, suite.File, "tests/deployment_test.yaml")
+		assert.Equal(t, suite.Name, "deployment")
+		assert.Equal(t, len(suite.Tests), 3)
+		for _, test := range suite.Tests {
+			assert.NilError(t, test.Err)
+			for _, a := range test.Assertions {
+				assert.Assert(t, !a.Failed, "%s: %s", test.Name, a)
+			}
+		}
+		assert.Assert(t, !result.Failed)
+
+		snapshot, err := ioutil.ReadFile(filepath.Join(dir, "tests", "__snapshot__", "deployment_test.yaml.snap"))
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(string(snapshot), "renders the defaults 1"), string(snapshot))
+
+		// the stored snapshot still matches
+		result, err = unitTestCli.UnitTest(dir)
+		assert.NilError(t, err)
+		assert.Assert(t, !result.Failed)
+	})
+	t.Run("fail", func(t *testing.T) {
+		dir := writeUnitTestChart(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "tests", "failing_test.yaml")
+		assert.NilError(t, ioutil.WriteFile(path, []byte(unitTestFailingSuiteForTest), 0644))
+		unitTestCli, err := cli.UnitTest([]helmclient.UnitTestOption{
+			helmclient.UnitTestWithTestFiles([]string{"tests/failing_test.yaml"}),
+		}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		// store the snapshot, then render something else
+		_, err = unitTestCli.UnitTest(dir)
+		assert.ErrorContains(t, err, "1 test(s) run, 1 failed")
+
+		unitTestCli, err = cli.UnitTest([]helmclient.UnitTestOption{
+			helmclient.UnitTestWithTestFiles([]string{"tests/failing_test.yaml"}),
+		}, []helmclient.ValueOption{
+			helmclient.WithValues([]string{"image.tag=2.0"}),
+		})
+		assert.NilError(t, err)
+		result, err := unitTestCli.UnitTest(dir)
+		assert.ErrorContains(t, err, "1 test(s) run, 1 failed")
+		assert.Assert(t, result.Failed)
+		test := result.Suites[0].Tests[0]
+		assert.Equal(t, len(test.Assertions), 3)
+
+		equal := test.Assertions[0]
+		assert.Assert(t, equal.Failed)
+		assert.Equal(t, equal.Message, "document 0 of templates/deployment.yaml: expected spec.replicas to equal 2")
+		assert.Assert(t, strings.Contains(equal.Diff, "-2\n+1\n"), equal.Diff)
+
+		isKind := test.Assertions[1]
+		assert.Assert(t, isKind.Failed)
+		assert.Equal(t, isKind.Message, "document 0 of templates/deployment.yaml: expected kind not to be Deployment, got Deployment")
+
+		snapshot := test.Assertions[2]
+		assert.Assert(t, snapshot.Failed)
+		assert.Assert(t, strings.Contains(snapshot.Diff, "-nginx:1.21\n+nginx:2.0\n"), snapshot.Diff)
+
+		// update mode accepts the new content
+		unitTestCli, err = cli.UnitTest([]helmclient.UnitTestOption{
+			helmclient.UnitTestWithTestFiles([]string{"tests/failing_test.yaml"}),
+			helmclient.UnitTestWithUpdateSnapshot(true),
+		}, []helmclient.ValueOption{
+			helmclient.WithValues([]string{"image.tag=2.0"}),
+		})
+		assert.NilError(t, err)
+		result, _ = unitTestCli.UnitTest(dir)
+		assert.Assert(t, !result.Suites[0].Tests[0].Assertions[2].Failed)
+	})
+	t.Run("invalid suite", func(t *testing.T) {
+		dir := writeUnitTestChart(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "tests", "invalid_test.yaml")
+		assert.NilError(t, ioutil.WriteFile(path, []byte("tests:\n  - it: typo\n    assert: []\n"), 0644))
+		unitTestCli, err := cli.UnitTest([]helmclient.UnitTestOption{}, []helmclient.ValueOption{})
+		assert.NilError(t, err)
+		result, err := unitTestCli.UnitTest(dir)
+		assert.Assert(t, err != nil)
+		assert.Equal(t, result.Suites[1].File, "tests/invalid_test.yaml")
+		assert.ErrorContains(t, result.Suites[1].Err, "unknown field")
+	})
+}
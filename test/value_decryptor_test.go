@@ -0,0 +1,152 @@
+package test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	helmclient "github.com/outgnaY/helm-go-client"
+	"gotest.tools/assert"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+func TestValuesDecryptor(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NilError(t, err)
+
+	t.Run("age", func(t *testing.T) {
+		var buf bytes.Buffer
+		a := armor.NewWriter(&buf)
+		w, err := age.Encrypt(a, identity.Recipient())
+		assert.NilError(t, err)
+		io.WriteString(w, "password: secret\n")
+		assert.NilError(t, w.Close())
+		assert.NilError(t, a.Close())
+
+		d, err := helmclient.NewAgeValuesDecryptor(strings.NewReader(identity.String()))
+		assert.NilError(t, err)
+		assert.Assert(t, d.Detect(buf.Bytes()))
+		assert.Assert(t, !d.Detect([]byte("password: secret\n")))
+		plain, err := d.Decrypt(buf.Bytes())
+		assert.NilError(t, err)
+		assert.Equal(t, string(plain), "password: secret\n")
+	})
+
+	// sopsDocument encrypts the values of a SOPS document for the identity,
+	// with a MAC over the plain values in document order
+	sopsDocument := func(t *testing.T) map[string]interface{} {
+		key := make([]byte, 32)
+		rand.Read(key)
+		var enc bytes.Buffer
+		a := armor.NewWriter(&enc)
+		w, err := age.Encrypt(a, identity.Recipient())
+		assert.NilError(t, err)
+		w.Write(key)
+		assert.NilError(t, w.Close())
+		assert.NilError(t, a.Close())
+
+		// the keys are marshalled sorted: db.password, db.port, hosts, region
+		mac := sha512.New()
+		for _, plain := range []string{"secret", "5432", "a.example.com", "eu"} {
+			mac.Write([]byte(plain))
+		}
+		lastModified := "2021-09-01T10:00:00Z"
+		return map[string]interface{}{
+			"db": map[string]interface{}{
+				"password": sopsEncrypt(t, key, "secret", "db:password:", "str"),
+				"port":     sopsEncrypt(t, key, "5432", "db:port:", "int"),
+			},
+			"hosts":  []interface{}{sopsEncrypt(t, key, "a.example.com", "hosts:", "str")},
+			"region": "eu",
+			"sops": map[string]interface{}{
+				"age":          []interface{}{map[string]interface{}{"recipient": identity.Recipient().String(), "enc": enc.String()}},
+				"lastmodified": lastModified,
+				"mac":          sopsEncrypt(t, key, fmt.Sprintf("%X", mac.Sum(nil)), lastModified, "str"),
+				"version":      "3.7.1",
+			},
+		}
+	}
+
+	t.Run("sops", func(t *testing.T) {
+		data, err := yaml.Marshal(sopsDocument(t))
+		assert.NilError(t, err)
+
+		d, err := helmclient.NewSopsValuesDecryptor(strings.NewReader(identity.String()))
+		assert.NilError(t, err)
+		assert.Assert(t, d.Detect(data))
+		plain, err := d.Decrypt(data)
+		assert.NilError(t, err)
+		vals := map[string]interface{}{}
+		assert.NilError(t, yaml.Unmarshal(plain, &vals))
+		assert.DeepEqual(t, vals, map[string]interface{}{
+			"db":     map[string]interface{}{"password": "secret", "port": float64(5432)},
+			"hosts":  []interface{}{"a.example.com"},
+			"region": "eu",
+		})
+	})
+
+	t.Run("sops MAC", func(t *testing.T) {
+		d, err := helmclient.NewSopsValuesDecryptor(strings.NewReader(identity.String()))
+		assert.NilError(t, err)
+		// a removed value, a changed plain value and a missing MAC are rejected
+		doc := sopsDocument(t)
+		delete(doc["db"].(map[string]interface{}), "port")
+		data, err := yaml.Marshal(doc)
+		assert.NilError(t, err)
+		_, err = d.Decrypt(data)
+		assert.ErrorContains(t, err, "sops MAC mismatch")
+
+		doc = sopsDocument(t)
+		doc["region"] = "us"
+		data, err = yaml.Marshal(doc)
+		assert.NilError(t, err)
+		_, err = d.Decrypt(data)
+		assert.ErrorContains(t, err, "sops MAC mismatch")
+
+		doc = sopsDocument(t)
+		doc["sops"].(map[string]interface{})["mac"] = "unused"
+		data, err = yaml.Marshal(doc)
+		assert.NilError(t, err)
+		_, err = d.Decrypt(data)
+		assert.ErrorContains(t, err, "no valid MAC")
+	})
+
+	t.Run("install with encrypted values", func(t *testing.T) {
+		data, err := yaml.Marshal(sopsDocument(t))
+		assert.NilError(t, err)
+		secrets := filepath.Join(t.TempDir(), "secrets.enc.yaml")
+		assert.NilError(t, ioutil.WriteFile(secrets, data, 0600))
+		d, err := helmclient.NewSopsValuesDecryptor(strings.NewReader(identity.String()))
+		assert.NilError(t, err)
+		cli := helmclient.NewHelmClientWithClientOpts(kubeConfigForTest, "default", []helmclient.GlobalOption{}, []helmclient.ClientOption{helmclient.ClientWithValuesDecryptor(d)})
+		installCli, err := cli.Install([]helmclient.InstallOption{}, []helmclient.ValueOption{helmclient.WithValueFiles([]string{secrets})}, []helmclient.ChartPathOption{})
+		assert.Equal(t, err, nil)
+		release, err := installCli.Install([]string{"NAME", packageChart(t, "hello", "0.1.0")})
+		fmt.Println(release)
+		assert.Equal(t, err, nil)
+	})
+}
+
+func sopsEncrypt(t *testing.T, key []byte, value, aad, typ string) string {
+	block, err := aes.NewCipher(key)
+	assert.NilError(t, err)
+	iv := make([]byte, 32)
+	rand.Read(iv)
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	assert.NilError(t, err)
+	out := gcm.Seal(nil, iv, []byte(value), []byte(aad))
+	data, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data), base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag), typ)
+}
@@ -89,7 +89,7 @@ func (c *uninstallClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) erro
 }
 
 func (c *uninstallClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
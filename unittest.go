@@ -0,0 +1,479 @@
+package helmclient
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+const (
+	unitTestDefaultUpdateSnapshot = false
+	unitTestDefaultReleaseName    = "RELEASE-NAME"
+	unitTestSnapshotDir           = "__snapshot__"
+)
+
+var unitTestDefaultTestFiles = []string{"tests/*_test.yaml"}
+
+type unitTestClient interface {
+	UnitTest(path string) (*UnitTestResult, error)
+}
+
+type unitTestClientImpl struct {
+	env            *helmEnv
+	valueOpts      *valueOptions
+	testFiles      []string
+	updateSnapshot bool
+}
+
+// UnitTestResult holds the results of the test suites of one chart.
+type UnitTestResult struct {
+	Path   string
+	Suites []*UnitTestSuiteResult
+	Failed bool
+}
+
+// UnitTestSuiteResult holds the results of one suite file.
+type UnitTestSuiteResult struct {
+	// File is the path of the suite relative to the chart root.
+	File string
+	Name string
+	// Err is set when the suite could not be read, it has no tests then.
+	Err    error
+	Tests  []*UnitTestCaseResult
+	Failed bool
+}
+
+// UnitTestCaseResult holds the results of one test of a suite.
+type UnitTestCaseResult struct {
+	Name string
+	// Err is set when the chart could not be rendered for the test.
+	Err        error
+	Assertions []*UnitTestAssertionResult
+	Failed     bool
+}
+
+// UnitTestAssertionResult is the outcome of one assertion of a test.
+type UnitTestAssertionResult struct {
+	// Index is the position of the assertion in the test, starting at 0.
+	Index int
+	// Type is the kind of assertion, such as equal or isKind.
+	Type string
+	Not  bool
+	// Message tells why the assertion failed.
+	Message string
+	// Diff is a unified diff from the expected to the actual content, for the
+	// assertions which compare content.
+	Diff   string
+	Failed bool
+}
+
+func (r *UnitTestAssertionResult) String() string {
+	name := r.Type
+	if r.Not {
+		name = "not " + name
+	}
+	if !r.Failed {
+		return fmt.Sprintf("[PASS] %d %s", r.Index, name)
+	}
+	return fmt.Sprintf("[FAIL] %d %s: %s", r.Index, name, r.Message)
+}
+
+type UnitTestOption struct {
+	f func(o *unitTestOptions)
+}
+
+type unitTestOptions struct {
+	testFiles      []string
+	updateSnapshot bool
+}
+
+func (o *unitTestOptions) apply(opts []UnitTestOption) {
+	for _, op := range opts {
+		op.f(o)
+	}
+}
+
+func newUnitTestOptions(opts []UnitTestOption) *unitTestOptions {
+	options := &unitTestOptions{
+		testFiles:      unitTestDefaultTestFiles,
+		updateSnapshot: unitTestDefaultUpdateSnapshot,
+	}
+	options.apply(opts)
+	return options
+}
+
+// UnitTestWithTestFiles sets the glob patterns of the suite files, relative
+// to the chart root.
+func UnitTestWithTestFiles(testFiles []string) UnitTestOption {
+	return UnitTestOption{f: func(o *unitTestOptions) {
+		o.testFiles = testFiles
+	}}
+}
+
+// UnitTestWithUpdateSnapshot stores the rendered content as the snapshots
+// instead of comparing against the stored ones.
+func UnitTestWithUpdateSnapshot(updateSnapshot bool) UnitTestOption {
+	return UnitTestOption{f: func(o *unitTestOptions) {
+		o.updateSnapshot = updateSnapshot
+	}}
+}
+
+func newUnitTestClient(opts []UnitTestOption, valueOpts []ValueOption, env *helmEnv) (*unitTestClientImpl, error) {
+	o := newUnitTestOptions(opts)
+	v := &valueOptions{
+		ValueFiles:   []string{},
+		StringValues: []string{},
+		Values:       []string{},
+		FileValues:   []string{},
+	}
+	addValueOptions(valueOpts, v)
+	return &unitTestClientImpl{
+		env:            env,
+		valueOpts:      v,
+		testFiles:      o.testFiles,
+		updateSnapshot: o.updateSnapshot,
+	}, nil
+}
+
+// unitTestSuite is the format of a suite file, a subset of the one of
+// helm-unittest.
+type unitTestSuite struct {
+	Suite string `json:"suite"`
+	// Templates select the templates the assertions run against, relative to
+	// the templates directory of the chart. Globs are allowed.
+	Templates []string `json:"templates"`
+	// Values are values files relative to the suite file.
+	Values []string `json:"values"`
+	// Set maps dotted value paths such as image.tag to values.
+	Set          map[string]interface{} `json:"set"`
+	Release      unitTestRelease        `json:"release"`
+	Capabilities unitTestCapabilities   `json:"capabilities"`
+	Tests        []*unitTestCase        `json:"tests"`
+}
+
+type unitTestRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Upgrade   bool   `json:"upgrade"`
+}
+
+type unitTestCapabilities struct {
+	MajorVersion string   `json:"majorVersion"`
+	MinorVersion string   `json:"minorVersion"`
+	APIVersions  []string `json:"apiVersions"`
+}
+
+// unitTestCase is a test of a suite. Its settings are added to the ones of
+// the suite.
+type unitTestCase struct {
+	It       string                 `json:"it"`
+	Template string                 `json:"template"`
+	Values   []string               `json:"values"`
+	Set      map[string]interface{} `json:"set"`
+	Release  *unitTestRelease       `json:"release"`
+	Asserts  []*unitTestAssert      `json:"asserts"`
+}
+
+// UnitTest runs the test suites of the chart directory at path. Every test
+// renders the chart the way Template does, with the values of the client
+// followed by the values of the suite and the test. If any test fails, the
+// result is returned together with a summary error.
+func (c *unitTestClientImpl) UnitTest(path string) (*UnitTestResult, error) {
+	if ok, err := chartutil.IsChartDir(path); !ok {
+		return nil, err
+	}
+	files, err := c.suiteFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("no test suites found in %s", path)
+	}
+	result := &UnitTestResult{Path: path}
+	for _, file := range files {
+		suite := c.runSuite(path, file)
+		result.Suites = append(result.Suites, suite)
+		result.Failed = result.Failed || suite.Failed
+	}
+	return result, unitTestSummary(result)
+}
+
+func (c *unitTestClientImpl) suiteFiles(chartPath string) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	for _, pattern := range c.testFiles {
+		matches, err := filepath.Glob(filepath.Join(chartPath, pattern))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid test file pattern %s", pattern)
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(chartPath, match)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[rel] {
+				seen[rel] = true
+				files = append(files, rel)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (c *unitTestClientImpl) runSuite(chartPath string, file string) *UnitTestSuiteResult {
+	result := &UnitTestSuiteResult{File: filepath.ToSlash(file)}
+	fail := func(err error) *UnitTestSuiteResult {
+		result.Err = err
+		result.Failed = true
+		return result
+	}
+	data, err := ioutil.ReadFile(filepath.Join(chartPath, file))
+	if err != nil {
+		return fail(err)
+	}
+	suite := &unitTestSuite{}
+	if err := yaml.UnmarshalStrict(data, suite); err != nil {
+		return fail(errors.Wrapf(err, "failed to parse %s", file))
+	}
+	result.Name = suite.Suite
+	snapshots, err := loadUnitTestSnapshots(filepath.Join(chartPath, file), c.updateSnapshot)
+	if err != nil {
+		return fail(err)
+	}
+	for _, test := range suite.Tests {
+		testResult := c.runTest(chartPath, filepath.Dir(filepath.Join(chartPath, file)), suite, test, snapshots)
+		result.Tests = append(result.Tests, testResult)
+		result.Failed = result.Failed || testResult.Failed
+	}
+	if err := snapshots.save(); err != nil {
+		return fail(err)
+	}
+	return result
+}
+
+func (c *unitTestClientImpl) runTest(chartPath string, suiteDir string, suite *unitTestSuite, test *unitTestCase, snapshots *unitTestSnapshots) *UnitTestCaseResult {
+	result := &UnitTestCaseResult{Name: test.It}
+	docs, err := c.render(chartPath, suiteDir, suite, test)
+	if err != nil {
+		result.Err = err
+		result.Failed = true
+		return result
+	}
+	selectors := suite.Templates
+	if test.Template != "" {
+		selectors = []string{test.Template}
+	}
+	snapshotCount := 0
+	for i, a := range test.Asserts {
+		ctx := &unitTestContext{docs: selectDocuments(docs, selectors)}
+		if a.Template != "" {
+			ctx.docs = selectDocuments(docs, []string{a.Template})
+		}
+		if a.MatchSnapshot != nil {
+			snapshotCount++
+			ctx.snapshots = snapshots
+			ctx.snapshotKey = fmt.Sprintf("%s %d", test.It, snapshotCount)
+		}
+		assertion := a.evaluate(ctx)
+		assertion.Index = i
+		result.Assertions = append(result.Assertions, assertion)
+		result.Failed = result.Failed || assertion.Failed
+	}
+	return result
+}
+
+// render renders the chart with the values and release settings of a test.
+func (c *unitTestClientImpl) render(chartPath string, suiteDir string, suite *unitTestSuite, test *unitTestCase) ([]*renderedDocument, error) {
+	settings := suite.Release
+	if test.Release != nil {
+		if test.Release.Name != "" {
+			settings.Name = test.Release.Name
+		}
+		if test.Release.Namespace != "" {
+			settings.Namespace = test.Release.Namespace
+		}
+		settings.Upgrade = settings.Upgrade || test.Release.Upgrade
+	}
+	if settings.Name == "" {
+		settings.Name = unitTestDefaultReleaseName
+	}
+
+	opts := []TemplateOption{TemplateWithIsUpgrade(settings.Upgrade)}
+	if caps := suite.Capabilities; caps.MajorVersion != "" || caps.MinorVersion != "" {
+		opts = append(opts, TemplateWithKubeVersion(fmt.Sprintf("v%s.%s.0", caps.MajorVersion, caps.MinorVersion)))
+	}
+	if len(suite.Capabilities.APIVersions) > 0 {
+		opts = append(opts, TemplateWithExtraAPIs(suite.Capabilities.APIVersions))
+	}
+	tc, err := newTemplateClient(opts, nil, c.env)
+	if err != nil {
+		return nil, err
+	}
+
+	v := c.valueOpts.with(nil)
+	for _, file := range append(append([]string{}, suite.Values...), test.Values...) {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(suiteDir, file)
+		}
+		v.ValueFiles = append(v.ValueFiles, file)
+	}
+	set := mergeMaps(expandSetValues(suite.Set), expandSetValues(test.Set))
+	if len(set) > 0 {
+		file, err := writeTempValues(set)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(file)
+		v.ValueFiles = append(v.ValueFiles, file)
+	}
+	tc.cli.Namespace = settings.Namespace
+
+	rel, err := tc.render(settings.Name, ChartFromFS(os.DirFS(chartPath)), v)
+	if err != nil {
+		return nil, err
+	}
+	return renderedDocuments(rel)
+}
+
+// expandSetValues turns dotted paths into nested maps.
+func expandSetValues(set map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for key, value := range set {
+		parts := strings.Split(key, ".")
+		for i := len(parts) - 1; i > 0; i-- {
+			value = map[string]interface{}{parts[i]: value}
+		}
+		out = mergeMaps(out, map[string]interface{}{parts[0]: value})
+	}
+	return out
+}
+
+func writeTempValues(vals map[string]interface{}) (string, error) {
+	data, err := yaml.Marshal(vals)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "helm-unittest-values-*.yaml")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func unitTestSummary(result *UnitTestResult) error {
+	total, failed := 0, 0
+	for _, suite := range result.Suites {
+		if suite.Err != nil {
+			failed++
+			continue
+		}
+		for _, test := range suite.Tests {
+			total++
+			if test.Failed {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d test(s) run, %d failed", total, failed)
+	}
+	return nil
+}
+
+// renderedDocument is one YAML document of a rendered release.
+type renderedDocument struct {
+	// Template is the path of the template which rendered the document,
+	// relative to the chart root.
+	Template string
+	Content  string
+	Object   map[string]interface{}
+	// Hook is set for the documents of hooks.
+	Hook bool
+}
+
+var sourceCommentRegex = regexp.MustCompile(`^# Source: [^/]+/(.+)\n`)
+
+// renderedDocuments splits the manifest of a release into its documents, in
+// the order Helm installs them, followed by the hooks. A document without a
+// source comment, such as the output of a post-renderer, is named after its
+// key in the split manifest.
+func renderedDocuments(rel *release.Release) ([]*renderedDocument, error) {
+	manifests := releaseutil.SplitManifests(rel.Manifest)
+	keys := make([]string, 0, len(manifests))
+	for k := range manifests {
+		keys = append(keys, k)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	var docs []*renderedDocument
+	add := func(template string, content string, hook bool) error {
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(content), &obj); err != nil {
+			return errors.Wrapf(err, "failed to parse document rendered by %s", template)
+		}
+		if len(obj) == 0 {
+			return nil
+		}
+		docs = append(docs, &renderedDocument{Template: template, Content: content, Object: obj, Hook: hook})
+		return nil
+	}
+	for _, k := range keys {
+		content := strings.TrimSpace(manifests[k]) + "\n"
+		template := k
+		if m := sourceCommentRegex.FindStringSubmatch(content); m != nil {
+			template = m[1]
+			content = content[len(m[0]):]
+		}
+		if err := add(template, content, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, h := range rel.Hooks {
+		template := h.Path
+		if i := strings.Index(template, "/"); i >= 0 {
+			template = template[i+1:]
+		}
+		if err := add(template, strings.TrimSpace(h.Manifest)+"\n", true); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// selectDocuments keeps the documents of the templates matching selectors. A
+// selector without a directory refers to the templates directory.
+func selectDocuments(docs []*renderedDocument, selectors []string) []*renderedDocument {
+	if len(selectors) == 0 {
+		return docs
+	}
+	var selected []*renderedDocument
+	for _, doc := range docs {
+		for _, selector := range selectors {
+			selector = path.Clean(filepath.ToSlash(selector))
+			if !strings.HasPrefix(selector, "templates/") && !strings.HasPrefix(selector, "charts/") {
+				selector = "templates/" + selector
+			}
+			if matched, _ := path.Match(selector, doc.Template); matched {
+				selected = append(selected, doc)
+				break
+			}
+		}
+	}
+	return selected
+}
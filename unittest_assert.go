@@ -0,0 +1,416 @@
+package helmclient
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+)
+
+// unitTestAssert is an assertion of a test. Exactly one of the assertion
+// fields is set.
+type unitTestAssert struct {
+	Equal         *unitTestEqual    `json:"equal"`
+	MatchRegex    *unitTestRegex    `json:"matchRegex"`
+	Contains      *unitTestContains `json:"contains"`
+	HasDocuments  *unitTestCount    `json:"hasDocuments"`
+	IsKind        *unitTestKind     `json:"isKind"`
+	NotExists     *unitTestPath     `json:"notExists"`
+	MatchSnapshot *unitTestPath     `json:"matchSnapshot"`
+	// Not negates the assertion.
+	Not bool `json:"not"`
+	// Template overrides the templates the test selects.
+	Template string `json:"template"`
+	// DocumentIndex limits the assertion to one of the selected documents.
+	DocumentIndex *int `json:"documentIndex"`
+}
+
+type unitTestEqual struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+type unitTestRegex struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+}
+
+type unitTestContains struct {
+	Path    string      `json:"path"`
+	Content interface{} `json:"content"`
+}
+
+type unitTestCount struct {
+	Count int `json:"count"`
+}
+
+type unitTestKind struct {
+	Of string `json:"of"`
+}
+
+type unitTestPath struct {
+	Path string `json:"path"`
+}
+
+// unitTestContext is what an assertion runs against.
+type unitTestContext struct {
+	docs        []*renderedDocument
+	snapshots   *unitTestSnapshots
+	snapshotKey string
+}
+
+// unitTestCheck checks a single document. It returns whether the document
+// matches and the expectation, phrased so that it reads after "expected" and
+// "expected ... not". diff is only used when a match was expected.
+type unitTestCheck func(doc *renderedDocument) (matched bool, subject string, predicate string, diff string)
+
+func (a *unitTestAssert) evaluate(ctx *unitTestContext) *UnitTestAssertionResult {
+	result := &UnitTestAssertionResult{Not: a.Not}
+	fail := func(format string, v ...interface{}) *UnitTestAssertionResult {
+		result.Failed = true
+		result.Message = fmt.Sprintf(format, v...)
+		return result
+	}
+
+	var check unitTestCheck
+	switch {
+	case a.Equal != nil:
+		result.Type = "equal"
+		check = a.Equal.check
+	case a.MatchRegex != nil:
+		result.Type = "matchRegex"
+		pattern, err := regexp.Compile(a.MatchRegex.Pattern)
+		if err != nil {
+			return fail("invalid pattern: %s", err)
+		}
+		check = a.MatchRegex.checker(pattern)
+	case a.Contains != nil:
+		result.Type = "contains"
+		check = a.Contains.check
+	case a.HasDocuments != nil:
+		result.Type = "hasDocuments"
+	case a.IsKind != nil:
+		result.Type = "isKind"
+		check = a.IsKind.check
+	case a.NotExists != nil:
+		result.Type = "notExists"
+		check = a.NotExists.checkNotExists
+	case a.MatchSnapshot != nil:
+		result.Type = "matchSnapshot"
+	default:
+		return fail("no assertion given")
+	}
+
+	docs := ctx.docs
+	if a.DocumentIndex != nil {
+		if *a.DocumentIndex < 0 || *a.DocumentIndex >= len(docs) {
+			return fail("documentIndex %d is out of range, %d document(s) rendered", *a.DocumentIndex, len(docs))
+		}
+		docs = docs[*a.DocumentIndex : *a.DocumentIndex+1]
+	}
+
+	switch result.Type {
+	case "hasDocuments":
+		if (len(docs) == a.HasDocuments.Count) == a.Not {
+			return fail("expected %s%d document(s), got %d", notPrefix(a.Not), a.HasDocuments.Count, len(docs))
+		}
+		return result
+	case "matchSnapshot":
+		if a.Not {
+			return fail("matchSnapshot can't be negated")
+		}
+		return a.MatchSnapshot.matchSnapshot(docs, ctx, result)
+	}
+
+	if len(docs) == 0 {
+		return fail("no documents rendered by the selected templates")
+	}
+	for i, doc := range docs {
+		matched, subject, predicate, diff := check(doc)
+		if matched == a.Not {
+			index := i
+			if a.DocumentIndex != nil {
+				index = *a.DocumentIndex
+			}
+			not := ""
+			if a.Not {
+				not = " not"
+			}
+			fail("document %d of %s: expected %s%s %s", index, doc.Template, subject, not, predicate)
+			if !a.Not {
+				result.Diff = diff
+			}
+			return result
+		}
+	}
+	return result
+}
+
+func notPrefix(not bool) string {
+	if not {
+		return "not "
+	}
+	return ""
+}
+
+func (e *unitTestEqual) check(doc *renderedDocument) (bool, string, string, string) {
+	predicate := fmt.Sprintf("to equal %s", formatUnitTestValue(e.Value))
+	actual, found, err := lookupUnitTestPath(doc.Object, e.Path)
+	if err != nil {
+		return false, e.Path, fmt.Sprintf("%s, %s", predicate, err), ""
+	}
+	if !found {
+		return false, e.Path, predicate + ", it does not exist", ""
+	}
+	if reflect.DeepEqual(actual, e.Value) {
+		return true, e.Path, predicate, ""
+	}
+	return false, e.Path, predicate, unitTestDiff(e.Value, actual)
+}
+
+func (r *unitTestRegex) checker(pattern *regexp.Regexp) unitTestCheck {
+	return func(doc *renderedDocument) (bool, string, string, string) {
+		predicate := fmt.Sprintf("to match %q", r.Pattern)
+		actual, found, _ := lookupUnitTestPath(doc.Object, r.Path)
+		s, ok := actual.(string)
+		if !found || !ok {
+			return false, r.Path, predicate + ", it is not a string", ""
+		}
+		return pattern.MatchString(s), r.Path, predicate + fmt.Sprintf(", got %q", s), ""
+	}
+}
+
+func (c *unitTestContains) check(doc *renderedDocument) (bool, string, string, string) {
+	predicate := fmt.Sprintf("to contain %s", formatUnitTestValue(c.Content))
+	actual, found, _ := lookupUnitTestPath(doc.Object, c.Path)
+	items, ok := actual.([]interface{})
+	if !found || !ok {
+		return false, c.Path, predicate + ", it is not a list", ""
+	}
+	for _, item := range items {
+		if reflect.DeepEqual(item, c.Content) {
+			return true, c.Path, predicate, ""
+		}
+	}
+	return false, c.Path, predicate, unitTestDiff([]interface{}{c.Content}, items)
+}
+
+func (k *unitTestKind) check(doc *renderedDocument) (bool, string, string, string) {
+	kind, _ := doc.Object["kind"].(string)
+	return kind == k.Of, "kind", fmt.Sprintf("to be %s, got %s", k.Of, kind), ""
+}
+
+func (p *unitTestPath) checkNotExists(doc *renderedDocument) (bool, string, string, string) {
+	_, found, _ := lookupUnitTestPath(doc.Object, p.Path)
+	return !found, p.Path, "to not exist", ""
+}
+
+// matchSnapshot compares the documents, or the value at the path of each of
+// them, with the snapshot stored for the assertion. A missing snapshot is
+// stored and passes.
+func (p *unitTestPath) matchSnapshot(docs []*renderedDocument, ctx *unitTestContext, result *UnitTestAssertionResult) *UnitTestAssertionResult {
+	var parts []string
+	for _, doc := range docs {
+		var v interface{} = doc.Object
+		if p.Path != "" {
+			var found bool
+			v, found, _ = lookupUnitTestPath(doc.Object, p.Path)
+			if !found {
+				continue
+			}
+		}
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			result.Failed = true
+			result.Message = err.Error()
+			return result
+		}
+		parts = append(parts, string(data))
+	}
+	content := strings.Join(parts, "---\n")
+	stored, ok := ctx.snapshots.match(ctx.snapshotKey, content)
+	if !ok {
+		result.Failed = true
+		result.Message = fmt.Sprintf("content differs from snapshot %q", ctx.snapshotKey)
+		result.Diff = unifiedDiff(stored, content)
+	}
+	return result
+}
+
+func formatUnitTestValue(v interface{}) string {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	s := strings.TrimSpace(string(data))
+	if strings.Contains(s, "\n") {
+		return "\n" + s
+	}
+	return s
+}
+
+func unitTestDiff(expected interface{}, actual interface{}) string {
+	a, _ := yaml.Marshal(expected)
+	b, _ := yaml.Marshal(actual)
+	return unifiedDiff(string(a), string(b))
+}
+
+func unifiedDiff(expected string, actual string) string {
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(expected),
+		B:        difflib.SplitLines(actual),
+		FromFile: "expected",
+		ToFile:   "actual",
+		Context:  3,
+	})
+	return diff
+}
+
+// lookupUnitTestPath returns the value at a path such as
+// spec.containers[0].image or metadata.labels["app.kubernetes.io/name"].
+func lookupUnitTestPath(obj map[string]interface{}, p string) (interface{}, bool, error) {
+	segments, err := parseUnitTestPath(p)
+	if err != nil {
+		return nil, false, err
+	}
+	var current interface{} = obj
+	for _, segment := range segments {
+		switch s := segment.(type) {
+		case string:
+			m, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false, nil
+			}
+			if current, ok = m[s]; !ok {
+				return nil, false, nil
+			}
+		case int:
+			l, ok := current.([]interface{})
+			if !ok || s < 0 || s >= len(l) {
+				return nil, false, nil
+			}
+			current = l[s]
+		}
+	}
+	return current, true, nil
+}
+
+// anyIndex stands for [*], which masks use to match every item of a list.
+// Lookups never match it.
+const anyIndex = -1
+
+// parseUnitTestPath splits a path into map keys and list indexes.
+func parseUnitTestPath(p string) ([]interface{}, error) {
+	var segments []interface{}
+	var key strings.Builder
+	flush := func() {
+		if key.Len() > 0 {
+			segments = append(segments, key.String())
+			key.Reset()
+		}
+	}
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '.':
+			flush()
+		case '[':
+			flush()
+			end := strings.IndexByte(p[i:], ']')
+			if end < 0 {
+				return nil, errors.Errorf("invalid path %s: unclosed [", p)
+			}
+			inner := p[i+1 : i+end]
+			i += end
+			if unquoted, err := strconv.Unquote(inner); err == nil {
+				segments = append(segments, unquoted)
+				continue
+			}
+			if inner == "*" {
+				segments = append(segments, anyIndex)
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, errors.Errorf("invalid path %s: %s is neither an index nor a quoted key", p, inner)
+			}
+			segments = append(segments, index)
+		default:
+			key.WriteByte(p[i])
+		}
+	}
+	flush()
+	return segments, nil
+}
+
+// unitTestSnapshots are the snapshots of a suite, stored in the __snapshot__
+// directory next to the suite file.
+type unitTestSnapshots struct {
+	path    string
+	update  bool
+	entries map[string]string
+	changed bool
+}
+
+func loadUnitTestSnapshots(suitePath string, update bool) (*unitTestSnapshots, error) {
+	s := &unitTestSnapshots{
+		path:    filepath.Join(filepath.Dir(suitePath), unitTestSnapshotDir, filepath.Base(suitePath)+".snap"),
+		update:  update,
+		entries: map[string]string{},
+	}
+	if update {
+		// all entries are written again, which drops the obsolete ones
+		s.changed = true
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &s.entries); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse snapshot %s", s.path)
+	}
+	return s, nil
+}
+
+// match compares content with the snapshot of key, storing it if there is
+// none yet or the snapshots are updated.
+func (s *unitTestSnapshots) match(key string, content string) (string, bool) {
+	stored, ok := s.entries[key]
+	if !ok || s.update {
+		s.entries[key] = content
+		s.changed = true
+		return content, true
+	}
+	return stored, stored == content
+}
+
+func (s *unitTestSnapshots) save() error {
+	if !s.changed {
+		return nil
+	}
+	if len(s.entries) == 0 {
+		// nothing to keep, don't leave an empty file behind
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := yaml.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
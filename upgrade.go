@@ -36,6 +36,7 @@ const (
 
 type upgradeClient interface {
 	Upgrade(args []string) (*release.Release, error)
+	UpgradeChart(name string, source ChartSource) (*release.Release, error)
 }
 
 type upgradeClientImpl struct {
@@ -224,7 +225,7 @@ func (c *upgradeClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error
 }
 
 func (c *upgradeClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env.clientGetter)
+	env, cfg, err := rebuildEnvAndCfg(globalOpts, namespace, c.env)
 	if err != nil {
 		return err
 	}
@@ -260,6 +261,9 @@ func copyUpgradeClientOptions(oldCli *action.Upgrade, newCli *action.Upgrade) {
 }
 
 func newUpgradeClient(opts []UpgradeOption, valueOpts []ValueOption, chartPathOpts []ChartPathOption, env *helmEnv) (*upgradeClientImpl, error) {
+	if err := validateTemplateFuncs(env.templateFuncs()); err != nil {
+		return nil, err
+	}
 	o := newUpgradeOptions(opts)
 	cfg := new(action.Configuration)
 	err := cfg.Init(env.clientGetter, env.Namespace(), "", debug)
@@ -312,24 +316,8 @@ func (c *upgradeClientImpl) Upgrade(args []string) (*release.Release, error) {
 		histClient := action.NewHistory(c.cfg)
 		histClient.Max = 1
 		if _, err := histClient.Run(args[0]); err == driver.ErrReleaseNotFound {
-			instClient := action.NewInstall(c.cfg)
-			instClient.CreateNamespace = c.createNamespace
-			instClient.ChartPathOptions = c.cli.ChartPathOptions
-			instClient.DryRun = c.cli.DryRun
-			instClient.DisableHooks = c.cli.DisableHooks
-			instClient.SkipCRDs = c.cli.SkipCRDs
-			instClient.Timeout = c.cli.Timeout
-			instClient.Wait = c.cli.Wait
-			instClient.WaitForJobs = c.cli.WaitForJobs
-			instClient.Devel = c.cli.Devel
-			instClient.Namespace = c.cli.Namespace
-			instClient.Atomic = c.cli.Atomic
-			instClient.PostRenderer = c.cli.PostRenderer
-			instClient.DisableOpenAPIValidation = c.cli.DisableOpenAPIValidation
-			instClient.SubNotes = c.cli.SubNotes
-			instClient.Description = c.cli.Description
-
-			return runInstall(args, instClient, (*values.Options)(c.valueOpts), os.Stdout, c.env)
+			instClient := c.newInstallClient()
+			return runInstall(args, instClient, c.cfg, (*values.Options)(c.valueOpts), os.Stdout, c.env)
 
 		} else if err != nil {
 			return nil, err
@@ -341,12 +329,21 @@ func (c *upgradeClientImpl) Upgrade(args []string) (*release.Release, error) {
 		c.cli.Version = ">0.0.0-0"
 	}
 
-	chartPath, err := c.cli.ChartPathOptions.LocateChart(args[1], c.env.settings)
+	chartPathOpts, err := chartPathCredentials(c.cli.ChartPathOptions, args[1], c.env)
+	if err != nil {
+		return nil, err
+	}
+	settings, cleanup, err := c.env.chartRepoSettings(chartPathOpts, args[1])
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	chartPath, err := chartPathOpts.LocateChart(args[1], settings)
 	if err != nil {
 		return nil, err
 	}
 
-	vals, err := (*values.Options)(c.valueOpts).MergeValues(getter.All(c.env.settings))
+	vals, err := c.valueOpts.mergeValues(getter.All(c.env.settings), c.env.valuesDecryptors())
 	if err != nil {
 		return nil, err
 	}
@@ -364,7 +361,65 @@ func (c *upgradeClientImpl) Upgrade(args []string) (*release.Release, error) {
 	if ch.Metadata.Deprecated {
 		warning("This chart is deprecated")
 	}
-	return c.cli.Run(args[0], ch, vals)
+	return runUpgradeAction(c.cli, c.cfg, args[0], ch, vals, c.env)
+}
+
+// UpgradeChart upgrades a release to a chart which is not located through the
+// chart path options.
+func (c *upgradeClientImpl) UpgradeChart(name string, source ChartSource) (*release.Release, error) {
+	c.cli.Namespace = c.env.Namespace()
+
+	if c.cli.Install {
+		// If a release does not exist, install it.
+		histClient := action.NewHistory(c.cfg)
+		histClient.Max = 1
+		if _, err := histClient.Run(name); err == driver.ErrReleaseNotFound {
+			return runInstallChart(name, source, c.newInstallClient(), c.cfg, (*values.Options)(c.valueOpts), c.env)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	vals, err := c.valueOpts.mergeValues(getter.All(c.env.settings), c.env.valuesDecryptors())
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := source.Load()
+	if err != nil {
+		return nil, err
+	}
+	if req := ch.Metadata.Dependencies; req != nil {
+		if err := action.CheckDependencies(ch, req); err != nil {
+			return nil, err
+		}
+	}
+	if ch.Metadata.Deprecated {
+		warning("This chart is deprecated")
+	}
+	return runUpgradeAction(c.cli, c.cfg, name, ch, vals, c.env)
+}
+
+// newInstallClient creates the install action used when upgrading a release
+// which does not exist yet.
+func (c *upgradeClientImpl) newInstallClient() *action.Install {
+	instClient := action.NewInstall(c.cfg)
+	instClient.CreateNamespace = c.createNamespace
+	instClient.ChartPathOptions = c.cli.ChartPathOptions
+	instClient.DryRun = c.cli.DryRun
+	instClient.DisableHooks = c.cli.DisableHooks
+	instClient.SkipCRDs = c.cli.SkipCRDs
+	instClient.Timeout = c.cli.Timeout
+	instClient.Wait = c.cli.Wait
+	instClient.WaitForJobs = c.cli.WaitForJobs
+	instClient.Devel = c.cli.Devel
+	instClient.Namespace = c.cli.Namespace
+	instClient.Atomic = c.cli.Atomic
+	instClient.PostRenderer = c.cli.PostRenderer
+	instClient.DisableOpenAPIValidation = c.cli.DisableOpenAPIValidation
+	instClient.SubNotes = c.cli.SubNotes
+	instClient.Description = c.cli.Description
+	return instClient
 }
 
 func mergeUpgradeOptions(o *upgradeOptions, cli *action.Upgrade) {
@@ -0,0 +1,323 @@
+package helmclient
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+	"hash"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sopsMetadataKey = "sops"
+	ageHeaderPrefix = "age-encryption.org/"
+)
+
+// ValuesDecryptor turns the content of an encrypted values file into plain
+// YAML. Implementations must not log or otherwise leak the decrypted content.
+type ValuesDecryptor interface {
+	// Detect reports whether data is encrypted in a format the decryptor handles.
+	Detect(data []byte) bool
+	Decrypt(data []byte) ([]byte, error)
+}
+
+// ageDecryptor handles values files that were encrypted as a whole with age,
+// either in the binary or in the ASCII armored format.
+type ageDecryptor struct {
+	identities []age.Identity
+}
+
+// NewAgeValuesDecryptor creates a decryptor for age encrypted values files.
+// identities is read in the age identity file format, one AGE-SECRET-KEY per line.
+func NewAgeValuesDecryptor(identities io.Reader) (ValuesDecryptor, error) {
+	ids, err := age.ParseIdentities(identities)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse age identities")
+	}
+	return &ageDecryptor{identities: ids}, nil
+}
+
+func (d *ageDecryptor) Detect(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return bytes.HasPrefix(data, []byte(ageHeaderPrefix)) || bytes.HasPrefix(data, []byte(armor.Header))
+}
+
+func (d *ageDecryptor) Decrypt(data []byte) ([]byte, error) {
+	return ageDecrypt(data, d.identities)
+}
+
+func ageDecrypt(data []byte, identities []age.Identity) ([]byte, error) {
+	var in io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		in = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// sopsDecryptor handles values files in the SOPS YAML layout whose data key is
+// encrypted for age recipients. Every encrypted value is authenticated by
+// AES-GCM with its key path, and the document as a whole by the SOPS MAC, so
+// that values which were changed, removed or added are rejected.
+type sopsDecryptor struct {
+	identities []age.Identity
+}
+
+type sopsMetadata struct {
+	Age []struct {
+		Recipient string `json:"recipient"`
+		Enc       string `json:"enc"`
+	} `json:"age"`
+	LastModified     string `json:"lastmodified"`
+	Mac              string `json:"mac"`
+	MacOnlyEncrypted bool   `json:"mac_only_encrypted"`
+	Version          string `json:"version"`
+}
+
+var sopsValueRegexp = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+// NewSopsValuesDecryptor creates a decryptor for values files encrypted by
+// SOPS with age keys. identities is read in the age identity file format.
+func NewSopsValuesDecryptor(identities io.Reader) (ValuesDecryptor, error) {
+	ids, err := age.ParseIdentities(identities)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse age identities")
+	}
+	return &sopsDecryptor{identities: ids}, nil
+}
+
+func (d *sopsDecryptor) Detect(data []byte) bool {
+	var doc struct {
+		Sops *sopsMetadata `json:"sops"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+	return doc.Sops != nil && doc.Sops.Mac != "" && doc.Sops.Version != ""
+}
+
+func (d *sopsDecryptor) Decrypt(data []byte) ([]byte, error) {
+	// the MAC covers the values in the order of the document
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, errors.New("sops file is not a map")
+	}
+	var meta struct {
+		Sops sopsMetadata `json:"sops"`
+	}
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	key, err := d.dataKey(&meta.Sops)
+	if err != nil {
+		return nil, err
+	}
+	w := &sopsWalker{key: key, hash: sha512.New(), onlyEncrypted: meta.Sops.MacOnlyEncrypted}
+	plain, err := w.walk(doc.Content[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySopsMac(&meta.Sops, key, w.hash.Sum(nil)); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(plain)
+}
+
+// verifySopsMac checks the MAC of the document, the hash of its values
+// encrypted with the last modification time as additional data.
+func verifySopsMac(meta *sopsMetadata, key []byte, sum []byte) error {
+	if !sopsValueRegexp.MatchString(meta.Mac) {
+		return errors.New("sops file has no valid MAC")
+	}
+	lastModified, err := time.Parse(time.RFC3339, meta.LastModified)
+	if err != nil {
+		return errors.Wrap(err, "invalid sops lastmodified")
+	}
+	mac, plain, err := sopsDecryptValue(meta.Mac, key, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return errors.Wrap(err, "failed to decrypt the sops MAC")
+	}
+	if _, ok := mac.(string); !ok || !hmac.Equal(plain, []byte(fmt.Sprintf("%X", sum))) {
+		return errors.New("sops MAC mismatch, the file was changed or truncated")
+	}
+	return nil
+}
+
+// dataKey recovers the document data key from the first age stanza that one
+// of the identities can open.
+func (d *sopsDecryptor) dataKey(meta *sopsMetadata) ([]byte, error) {
+	if len(meta.Age) == 0 {
+		return nil, errors.New("sops file has no age recipients")
+	}
+	var lastErr error
+	for _, stanza := range meta.Age {
+		key, err := ageDecrypt([]byte(stanza.Enc), d.identities)
+		if err == nil {
+			return key, nil
+		}
+		lastErr = err
+	}
+	return nil, errors.Wrap(lastErr, "failed to decrypt sops data key")
+}
+
+// sopsWalker decrypts a document and hashes its values for the MAC.
+type sopsWalker struct {
+	key           []byte
+	hash          hash.Hash
+	onlyEncrypted bool
+}
+
+// walk walks the document the same way SOPS does: only map keys contribute to
+// the path used as additional authenticated data, list items share the path
+// of the list. The sops metadata at the top is left out.
+func (w *sopsWalker) walk(node *yamlv3.Node, path []string) (interface{}, error) {
+	switch node.Kind {
+	case yamlv3.AliasNode:
+		return w.walk(node.Alias, path)
+	case yamlv3.MappingNode:
+		out := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i].Value
+			if len(path) == 0 && k == sopsMetadataKey {
+				continue
+			}
+			v, err := w.walk(node.Content[i+1], append(path[:len(path):len(path)], k))
+			if err != nil {
+				return nil, err
+			}
+			out[k] = v
+		}
+		return out, nil
+	case yamlv3.SequenceNode:
+		out := make([]interface{}, len(node.Content))
+		for i, item := range node.Content {
+			v, err := w.walk(item, path)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+	var v interface{}
+	if err := node.Decode(&v); err != nil {
+		return nil, err
+	}
+	if s, ok := v.(string); ok && sopsValueRegexp.MatchString(s) {
+		plain, data, err := sopsDecryptValue(s, w.key, strings.Join(path, ":")+":")
+		if err != nil {
+			return nil, err
+		}
+		w.hash.Write(data)
+		return plain, nil
+	}
+	if !w.onlyEncrypted {
+		w.hash.Write(sopsValueBytes(v))
+	}
+	return v, nil
+}
+
+// sopsValueBytes is a plain value as SOPS hashes it.
+func sopsValueBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+	case int:
+		return []byte(strconv.Itoa(v))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		if v {
+			return []byte("True")
+		}
+		return []byte("False")
+	case nil:
+		return nil
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+// sopsDecryptValue returns the typed value and the plaintext of an encrypted
+// value.
+func sopsDecryptValue(value string, key []byte, aad string) (interface{}, []byte, error) {
+	m := sopsValueRegexp.FindStringSubmatch(value)
+	data, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid sops data")
+	}
+	iv, err := base64.StdEncoding.DecodeString(m[2])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid sops iv")
+	}
+	tag, err := base64.StdEncoding.DecodeString(m[3])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid sops tag")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(aad))
+	if err != nil {
+		// never include the value itself, only where it lives
+		return nil, nil, errors.Errorf("failed to decrypt sops value at %q", strings.TrimSuffix(aad, ":"))
+	}
+	switch m[4] {
+	case "str", "bytes":
+		return string(plain), plain, nil
+	case "int":
+		if i, err := strconv.Atoi(string(plain)); err == nil {
+			return i, plain, nil
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(string(plain), 64); err == nil {
+			return f, plain, nil
+		}
+	case "bool":
+		return strings.EqualFold(string(plain), "true"), plain, nil
+	default:
+		return nil, nil, errors.Errorf("unknown sops value type %q", m[4])
+	}
+	return nil, nil, errors.Errorf("sops value at %q is not a valid %s", strings.TrimSuffix(aad, ":"), m[4])
+}
+
+// decryptValues returns data unchanged unless one of the decryptors detects it
+// as encrypted.
+func decryptValues(filePath string, data []byte, decryptors []ValuesDecryptor) ([]byte, error) {
+	for _, d := range decryptors {
+		if !d.Detect(data) {
+			continue
+		}
+		plain, err := d.Decrypt(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decrypt %s", filePath)
+		}
+		debug("decrypted values file %s", filePath)
+		return plain, nil
+	}
+	return data, nil
+}
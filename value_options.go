@@ -1,7 +1,15 @@
 package helmclient
 
 import (
+	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/strvals"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
 )
 
 type ValueOption struct {
@@ -20,6 +28,18 @@ func addValueOptions(opts []ValueOption, v *valueOptions) {
 	v.apply(opts)
 }
 
+// with returns a copy of the options with opts applied on top.
+func (o *valueOptions) with(opts []ValueOption) *valueOptions {
+	v := &valueOptions{
+		ValueFiles:   append([]string{}, o.ValueFiles...),
+		StringValues: append([]string{}, o.StringValues...),
+		Values:       append([]string{}, o.Values...),
+		FileValues:   append([]string{}, o.FileValues...),
+	}
+	v.apply(opts)
+	return v
+}
+
 func WithValueFiles(valueFiles []string) ValueOption {
 	return ValueOption{f: func(o *valueOptions) {
 		o.ValueFiles = valueFiles
@@ -43,3 +63,97 @@ func WithFileValues(fileValues []string) ValueOption {
 		o.FileValues = fileValues
 	}}
 }
+
+// mergeValues is values.Options.MergeValues with values files passed through
+// the decryptors before they are parsed. The decrypted content is only kept in
+// memory and never logged.
+func (o *valueOptions) mergeValues(p getter.Providers, decryptors []ValuesDecryptor) (map[string]interface{}, error) {
+	if len(decryptors) == 0 {
+		return (*values.Options)(o).MergeValues(p)
+	}
+	base := map[string]interface{}{}
+
+	// User specified a values files via -f/--values
+	for _, filePath := range o.ValueFiles {
+		currentMap := map[string]interface{}{}
+
+		bytes, err := readValuesFile(filePath, p)
+		if err != nil {
+			return nil, err
+		}
+		bytes, err = decryptValues(filePath, bytes, decryptors)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := yaml.Unmarshal(bytes, &currentMap); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", filePath)
+		}
+		// Merge with the previous map
+		base = mergeMaps(base, currentMap)
+	}
+
+	// User specified a value via --set
+	for _, value := range o.Values {
+		if err := strvals.ParseInto(value, base); err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set data")
+		}
+	}
+
+	// User specified a value via --set-string
+	for _, value := range o.StringValues {
+		if err := strvals.ParseIntoString(value, base); err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set-string data")
+		}
+	}
+
+	// User specified a value via --set-file
+	for _, value := range o.FileValues {
+		reader := func(rs []rune) (interface{}, error) {
+			bytes, err := readValuesFile(string(rs), p)
+			return string(bytes), err
+		}
+		if err := strvals.ParseIntoFile(value, base, reader); err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set-file data")
+		}
+	}
+
+	return base, nil
+}
+
+func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]interface{}); ok {
+			if bv, ok := out[k]; ok {
+				if bv, ok := bv.(map[string]interface{}); ok {
+					out[k] = mergeMaps(bv, v)
+					continue
+				}
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// readValuesFile load a file from stdin, the local directory, or a remote file with a url.
+func readValuesFile(filePath string, p getter.Providers) ([]byte, error) {
+	if strings.TrimSpace(filePath) == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	u, _ := url.Parse(filePath)
+
+	g, err := p.ByScheme(u.Scheme)
+	if err != nil {
+		return ioutil.ReadFile(filePath)
+	}
+	data, err := g.Get(filePath, getter.WithURL(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
@@ -58,7 +58,7 @@ func (c *versionClientImpl) OverrideGlobalOpts(globalOpts []GlobalOption) error
 }
 
 func (c *versionClientImpl) OverrideGlobalOptsWithNamespace(globalOpts []GlobalOption, namespace string) error {
-	env := rebuildEnv(globalOpts, namespace, c.env.clientGetter)
+	env := rebuildEnv(globalOpts, namespace, c.env)
 	c.env = env
 	return nil
 }